@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// cv for the properties of GO annotations
+const goAssociationCv = "gene_ontology_association"
+
+const goFeatureSQL = `
+SELECT feature_id FROM feature WHERE uniquename = $1
+UNION
+SELECT feature_dbxref.feature_id FROM feature_dbxref
+JOIN dbxref ON dbxref.dbxref_id = feature_dbxref.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE db.name = $2 AND dbxref.accession = $1
+LIMIT 1
+	`
+
+const goTermSQL = `
+SELECT cvterm.cvterm_id,cvterm.is_obsolete FROM cvterm
+JOIN dbxref ON dbxref.dbxref_id = cvterm.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE db.name = 'GO' AND dbxref.accession = $1
+ORDER BY cvterm.is_obsolete
+LIMIT 1
+	`
+
+const goPubSQL = `
+SELECT pub_id FROM pub WHERE uniquename = $1 OR uniquename = $2
+UNION
+SELECT pub_dbxref.pub_id FROM pub_dbxref
+JOIN dbxref ON dbxref.dbxref_id = pub_dbxref.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE db.name = $3 AND dbxref.accession = $2
+LIMIT 1
+	`
+
+const upsertFeatureCvterm = `
+INSERT INTO feature_cvterm (feature_id,cvterm_id,pub_id,is_not,rank)
+VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (feature_id,cvterm_id,pub_id,rank)
+DO UPDATE SET is_not = $4
+RETURNING feature_cvterm_id
+	`
+
+const upsertInternalCvterm = `
+INSERT INTO cvterm (cv_id,name,dbxref_id) VALUES ($1,$2,$3)
+ON CONFLICT (name,cv_id,is_obsolete)
+DO UPDATE SET name = EXCLUDED.name
+RETURNING cvterm_id
+	`
+
+const insertFeatureCvtermDbxref = `
+INSERT INTO feature_cvterm_dbxref (feature_cvterm_id,dbxref_id)
+VALUES ($1,$2)
+ON CONFLICT DO NOTHING
+	`
+
+func validateGenomeAnno(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	return nil
+}
+
+func GenomeAnnoAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "genome-annotations")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	files, err := listAnnotationFiles(c.String("folder"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type":   "dir-lookup",
+			"folder": c.String("folder"),
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if len(files) == 0 {
+		log.WithFields(logrus.Fields{
+			"type":   "dir-lookup",
+			"folder": c.String("folder"),
+		}).Warn("no annotation file to load")
+		return nil
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	tx, err := dbh.Begin()
+	if err != nil {
+		log.Errorf("error in starting transaction %s", err)
+		return cli.NewExitError(
+			fmt.Sprintf("error in starting transaction %s", err),
+			2,
+		)
+	}
+	defer tx.AutoRollback()
+	al, err := newAnnotationLoader(c, tx, log)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "annotation-loader",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	for _, f := range files {
+		af, err := readAnnotationFile(f)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "annotation-parser",
+				"file": f,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		if err := al.load(af); err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "annotation-loader",
+				"file": f,
+			}).Error(err)
+			if err == errCancelled {
+				return err
+			}
+			return cli.NewExitError(err.Error(), 2)
+		}
+	}
+	if err := commitTx(c, tx, al.report, log); err != nil {
+		if err == errCancelled {
+			return err
+		}
+		return cli.NewExitError(
+			fmt.Sprintf("error in commiting %s", err),
+			2,
+		)
+	}
+	for t, n := range al.rows {
+		addRowCount(c, t, n)
+	}
+	log.WithFields(logrus.Fields{
+		"type":     "annotation-loader",
+		"loaded":   al.rows["feature_cvterm"],
+		"rejected": len(al.rejects),
+	}).Info("loaded GO annotations")
+	if len(al.rejects) == 0 {
+		return nil
+	}
+	rfile, err := writeRejectReport(c, al.rejects)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "reject-report",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":     "reject-report",
+		"file":     rfile,
+		"rejected": len(al.rejects),
+	}).Warn("some annotations were rejected")
+	return nil
+}
+
+func listAnnotationFiles(folder string) ([]string, error) {
+	all, err := listFiles(folder)
+	if err != nil {
+		return all, err
+	}
+	var files []string
+	for _, f := range all {
+		name := strings.TrimSuffix(f, ".gz")
+		switch filepath.Ext(name) {
+		case ".gaf", ".gpad", ".gpa":
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// annotationReject is an annotation that could not be loaded
+type annotationReject struct {
+	File   string
+	Line   int
+	Reason string
+	Text   string
+}
+
+type annotationLoader struct {
+	c          *cli.Context
+	tx         *runner.Tx
+	log        *logrus.Logger
+	report     *changeReport
+	nullPub    int64
+	cvId       int64
+	internalDb int64
+	featureIds map[string]int64
+	termIds    map[string]int64
+	pubIds     map[string]int64
+	propIds    map[string]int64
+	ranks      map[string]int
+	rows       map[string]int64
+	rejects    []*annotationReject
+}
+
+func newAnnotationLoader(c *cli.Context, tx *runner.Tx, log *logrus.Logger) (*annotationLoader, error) {
+	al := &annotationLoader{
+		c:          c,
+		tx:         tx,
+		log:        log,
+		report:     newChangeReport(c),
+		featureIds: make(map[string]int64),
+		termIds:    make(map[string]int64),
+		pubIds:     make(map[string]int64),
+		propIds:    make(map[string]int64),
+		ranks:      make(map[string]int),
+		rows:       make(map[string]int64),
+	}
+	err := tx.Select("pub_id").From("pub").Where("uniquename = $1", "null").QueryScalar(&al.nullPub)
+	if err != nil {
+		if err == dat.ErrNotFound {
+			return al, fmt.Errorf("null pub is needed for annotations without any known reference")
+		}
+		return al, fmt.Errorf("error in looking up null pub %s", err)
+	}
+	if err := tx.SQL(upsertCv, goAssociationCv).QueryScalar(&al.cvId); err != nil {
+		return al, fmt.Errorf("error in finding or creating cv %s %s", goAssociationCv, err)
+	}
+	if err := tx.SQL(upsertDb, "internal").QueryScalar(&al.internalDb); err != nil {
+		return al, fmt.Errorf("error in finding or creating db internal %s", err)
+	}
+	return al, nil
+}
+
+func (al *annotationLoader) reject(file string, line int, text, reason string) {
+	al.rejects = append(al.rejects, &annotationReject{
+		File:   file,
+		Line:   line,
+		Reason: reason,
+		Text:   text,
+	})
+}
+
+func (al *annotationLoader) load(af *goAnnotationFile) error {
+	for _, r := range af.Rejects {
+		al.reject(af.Name, r.Line, r.Text, r.Reason)
+	}
+	for _, a := range af.Annotations {
+		if isCancelled(al.c) {
+			return errCancelled
+		}
+		featureId, ok, err := al.featureId(a)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			al.reject(af.Name, a.Line, a.Text, fmt.Sprintf("unknown gene %s:%s", a.DB, a.ObjectID))
+			continue
+		}
+		termId, reason, err := al.termId(a.GOID)
+		if err != nil {
+			return err
+		}
+		if len(reason) > 0 {
+			al.reject(af.Name, a.Line, a.Text, reason)
+			continue
+		}
+		pubId, err := al.pubId(a.References)
+		if err != nil {
+			return err
+		}
+		if err := al.loadAnnotation(a, featureId, termId, pubId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (al *annotationLoader) featureId(a *goAnnotation) (int64, bool, error) {
+	if id, ok := al.featureIds[a.ObjectID]; ok {
+		return id, id > 0, nil
+	}
+	var id int64
+	err := al.tx.SQL(goFeatureSQL, a.ObjectID, a.DB).QueryScalar(&id)
+	if err != nil && err != dat.ErrNotFound {
+		return id, false, fmt.Errorf("error in looking up gene %s %s", a.ObjectID, err)
+	}
+	al.featureIds[a.ObjectID] = id
+	return id, id > 0, nil
+}
+
+// termId returns the cvterm of the GO id, the reason is set when the term
+// could not be used
+func (al *annotationLoader) termId(goid string) (int64, string, error) {
+	if id, ok := al.termIds[goid]; ok {
+		if id == 0 {
+			return id, fmt.Sprintf("unknown GO term %s", goid), nil
+		}
+		if id < 0 {
+			return id, fmt.Sprintf("obsolete GO term %s", goid), nil
+		}
+		return id, "", nil
+	}
+	term := new(Cvterm)
+	err := al.tx.SQL(goTermSQL, strings.TrimPrefix(goid, "GO:")).QueryStruct(term)
+	if err != nil && err != dat.ErrNotFound {
+		return 0, "", fmt.Errorf("error in looking up GO term %s %s", goid, err)
+	}
+	switch {
+	case err == dat.ErrNotFound:
+		al.termIds[goid] = 0
+	case term.IsObsolete != 0:
+		al.termIds[goid] = -term.CvtermId
+	default:
+		al.termIds[goid] = term.CvtermId
+	}
+	return al.termId(goid)
+}
+
+// pubId returns the first reference that is a known publication, otherwise
+// the null pub
+func (al *annotationLoader) pubId(refs []string) (int64, error) {
+	for _, ref := range refs {
+		if id, ok := al.pubIds[ref]; ok {
+			if id > 0 {
+				return id, nil
+			}
+			continue
+		}
+		db, acc := ref, ref
+		if parts := strings.SplitN(ref, ":", 2); len(parts) == 2 {
+			db, acc = parts[0], parts[1]
+		}
+		var id int64
+		err := al.tx.SQL(goPubSQL, ref, acc, db).QueryScalar(&id)
+		if err != nil && err != dat.ErrNotFound {
+			return id, fmt.Errorf("error in looking up publication %s %s", ref, err)
+		}
+		al.pubIds[ref] = id
+		if id > 0 {
+			return id, nil
+		}
+	}
+	return al.nullPub, nil
+}
+
+func (al *annotationLoader) propId(name string) (int64, error) {
+	if id, ok := al.propIds[name]; ok {
+		return id, nil
+	}
+	var xid, id int64
+	if err := al.tx.SQL(upsertDbxref, al.internalDb, name).QueryScalar(&xid); err != nil {
+		return id, fmt.Errorf("error in finding or creating dbxref %s %s", name, err)
+	}
+	if err := al.tx.SQL(upsertInternalCvterm, al.cvId, name, xid).QueryScalar(&id); err != nil {
+		return id, fmt.Errorf("error in finding or creating cvterm %s %s", name, err)
+	}
+	al.propIds[name] = id
+	return id, nil
+}
+
+// loadAnnotation upserts the annotation and replaces its qualifiers and
+// references. Annotations of a gene with the same term and publication are
+// ranked in the order of the file.
+func (al *annotationLoader) loadAnnotation(a *goAnnotation, featureId, termId, pubId int64) error {
+	key := fmt.Sprintf("%d:%d:%d", featureId, termId, pubId)
+	rank := al.ranks[key]
+	al.ranks[key]++
+	var id int64
+	err := al.tx.SQL(upsertFeatureCvterm, featureId, termId, pubId, a.Not, rank).QueryScalar(&id)
+	if err != nil {
+		return fmt.Errorf("error in upserting annotation %s %s %s", a.ObjectID, a.GOID, err)
+	}
+	al.report.add("feature_cvterm", changeUpsert, 1)
+	al.report.sample("feature_cvterm", changeUpsert, map[string]interface{}{
+		"feature_cvterm_id": id,
+		"gene":              a.ObjectID,
+		"go":                a.GOID,
+		"evidence":          a.Evidence,
+	})
+	al.rows["feature_cvterm"]++
+	for _, t := range []string{"feature_cvtermprop", "feature_cvterm_dbxref"} {
+		res, err := al.tx.DeleteFrom(t).Where("feature_cvterm_id = $1", id).Exec()
+		if err != nil {
+			return fmt.Errorf("error in removing %s of annotation %s %s", t, a.ObjectID, err)
+		}
+		al.report.add(t, changeDelete, res.RowsAffected)
+	}
+	props := map[string][]string{
+		"evidence_code":        {a.Evidence},
+		"qualifier":            a.Qualifiers,
+		"with":                 a.With,
+		"date":                 {a.Date},
+		"assigned_by":          {a.AssignedBy},
+		"annotation_extension": a.Extensions,
+	}
+	for _, p := range []string{"evidence_code", "qualifier", "with", "date", "assigned_by", "annotation_extension"} {
+		for i, v := range props[p] {
+			if len(v) == 0 {
+				continue
+			}
+			typeId, err := al.propId(p)
+			if err != nil {
+				return err
+			}
+			_, err = al.tx.InsertInto("feature_cvtermprop").
+				Columns("feature_cvterm_id", "type_id", "value", "rank").
+				Values(id, typeId, v, i).
+				Exec()
+			if err != nil {
+				return fmt.Errorf("error in inserting %s of annotation %s %s", p, a.ObjectID, err)
+			}
+			al.report.add("feature_cvtermprop", changeInsert, 1)
+			al.rows["feature_cvtermprop"]++
+		}
+	}
+	for _, ref := range a.References {
+		parts := strings.SplitN(ref, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var dbId, xid int64
+		if err := al.tx.SQL(upsertDb, parts[0]).QueryScalar(&dbId); err != nil {
+			return fmt.Errorf("error in finding or creating db %s %s", parts[0], err)
+		}
+		if err := al.tx.SQL(upsertDbxref, dbId, parts[1]).QueryScalar(&xid); err != nil {
+			return fmt.Errorf("error in finding or creating dbxref %s %s", ref, err)
+		}
+		res, err := al.tx.SQL(insertFeatureCvtermDbxref, id, xid).Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting reference %s of annotation %s %s", ref, a.ObjectID, err)
+		}
+		al.report.add("feature_cvterm_dbxref", changeInsert, res.RowsAffected)
+		al.rows["feature_cvterm_dbxref"] += res.RowsAffected
+	}
+	return nil
+}
+
+// writeRejectReport writes the rejected annotations as a tab separated file,
+// by default in the local log folder so that it gets uploaded with the logs.
+// The last column is the original line of the annotation file.
+func writeRejectReport(c *cli.Context, rejects []*annotationReject) (string, error) {
+	file := c.String("reject-report")
+	if len(file) == 0 {
+		logf, err := getLogFileName(c, "genome-annotations-rejects")
+		if err != nil {
+			return file, err
+		}
+		file = strings.TrimSuffix(logf, ".log") + ".tsv"
+	}
+	w, err := os.Create(file)
+	if err != nil {
+		return file, fmt.Errorf("unable to create reject report %s", err)
+	}
+	defer w.Close()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# rejected annotations %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintln(bw, "file\tline\treason\tannotation")
+	for _, r := range rejects {
+		fmt.Fprintf(bw, "%s\t%d\t%s\t%s\n", r.File, r.Line, r.Reason, r.Text)
+	}
+	return file, bw.Flush()
+}
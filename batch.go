@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// batchTx splits a long running load in transactions of a given number of
+// records. A dry run keeps everything in a single transaction, as the later
+// records might depend on the earlier ones that are never committed.
+type batchTx struct {
+	c       *cli.Context
+	dbh     *runner.DB
+	log     *logrus.Logger
+	size    int
+	pending int
+	rows    map[string]int64
+	tx      *runner.Tx
+	report  *changeReport
+}
+
+func newBatchTx(c *cli.Context, dbh *runner.DB, log *logrus.Logger, size int) (*batchTx, error) {
+	b := &batchTx{
+		c:    c,
+		dbh:  dbh,
+		log:  log,
+		size: size,
+		rows: make(map[string]int64),
+	}
+	return b, b.begin()
+}
+
+func (b *batchTx) begin() error {
+	tx, err := b.dbh.Begin()
+	if err != nil {
+		return fmt.Errorf("error in starting transaction %s", err)
+	}
+	b.tx = tx
+	b.report = newChangeReport(b.c)
+	return nil
+}
+
+// rollback discards the current batch, it is meant to be deferred
+func (b *batchTx) rollback() {
+	if b.tx != nil {
+		b.tx.AutoRollback()
+	}
+}
+
+// add counts the rows of a table written in the current batch
+func (b *batchTx) add(table, kind string, count int64) {
+	b.report.add(table, kind, count)
+	if kind != changeDelete {
+		b.rows[table] += count
+	}
+}
+
+// done marks a record as written and commits the batch once it is full
+func (b *batchTx) done() error {
+	b.pending++
+	return b.commit(false)
+}
+
+// commit ends the current batch once it is full, or always when it is the
+// last one
+func (b *batchTx) commit(last bool) error {
+	if !last && (b.report.dryRun || b.pending < b.size) {
+		return nil
+	}
+	err := commitTx(b.c, b.tx, b.report, b.log)
+	b.tx = nil
+	if err != nil {
+		if err == errCancelled {
+			return err
+		}
+		return fmt.Errorf("error in commiting %s", err)
+	}
+	b.pending = 0
+	for t, n := range b.rows {
+		addRowCount(b.c, t, n)
+	}
+	b.rows = make(map[string]int64)
+	if last {
+		return nil
+	}
+	return b.begin()
+}
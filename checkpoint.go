@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkpoint keeps the list of completed substeps of a multi step import
+// for a particular input archive
+type checkpoint struct {
+	Command    string    `json:"command"`
+	RemotePath string    `json:"remote_path"`
+	Checksum   string    `json:"checksum"`
+	Completed  []string  `json:"completed"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	file       string
+}
+
+func checkpointFile(dir, command, remotePath string) string {
+	name := strings.Replace(strings.Trim(remotePath, "/"), "/", "_", -1)
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.json", command, name))
+}
+
+// newCheckpoint starts a fresh checkpoint discarding any earlier one
+func newCheckpoint(dir, command, remotePath, checksum string) (*checkpoint, error) {
+	cp := &checkpoint{
+		Command:    command,
+		RemotePath: remotePath,
+		Checksum:   checksum,
+		Completed:  make([]string, 0),
+		file:       checkpointFile(dir, command, remotePath),
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return cp, fmt.Errorf("unable to create checkpoint folder %s %s", dir, err)
+	}
+	return cp, cp.save()
+}
+
+// resumeCheckpoint reads an earlier checkpoint, a fresh one is started if
+// none exists. It is an error to resume with an archive different from the
+// one of the checkpoint.
+func resumeCheckpoint(dir, command, remotePath, checksum string) (*checkpoint, bool, error) {
+	file := checkpointFile(dir, command, remotePath)
+	ct, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			cp, err := newCheckpoint(dir, command, remotePath, checksum)
+			return cp, false, err
+		}
+		return nil, false, fmt.Errorf("unable to read checkpoint file %s %s", file, err)
+	}
+	cp := &checkpoint{file: file}
+	if err := json.Unmarshal(ct, cp); err != nil {
+		return nil, false, fmt.Errorf("unable to decode checkpoint file %s %s", file, err)
+	}
+	if cp.Checksum != checksum {
+		return nil, false, fmt.Errorf(
+			"archive %s has changed since the checkpoint of %s(checksum %s, expected %s), refusing to resume",
+			remotePath, cp.UpdatedAt.Format(time.RFC3339), checksum, cp.Checksum,
+		)
+	}
+	return cp, true, nil
+}
+
+func (cp *checkpoint) isDone(substep string) bool {
+	for _, s := range cp.Completed {
+		if s == substep {
+			return true
+		}
+	}
+	return false
+}
+
+func (cp *checkpoint) markDone(substep string) error {
+	if cp.isDone(substep) {
+		return nil
+	}
+	cp.Completed = append(cp.Completed, substep)
+	return cp.save()
+}
+
+func (cp *checkpoint) save() error {
+	cp.UpdatedAt = time.Now()
+	ct, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename, so that the checkpoint file is
+	// never left half written
+	tmp := cp.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, ct, 0644); err != nil {
+		return fmt.Errorf("unable to write checkpoint file %s %s", tmp, err)
+	}
+	if err := os.Rename(tmp, cp.file); err != nil {
+		return fmt.Errorf("unable to rename checkpoint file %s %s", tmp, err)
+	}
+	return nil
+}
+
+func fileChecksum(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file %s %s", file, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("unable to compute checksum of %s %s", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// number of cvtermpath rows in a single insert
+const closureChunk = 1000
+
+const cvIdSQL = `
+SELECT cv_id FROM cv WHERE name = $1
+	`
+
+// the relationship types are given by the accession of their dbxref, as
+// their names are labels such as "part of"
+const closureEdgeSQL = `
+SELECT cvterm_relationship.subject_id,cvterm_relationship.object_id,
+	cvterm_relationship.type_id,dbxref.accession,db.name AS db
+FROM cvterm_relationship
+JOIN cvterm subject ON subject.cvterm_id = cvterm_relationship.subject_id
+JOIN cv ON cv.cv_id = subject.cv_id
+JOIN cvterm rtype ON rtype.cvterm_id = cvterm_relationship.type_id
+JOIN dbxref ON dbxref.dbxref_id = rtype.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE cv.name = $1
+	`
+
+// the relations of the closure unless given otherwise
+var defaultClosureRelations = []string{"is_a", "part_of"}
+
+// closureEdge is a relationship of the cv, the type is the relation it is
+// matched to
+type closureEdge struct {
+	SubjectID int64  `db:"subject_id"`
+	ObjectID  int64  `db:"object_id"`
+	TypeID    int64  `db:"type_id"`
+	Accession string `db:"accession"`
+	Db        string `db:"db"`
+	Type      string
+}
+
+// closurePath is a row of cvtermpath
+type closurePath struct {
+	subject  int64
+	object   int64
+	relation string
+	distance int
+}
+
+type closureState struct {
+	node     int64
+	relation string
+}
+
+// closureResult is the outcome of the closure of a cv, every cycle is
+// given by the ids of its terms
+type closureResult struct {
+	Cv     string
+	Paths  int64
+	Cycles [][]string
+}
+
+// closureRelations returns the relations given by the flag or the default
+// ones
+func closureRelations(c *cli.Context, flag string) []string {
+	if rels := c.StringSlice(flag); len(rels) > 0 {
+		return rels
+	}
+	return defaultClosureRelations
+}
+
+// closureEdges keeps the edges of the relations, a relation is given either
+// by the accession of its type, such as part_of, or by the full id, such as
+// BFO:0000050
+func closureEdges(all []*closureEdge, relations []string) []*closureEdge {
+	wanted := make(map[string]bool)
+	for _, r := range relations {
+		wanted[r] = true
+	}
+	var edges []*closureEdge
+	for _, e := range all {
+		switch {
+		case wanted[e.Accession]:
+			e.Type = e.Accession
+		case wanted[e.Db+":"+e.Accession]:
+			e.Type = e.Db + ":" + e.Accession
+		default:
+			continue
+		}
+		edges = append(edges, e)
+	}
+	return edges
+}
+
+// composeRelation is the relation of a path extended by an edge, is_a
+// takes the relation of the other side and a path of two different
+// relations other than is_a is not inferred
+func composeRelation(path, edge string) string {
+	switch {
+	case path == "is_a":
+		return edge
+	case edge == "is_a" || edge == path:
+		return path
+	}
+	return ""
+}
+
+// transitiveClosure returns the shortest path of every relation from each
+// term to its ancestors, a term is never a path of itself
+func transitiveClosure(edges []*closureEdge) []*closurePath {
+	graph := make(map[int64][]*closureEdge)
+	for _, e := range edges {
+		graph[e.SubjectID] = append(graph[e.SubjectID], e)
+	}
+	var subjects []int64
+	for s := range graph {
+		subjects = append(subjects, s)
+	}
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i] < subjects[j] })
+	var paths []*closurePath
+	for _, s := range subjects {
+		seen := make(map[closureState]bool)
+		var queue []*closurePath
+		for _, e := range graph[s] {
+			st := closureState{e.ObjectID, e.Type}
+			if !seen[st] {
+				seen[st] = true
+				queue = append(queue, &closurePath{s, e.ObjectID, e.Type, 1})
+			}
+		}
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			if p.object == s {
+				continue
+			}
+			paths = append(paths, p)
+			for _, e := range graph[p.object] {
+				rel := composeRelation(p.relation, e.Type)
+				if len(rel) == 0 {
+					continue
+				}
+				st := closureState{e.ObjectID, rel}
+				if seen[st] {
+					continue
+				}
+				seen[st] = true
+				queue = append(queue, &closurePath{s, e.ObjectID, rel, p.distance + 1})
+			}
+		}
+	}
+	return paths
+}
+
+// closureCycles returns the strongly connected components of the graph
+// that are cycles, including the terms related to themselves
+func closureCycles(edges []*closureEdge) [][]int64 {
+	graph := make(map[int64][]int64)
+	self := make(map[int64]bool)
+	var nodes []int64
+	for _, e := range edges {
+		if _, ok := graph[e.SubjectID]; !ok {
+			nodes = append(nodes, e.SubjectID)
+		}
+		graph[e.SubjectID] = append(graph[e.SubjectID], e.ObjectID)
+		if e.SubjectID == e.ObjectID {
+			self[e.SubjectID] = true
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	index := make(map[int64]int)
+	low := make(map[int64]int)
+	onStack := make(map[int64]bool)
+	var stack []int64
+	var cycles [][]int64
+	var connect func(n int64)
+	connect = func(n int64) {
+		index[n] = len(index)
+		low[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, m := range graph[n] {
+			if _, ok := index[m]; !ok {
+				connect(m)
+				if low[m] < low[n] {
+					low[n] = low[m]
+				}
+			} else if onStack[m] && index[m] < low[n] {
+				low[n] = index[m]
+			}
+		}
+		if low[n] != index[n] {
+			return
+		}
+		var comp []int64
+		for {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[m] = false
+			comp = append(comp, m)
+			if m == n {
+				break
+			}
+		}
+		if len(comp) > 1 || self[n] {
+			sort.Slice(comp, func(i, j int) bool { return comp[i] < comp[j] })
+			cycles = append(cycles, comp)
+		}
+	}
+	for _, n := range nodes {
+		if _, ok := index[n]; !ok {
+			connect(n)
+		}
+	}
+	return cycles
+}
+
+// loadClosure replaces the cvtermpath of a cv with the transitive closure
+// of its relations
+func loadClosure(c *cli.Context, dbh *runner.DB, log *logrus.Logger, cv string, relations []string) (*closureResult, error) {
+	res := &closureResult{Cv: cv}
+	tx, err := dbh.Begin()
+	if err != nil {
+		return res, fmt.Errorf("error in starting transaction %s", err)
+	}
+	defer tx.AutoRollback()
+	var cvId int64
+	if err := tx.SQL(cvIdSQL, cv).QueryScalar(&cvId); err != nil {
+		if err == dat.ErrNotFound {
+			return res, fmt.Errorf("cv %s is not loaded", cv)
+		}
+		return res, fmt.Errorf("error in looking up cv %s %s", cv, err)
+	}
+	var all []*closureEdge
+	err = tx.SQL(closureEdgeSQL, cv).QueryStructs(&all)
+	if err != nil && err != dat.ErrNotFound {
+		return res, fmt.Errorf("error in querying relationships of %s %s", cv, err)
+	}
+	edges := closureEdges(all, relations)
+	typeIds := make(map[string]int64)
+	for _, e := range edges {
+		if _, ok := typeIds[e.Type]; !ok {
+			typeIds[e.Type] = e.TypeID
+		}
+	}
+	if cycles := closureCycles(edges); len(cycles) > 0 {
+		terms, err := readChadoTerms(tx, cv)
+		if err != nil {
+			return res, err
+		}
+		ids := make(map[int64]string)
+		for id, t := range terms {
+			ids[t.CvtermID] = id
+		}
+		for _, cyc := range cycles {
+			var names []string
+			for _, n := range cyc {
+				names = append(names, ids[n])
+			}
+			res.Cycles = append(res.Cycles, names)
+			log.WithFields(logrus.Fields{
+				"type":  "ontology-closure",
+				"kind":  "cycle",
+				"cv":    cv,
+				"terms": strings.Join(names, ","),
+			}).Warn("cycle in the relationships of the cv")
+		}
+	}
+	paths := transitiveClosure(edges)
+	report := newChangeReport(c)
+	dres, err := tx.DeleteFrom("cvtermpath").Where("cv_id = $1", cvId).Exec()
+	if err != nil {
+		return res, fmt.Errorf("error in removing cvtermpath of %s %s", cv, err)
+	}
+	report.add("cvtermpath", changeDelete, dres.RowsAffected)
+	for i := 0; i < len(paths); i += closureChunk {
+		if isCancelled(c) {
+			return res, errCancelled
+		}
+		end := i + closureChunk
+		if end > len(paths) {
+			end = len(paths)
+		}
+		ib := tx.InsertInto("cvtermpath").
+			Columns("type_id", "subject_id", "object_id", "cv_id", "pathdistance")
+		for _, p := range paths[i:end] {
+			ib = ib.Values(typeIds[p.relation], p.subject, p.object, cvId, p.distance)
+		}
+		if _, err := ib.Exec(); err != nil {
+			return res, fmt.Errorf("error in inserting cvtermpath of %s %s", cv, err)
+		}
+		report.add("cvtermpath", changeInsert, int64(end-i))
+	}
+	if err := commitTx(c, tx, report, log); err != nil {
+		if err == errCancelled {
+			return res, err
+		}
+		return res, fmt.Errorf("error in commiting %s", err)
+	}
+	res.Paths = int64(len(paths))
+	addRowCount(c, "cvtermpath", res.Paths)
+	log.WithFields(logrus.Fields{
+		"type":      "ontology-closure",
+		"kind":      "loading-success",
+		"cv":        cv,
+		"relations": strings.Join(relations, ","),
+		"edges":     len(edges),
+		"paths":     res.Paths,
+		"cycles":    len(res.Cycles),
+	}).Info("loaded transitive closure")
+	return res, nil
+}
+
+// ontologyCvs returns the cvs of the terms of an ontology
+func ontologyCvs(doc *oboDocument) []string {
+	var cvs []string
+	seen := make(map[string]bool)
+	for _, t := range doc.Terms {
+		cv := doc.TermNamespace(t)
+		if !seen[cv] {
+			seen[cv] = true
+			cvs = append(cvs, cv)
+		}
+	}
+	return cvs
+}
+
+// closureAfterLoad computes the closure of the cvs of a loaded ontology
+// when it is asked for
+func closureAfterLoad(c *cli.Context, dbh *runner.DB, log *logrus.Logger, doc *oboDocument) error {
+	if !c.Bool("closure") || doc == nil {
+		return nil
+	}
+	for _, cv := range ontologyCvs(doc) {
+		if _, err := loadClosure(c, dbh, log, cv, closureRelations(c, "closure-relation")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateOntoClosure(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(c.StringSlice("cv")) == 0 {
+		return cli.NewExitError("at least one cv is needed", 2)
+	}
+	return nil
+}
+
+func OntoClosureAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "onto-closure")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	relations := closureRelations(c, "relation")
+	for _, cv := range c.StringSlice("cv") {
+		res, err := loadClosure(c, dbh, log, cv, relations)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "ontology-closure",
+				"kind": "loading-issue",
+				"cv":   cv,
+			}).Error(err)
+			if err == errCancelled {
+				return err
+			}
+			return cli.NewExitError(err.Error(), 2)
+		}
+		fmt.Fprintf(c.App.Writer, "cv %s, %d paths, %d cycles\n", res.Cv, res.Paths, len(res.Cycles))
+		for _, cyc := range res.Cycles {
+			fmt.Fprintf(c.App.Writer, "cycle %s\n", strings.Join(cyc, ","))
+		}
+	}
+	return nil
+}
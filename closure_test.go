@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testEdge(subject, object int64, relation string) *closureEdge {
+	return &closureEdge{SubjectID: subject, ObjectID: object, Type: relation}
+}
+
+// pathStrings gives the paths as sorted subject relation object distance
+func pathStrings(paths []*closurePath) []string {
+	var s []string
+	for _, p := range paths {
+		s = append(s, fmt.Sprintf("%d %s %d %d", p.subject, p.relation, p.object, p.distance))
+	}
+	sort.Strings(s)
+	return s
+}
+
+func TestClosureEdges(t *testing.T) {
+	all := []*closureEdge{
+		{SubjectID: 1, ObjectID: 2, Accession: "is_a", Db: "OBO_REL"},
+		{SubjectID: 2, ObjectID: 3, Accession: "part_of", Db: "_global"},
+		{SubjectID: 3, ObjectID: 4, Accession: "0000050", Db: "BFO"},
+		{SubjectID: 4, ObjectID: 5, Accession: "regulates", Db: "_global"},
+	}
+	var types []string
+	for _, e := range closureEdges(all, []string{"is_a", "part_of", "BFO:0000050"}) {
+		types = append(types, e.Type)
+	}
+	if expected := []string{"is_a", "part_of", "BFO:0000050"}; !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected the edges of %v, got %v", expected, types)
+	}
+}
+
+func TestTransitiveClosure(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		edges    []*closureEdge
+		expected []string
+	}{
+		{
+			name: "is_a and part_of",
+			edges: []*closureEdge{
+				testEdge(1, 2, "is_a"),
+				testEdge(2, 3, "part_of"),
+				testEdge(3, 4, "is_a"),
+				testEdge(4, 5, "part_of"),
+			},
+			expected: []string{
+				"1 is_a 2 1", "1 part_of 3 2", "1 part_of 4 3", "1 part_of 5 4",
+				"2 part_of 3 1", "2 part_of 4 2", "2 part_of 5 3",
+				"3 is_a 4 1", "3 part_of 5 2",
+				"4 part_of 5 1",
+			},
+		},
+		{
+			name: "different relations are not composed",
+			edges: []*closureEdge{
+				testEdge(1, 2, "part_of"),
+				testEdge(2, 3, "develops_from"),
+			},
+			expected: []string{"1 part_of 2 1", "2 develops_from 3 1"},
+		},
+		{
+			name: "shortest path of every relation",
+			edges: []*closureEdge{
+				testEdge(1, 2, "is_a"),
+				testEdge(1, 2, "part_of"),
+				testEdge(2, 3, "is_a"),
+				testEdge(1, 3, "is_a"),
+			},
+			expected: []string{"1 is_a 2 1", "1 is_a 3 1", "1 part_of 2 1", "1 part_of 3 2", "2 is_a 3 1"},
+		},
+		{
+			name: "cycles",
+			edges: []*closureEdge{
+				testEdge(1, 2, "is_a"),
+				testEdge(2, 3, "is_a"),
+				testEdge(3, 1, "is_a"),
+				testEdge(4, 4, "is_a"),
+				testEdge(5, 1, "is_a"),
+			},
+			expected: []string{
+				"1 is_a 2 1", "1 is_a 3 2",
+				"2 is_a 1 2", "2 is_a 3 1",
+				"3 is_a 1 1", "3 is_a 2 2",
+				"5 is_a 1 1", "5 is_a 2 2", "5 is_a 3 3",
+			},
+		},
+	} {
+		if paths := pathStrings(transitiveClosure(tc.edges)); !reflect.DeepEqual(paths, tc.expected) {
+			t.Errorf("%s: expected paths %v, got %v", tc.name, tc.expected, paths)
+		}
+	}
+}
+
+func TestClosureCycles(t *testing.T) {
+	cycles := closureCycles([]*closureEdge{
+		testEdge(1, 2, "is_a"),
+		testEdge(2, 3, "part_of"),
+		testEdge(3, 1, "is_a"),
+		testEdge(4, 4, "is_a"),
+		testEdge(5, 1, "is_a"),
+		testEdge(6, 7, "is_a"),
+	})
+	if expected := [][]int64{{1, 2, 3}, {4}}; !reflect.DeepEqual(cycles, expected) {
+		t.Fatalf("expected cycles %v, got %v", expected, cycles)
+	}
+	if cycles := closureCycles([]*closureEdge{testEdge(1, 2, "is_a"), testEdge(2, 3, "is_a")}); len(cycles) != 0 {
+		t.Fatalf("expected no cycle, got %v", cycles)
+	}
+}
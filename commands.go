@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/urfave/cli.v1"
 )
@@ -32,16 +33,16 @@ func main() {
 			EnvVar: "SLACK_CHANNEL",
 			Usage:  "Slack channel where the log will be posted",
 		},
-		cli.StringFlag{
+		secretFlag(cli.StringFlag{
 			Name:   "slack-url",
 			EnvVar: "SLACK_URL",
 			Usage:  "Slack webhook url[required if slack channel is provided]",
-		},
-		cli.StringFlag{
+		}),
+		secretFlag(cli.StringFlag{
 			Name:   "chado-pass",
 			EnvVar: "CHADO_PASS",
 			Usage:  "chado database password",
-		},
+		}),
 		cli.StringFlag{
 			Name:   "chado-db",
 			EnvVar: "CHADO_DB",
@@ -72,16 +73,16 @@ func main() {
 			Usage: "S3 bucket where the import data is kept",
 			Value: "dictybase",
 		},
-		cli.StringFlag{
+		secretFlag(cli.StringFlag{
 			Name:   "access-key, akey",
 			EnvVar: "S3_ACCESS_KEY",
 			Usage:  "access key for S3 server, required based on command run",
-		},
-		cli.StringFlag{
+		}),
+		secretFlag(cli.StringFlag{
 			Name:   "secret-key, skey",
 			EnvVar: "S3_SECRET_KEY",
 			Usage:  "secret key for S3 server, required based on command run",
-		},
+		}),
 		cli.BoolFlag{
 			Name:   "use-logfile",
 			EnvVar: "USE_LOG_FILE",
@@ -93,18 +94,72 @@ func main() {
 			Value:  "/log",
 			Usage:  "local log folder",
 		},
+		cli.StringFlag{
+			Name:   "etcd-host",
+			EnvVar: "ETCD_CLIENT_SERVICE_HOST",
+			Usage:  "ip address of etcd instance",
+		},
+		cli.StringFlag{
+			Name:   "etcd-port",
+			EnvVar: "ETCD_CLIENT_SERVICE_PORT",
+			Usage:  "port number of etcd instance",
+		},
+		cli.StringFlag{
+			Name:   "key-dir",
+			EnvVar: "KEY_DIR",
+			Usage:  "local folder to keep the coordination keys, used when etcd is not given",
+		},
+		cli.StringSliceFlag{
+			Name:  "key-watch",
+			Usage: "key(s) to watch before start loading",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringSliceFlag{
+			Name:  "key-register",
+			Usage: "key(s) to register after successful loading",
+			Value: &cli.StringSlice{},
+		},
+		cli.DurationFlag{
+			Name:  "key-timeout",
+			Usage: "maximum time to wait for the watched keys, zero waits forever",
+			Value: 30 * time.Minute,
+		},
+		cli.StringFlag{
+			Name:   "notify-channel",
+			EnvVar: "NOTIFY_CHANNEL",
+			Usage:  "postgresql channel where the completion of every command is notified",
+			Value:  "migration_import",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "perform all the work, report the changes and roll back instead of commiting",
+		},
+		cli.DurationFlag{
+			Name:  "lock-timeout",
+			Usage: "maximum time to wait for the import locks held by another run, zero gives up right away without waiting",
+			Value: 5 * time.Minute,
+		},
+		cli.BoolFlag{
+			Name:  "migration-lock",
+			Usage: "hold the migration lock exclusively to keep out every other import",
+		},
+		cli.DurationFlag{
+			Name:  "grace-period",
+			Usage: "time given to the external loaders to stop after a SIGTERM before they are killed",
+			Value: 10 * time.Second,
+		},
 	}
 	app.Commands = []cli.Command{
 		{
 			Name:   "organism",
 			Usage:  "Import organism",
-			Action: OrganismAction,
+			Action: importAction(OrganismAction),
 			Before: validateOrganism,
 		},
 		{
 			Name:   "organism-plus",
 			Usage:  "Import additional organisms tied to stocks in stock center",
-			Action: OrganismPlusAction,
+			Action: importAction(OrganismPlusAction),
 			Before: validateOrganismPlus,
 			Flags: []cli.Flag{
 				cli.StringFlag{
@@ -117,7 +172,47 @@ func main() {
 		{
 			Name:   "onto",
 			Usage:  "Import one or more ontologies",
-			Action: ontoAction,
+			Action: parentAction("onto", importAction(ontoAction)),
+			Subcommands: []cli.Command{
+				{
+					Name:   "diff",
+					Usage:  "Report the changes of an incoming ontology against the terms of its cv",
+					Before: validateOntoDiff,
+					Action: OntoDiffAction,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "file",
+							Usage: "file of the incoming ontology, either of obo, json(obographs) or owl",
+						},
+						cli.StringFlag{
+							Name:  "cv",
+							Usage: "cv to compare with, by default it is the namespace of the ontology",
+						},
+						cli.StringFlag{
+							Name:  "json",
+							Usage: "file for the report in json, - writes it to standard output instead of the text report",
+						},
+					},
+				},
+				{
+					Name:   "closure",
+					Usage:  "Load the transitive closure of the relationships of cvs in cvtermpath",
+					Before: validateOntoClosure,
+					Action: importAction(OntoClosureAction),
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "cv",
+							Usage: "cv to compute the closure of",
+							Value: &cli.StringSlice{},
+						},
+						cli.StringSliceFlag{
+							Name:  "relation",
+							Usage: "relation to follow, given by the accession of its type such as part_of or as BFO:0000050, by default is_a and part_of",
+							Value: &cli.StringSlice{},
+						},
+					},
+				},
+			},
 			Flags: []cli.Flag{
 				cli.BoolFlag{
 					Name:  "github, gh",
@@ -127,24 +222,103 @@ func main() {
 					Name:  "purl",
 					Usage: "Flag to retrieve all ontology files using purl url",
 				},
+				cli.StringFlag{
+					Name:  "obo-dir",
+					Usage: "local folder to read the ontology files from",
+				},
+				cli.StringFlag{
+					Name:  "s3",
+					Usage: "folder in the S3 bucket to read the ontology files from",
+				},
+				cli.StringSliceFlag{
+					Name:  "source",
+					Usage: "source of a single ontology given as name=source, source is either of purl, github, dir or s3",
+					Value: &cli.StringSlice{},
+				},
 				cli.StringSliceFlag{
 					Name:  "obo",
 					Usage: "Name on ontologies to load",
 					Value: &cli.StringSlice{},
 				},
+				cli.StringFlag{
+					Name:   "registry",
+					Usage:  "json file mapping the ontologies to their source, format and cv, the builtin one reads from the dictyBase github repositories",
+					EnvVar: "ONTOLOGY_REGISTRY",
+				},
+				secretFlag(cli.StringFlag{
+					Name:   "gh-token",
+					Usage:  "github token to fetch the ontologies with, avoids the rate limit of anonymous requests",
+					EnvVar: "GITHUB_TOKEN",
+				}),
+				cli.StringSliceFlag{
+					Name:  "gh-ref",
+					Usage: "branch, tag or commit to fetch the ontologies from github, given as name=ref for a single ontology",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringFlag{
+					Name:   "cache-dir",
+					Usage:  "folder to cache the ontologies fetched from purl and github, kept on the data volume between runs",
+					EnvVar: "ONTOLOGY_CACHE_DIR",
+					Value:  "/data/ontology-cache",
+				},
+				cli.BoolFlag{
+					Name:  "offline",
+					Usage: "serve the ontologies of purl and github from the cache only",
+				},
+				cli.BoolFlag{
+					Name:  "closure",
+					Usage: "load the transitive closure of the cvs in cvtermpath after every ontology",
+				},
+				cli.StringSliceFlag{
+					Name:  "closure-relation",
+					Usage: "relation to follow for the closure, given by the accession of its type, by default is_a and part_of",
+					Value: &cli.StringSlice{},
+				},
+				cli.BoolFlag{
+					Name:  "fetch-dependencies",
+					Usage: "fetch the ontologies that provide the missing terms referred by the loaded ones",
+				},
+				cli.StringSliceFlag{
+					Name:  "format",
+					Usage: "format of the ontologies, either of obo, json(obographs) or owl(functional syntax), given as name=format for a single ontology",
+					Value: &cli.StringSlice{},
+				},
+				cli.BoolFlag{
+					Name:  "force",
+					Usage: "reload the ontologies even when their version is the same as the loaded one",
+				},
+				cli.BoolFlag{
+					Name:  "obo2chado",
+					Usage: "load through the obo2chado commands of modware-load instead of the builtin loader",
+				},
+				cli.BoolFlag{
+					Name:  "migrate-obsolete",
+					Usage: "move the stock annotations and phenotypes of newly obsoleted terms to their replaced_by term",
+				},
 			},
 			Before: validateOnto,
 		},
 		{
 			Name:   "genomes",
 			Usage:  "Import all genomes",
-			Action: GenomesAction,
+			Action: importAction(GenomesAction),
+			Before: validateGenomes,
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "folder",
 					Usage: "data folder",
 					Value: "/data/gff3",
 				},
+				cli.StringFlag{
+					Name:  "organism",
+					Usage: "genus and species of the organism the genomes belong to",
+					Value: "Dictyostelium discoideum",
+				},
+				cli.IntFlag{
+					Name:  "batch-size",
+					Usage: "number of features written in a single transaction",
+					Value: 1000,
+				},
 				cli.StringFlag{
 					Name:  "key-watch",
 					Usage: "key to watch before loading genomes",
@@ -159,21 +333,60 @@ func main() {
 		},
 		{
 			Name:   "genome-annotations",
-			Usage:  "Import all genome annotations",
-			Action: GenomeAnnoAction,
+			Usage:  "Import GO annotations of genes from GAF or GPAD files",
+			Action: importAction(GenomeAnnoAction),
+			Before: validateGenomeAnno,
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "folder",
 					Usage: "data folder",
 					Value: "/data/stockcenter",
 				},
+				cli.StringFlag{
+					Name:  "reject-report",
+					Usage: "file for the annotations that could not be loaded, by default it is kept in the log folder",
+				},
+			},
+		},
+		{
+			Name:   "sequences",
+			Usage:  "Load residues of features from FASTA files",
+			Action: importAction(SequencesAction),
+			Before: validateSequences,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "folder",
+					Usage: "data folder with FASTA files, plain or gzipped",
+				},
+				cli.StringFlag{
+					Name:  "remote-path, rp",
+					Usage: "full path(relative to the bucket) of s3 object which will be download, either a FASTA file or a tarball of them",
+				},
+				cli.StringFlag{
+					Name:  "id-pattern",
+					Usage: "regular expression with a single group to extract the feature uniquename from the FASTA header",
+				},
+				cli.StringFlag{
+					Name:  "feature-type",
+					Usage: "match only the features of this type",
+				},
+				cli.StringFlag{
+					Name:  "molecule",
+					Usage: "type of the sequences, either of dna or protein, only dna is checked against the feature extents",
+					Value: "dna",
+				},
+				cli.IntFlag{
+					Name:  "batch-size",
+					Usage: "number of sequences written in a single transaction",
+					Value: 100,
+				},
 			},
 		},
 		{
 			Name:   "literature",
 			Usage:  "Import literature",
-			Action: LiteratureAction,
-			Before: validateCommon,
+			Action: importAction(LiteratureAction),
+			Before: validateLiterature,
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "remote-path, rp",
@@ -185,8 +398,8 @@ func main() {
 		{
 			Name:   "stock-center",
 			Usage:  "Import all data related to stock center",
-			Before: validateCommon,
-			Action: ScAction,
+			Before: validateSc,
+			Action: importAction(ScAction),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "remote-path, rp",
@@ -197,13 +410,23 @@ func main() {
 					Name:  "prune",
 					Usage: "clean all dsc records before loading",
 				},
+				cli.BoolFlag{
+					Name:  "resume",
+					Usage: "skip the substeps that were completed by an earlier run of the same archive",
+				},
+				cli.StringFlag{
+					Name:   "checkpoint-dir",
+					EnvVar: "CHECKPOINT_DIR",
+					Usage:  "local folder where the progress of substeps is kept",
+					Value:  "/log/checkpoint",
+				},
 			},
 		},
 		{
 			Name:   "users",
 			Usage:  "load all dictybase users(colleagues)",
 			Before: validateCommon,
-			Action: userAction,
+			Action: importAction(userAction),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "remote-path, rp",
@@ -215,7 +438,7 @@ func main() {
 		{
 			Name:   "upload-log",
 			Usage:  "Upload all log files(compressed) to a s3 bucket",
-			Action: UploadLogAction,
+			Action: coordinate(UploadLogAction),
 			Before: validateUploadLog,
 			Flags: []cli.Flag{
 				cli.StringFlag{
@@ -230,7 +453,7 @@ func main() {
 			Name:   "stock-center-orders",
 			Usage:  "Import all strains and plasmid orders",
 			Before: validateCommon,
-			Action: ScOrderAction,
+			Action: importAction(ScOrderAction),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "remote-path, rp",
@@ -243,25 +466,25 @@ func main() {
 			Name:   "tag-inventory",
 			Usage:  "Add an ontology term to model the availability of items in dsc",
 			Before: validateCommon,
-			Action: TagInventoryAction,
+			Action: importAction(TagInventoryAction),
 		},
 		{
 			Name:   "plasmid-prefix",
 			Usage:  "Add lower case p to missing plasmid names",
 			Before: validateCommon,
-			Action: PrefixPlasmidAction,
+			Action: importAction(PrefixPlasmidAction),
 		},
 		{
 			Name:   "bacterial-strain",
 			Usage:  "Separate bacterial strains from ameoba strains",
 			Before: validateCommon,
-			Action: BacterialStrainAction,
+			Action: importAction(BacterialStrainAction),
 		},
 		{
 			Name:   "annotation-assignments",
 			Usage:  "Assign user assignments to strain and plasmid annotations",
 			Before: validateCommon,
-			Action: LoadAnnotationAssignment,
+			Action: importAction(LoadAnnotationAssignment),
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "remote-path, rp",
@@ -270,6 +493,160 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "pipeline",
+			Usage:  "Run all import subcommands in the order of their dependencies",
+			Before: validatePipeline,
+			Action: PipelineAction,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "only",
+					Usage: "run only the given step(s), could be repeated",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "run the given step and all the steps that depend on it",
+				},
+				cli.IntFlag{
+					Name:  "max-parallel",
+					Usage: "maximum number of independent steps to run at the same time",
+					Value: 1,
+				},
+			},
+		},
+		{
+			Name:   "run",
+			Usage:  "Run the import steps described in a manifest file",
+			Before: validateRunManifest,
+			Action: RunManifestAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "manifest, m",
+					Usage: "json file describing the steps, their inputs and dependencies",
+				},
+				cli.StringSliceFlag{
+					Name:  "only",
+					Usage: "run only the given step(s), could be repeated",
+					Value: &cli.StringSlice{},
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "run the given step and all the steps that depend on it",
+				},
+				cli.IntFlag{
+					Name:  "max-parallel",
+					Usage: "maximum number of independent steps to run at the same time",
+					Value: 1,
+				},
+			},
+		},
+		{
+			Name:   "history",
+			Usage:  "List the import runs recorded in the database",
+			Before: validateHistory,
+			Action: HistoryAction,
+			Flags: []cli.Flag{
+				cli.Int64Flag{
+					Name:  "id",
+					Usage: "show the details of a single import run",
+				},
+				cli.StringFlag{
+					Name:  "command",
+					Usage: "list only the runs of this command",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Usage: "maximum number of runs to list",
+					Value: 20,
+				},
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "Export data from the database",
+			Subcommands: []cli.Command{
+				{
+					Name:   "genome",
+					Usage:  "Export the features of an organism as GFF3 and FASTA",
+					Before: validateGenomeExport,
+					Action: ExportGenomeAction,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "organism",
+							Usage: "genus and species of the organism to export",
+							Value: "Dictyostelium discoideum",
+						},
+						cli.StringFlag{
+							Name:  "output",
+							Usage: "GFF3 output file, - for standard output",
+							Value: "-",
+						},
+						cli.StringFlag{
+							Name:  "fasta",
+							Usage: "FASTA output file for the residues of features, - for standard output",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "Compare the data in the database with the source files",
+			Subcommands: []cli.Command{
+				{
+					Name:   "genome",
+					Usage:  "Report missing, extra and changed features against the GFF3 files",
+					Before: validateGenomeExport,
+					Action: VerifyGenomeAction,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "folder",
+							Usage: "folder with the original GFF3 files",
+							Value: "/data/gff3",
+						},
+						cli.StringFlag{
+							Name:  "organism",
+							Usage: "genus and species of the organism to verify",
+							Value: "Dictyostelium discoideum",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:   "locks",
+			Usage:  "List the import locks currently held in the database",
+			Before: validateArgs,
+			Action: LocksAction,
+		},
+		{
+			Name:   "wait-for",
+			Usage:  "Wait for an import command to finish through postgresql notification",
+			Before: validateWaitFor,
+			Action: WaitForAction,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "channel",
+					EnvVar: "NOTIFY_CHANNEL",
+					Usage:  "postgresql channel to listen",
+					Value:  "migration_import",
+				},
+				cli.StringFlag{
+					Name:  "event",
+					Usage: "name of the import command to wait for",
+				},
+				cli.DurationFlag{
+					Name:  "timeout",
+					Usage: "maximum time to wait, zero waits forever",
+				},
+				cli.DurationFlag{
+					Name:  "since",
+					Usage: "also accept a run of the command recorded within this duration before waiting",
+				},
+			},
+		},
 	}
+	handleSignals(app)
 	app.Run(os.Args)
 }
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	minio "github.com/minio/minio-go"
 	"github.com/sirupsen/logrus"
@@ -16,6 +17,25 @@ import (
 	"gopkg.in/urfave/cli.v1"
 )
 
+// importAction wraps the action of an import subcommand with the
+// bookkeeping shared by all of them
+func importAction(action cli.ActionFunc) cli.ActionFunc {
+	return cancellable(coordinate(notifyRun(recordRun(lockImport(action)))))
+}
+
+// parentAction runs the action of a command that also has subcommands, cli
+// runs such an action without its command, which the bookkeeping relies on
+func parentAction(name string, action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if len(c.Command.Name) == 0 && c.Parent() != nil {
+			if cmd := c.Parent().App.Command(name); cmd != nil {
+				c.Command = *cmd
+			}
+		}
+		return action(c)
+	}
+}
+
 func validateCommon(c *cli.Context) error {
 	if err := validateArgs(c); err != nil {
 		return err
@@ -126,6 +146,15 @@ func fetchRemoteFile(c *cli.Context, name string) (string, error) {
 	if err := s3Client.FGetObject(c.GlobalString("s3-bucket"), c.String("remote-path"), tmpf.Name()); err != nil {
 		return "", fmt.Errorf("Unable to retrieve the object %s", err.Error(), 2)
 	}
+	info, err := s3Client.StatObject(c.GlobalString("s3-bucket"), c.String("remote-path"))
+	if err != nil {
+		return "", fmt.Errorf("unable to stat the object %s", err)
+	}
+	// object-version is only set for a step of manifest
+	if v := c.GlobalString("object-version"); len(v) > 0 && strings.Trim(info.ETag, `"`) != strings.Trim(v, `"`) {
+		return "", fmt.Errorf("object %s has version %s, expected %s", c.String("remote-path"), info.ETag, v)
+	}
+	setRunSource(c, c.String("remote-path"), info.ETag)
 	return tmpf.Name(), nil
 }
 
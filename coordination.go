@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const keyPollInterval = 2 * time.Second
+
+// keyStore is a shared storage where the import commands register and look
+// up keys to sequence themselves
+type keyStore interface {
+	Exists(key string) (bool, error)
+	Register(key, value string) error
+	String() string
+}
+
+// etcdStore talks to the JSON gateway of etcd v3 api
+type etcdStore struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newEtcdStore(endpoint string) *etcdStore {
+	return &etcdStore{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (e *etcdStore) String() string {
+	return fmt.Sprintf("etcd(%s)", e.endpoint)
+}
+
+func (e *etcdStore) post(path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to reach etcd %s", err)
+	}
+	defer res.Body.Close()
+	ct, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read etcd response %s", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd returned %s %s", res.Status, string(ct))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(ct, resp)
+}
+
+func (e *etcdStore) Exists(key string) (bool, error) {
+	var resp struct {
+		Count string `json:"count"`
+	}
+	err := e.post(
+		"/v3/kv/range",
+		map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))},
+		&resp,
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Count) > 0 && resp.Count != "0", nil
+}
+
+func (e *etcdStore) Register(key, value string) error {
+	return e.post(
+		"/v3/kv/put",
+		map[string]string{
+			"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+			"value": base64.StdEncoding.EncodeToString([]byte(value)),
+		},
+		nil,
+	)
+}
+
+// fileStore keeps every key as a file under a folder, meant for running
+// the imports locally without etcd
+type fileStore struct {
+	dir string
+}
+
+func (f *fileStore) String() string {
+	return fmt.Sprintf("folder(%s)", f.dir)
+}
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(strings.TrimPrefix(key, "/")))
+}
+
+func (f *fileStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(f.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *fileStore) Register(key, value string) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create folder for key %s %s", key, err)
+	}
+	return ioutil.WriteFile(path, []byte(value), 0644)
+}
+
+func hasEtcd(c *cli.Context) bool {
+	return len(c.GlobalString("etcd-host")) > 0 && len(c.GlobalString("etcd-port")) > 0
+}
+
+// getKeyStore returns etcd if it is configured, otherwise the local folder.
+// The returned boolean is false when none of them is available.
+func getKeyStore(c *cli.Context) (keyStore, bool) {
+	if hasEtcd(c) {
+		return newEtcdStore(
+			fmt.Sprintf("http://%s:%s", c.GlobalString("etcd-host"), c.GlobalString("etcd-port")),
+		), true
+	}
+	if len(c.GlobalString("key-dir")) > 0 {
+		return &fileStore{dir: c.GlobalString("key-dir")}, true
+	}
+	return nil, false
+}
+
+// watchKeys returns the keys to wait for, any key set for the subcommand
+// is added to the global ones
+func watchKeys(c *cli.Context) []string {
+	keys := append([]string{}, c.GlobalStringSlice("key-watch")...)
+	if k := c.String("key-watch"); len(k) > 0 {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func registerKeys(c *cli.Context) []string {
+	keys := append([]string{}, c.GlobalStringSlice("key-register")...)
+	if k := c.String("key-register"); len(k) > 0 {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// keysGiven tells if any key is set on the command line, the defaults of the
+// subcommands only apply when a store is configured
+func keysGiven(c *cli.Context) bool {
+	for _, n := range []string{"key-watch", "key-register"} {
+		if c.GlobalIsSet(n) || c.IsSet(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForKey blocks until the key exists in the store or the timeout
+// expires, a zero timeout waits forever
+func waitForKey(ctx context.Context, store keyStore, key string, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		ok, err := store.Exists(key)
+		if err != nil {
+			return fmt.Errorf("unable to look up key %s in %s %s", key, store, err)
+		}
+		if ok {
+			return nil
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for key %s in %s", timeout, key, store)
+		}
+		if !sleepContext(ctx, keyPollInterval) {
+			return fmt.Errorf("waiting for key %s in %s %s", key, store, errCancelled)
+		}
+	}
+}
+
+// coordinate wraps an action so that it waits for the watched keys before
+// running and registers keys after a successful run
+func coordinate(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		wkeys := watchKeys(c)
+		rkeys := registerKeys(c)
+		if len(wkeys) == 0 && len(rkeys) == 0 {
+			return action(c)
+		}
+		store, ok := getKeyStore(c)
+		if !ok {
+			if keysGiven(c) {
+				return cli.NewExitError("keys to watch or register are given without etcd or key-dir to keep them", 2)
+			}
+			return action(c)
+		}
+		log, err := getLogger(c, "coordination")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		for _, k := range wkeys {
+			log.WithFields(logrus.Fields{
+				"type":  "key-watch",
+				"key":   k,
+				"store": store.String(),
+			}).Info("waiting for key")
+			if err := waitForKey(getContext(c), store, k, c.GlobalDuration("key-timeout")); err != nil {
+				log.WithFields(logrus.Fields{
+					"type":  "key-watch",
+					"key":   k,
+					"store": store.String(),
+				}).Error(err)
+				return cli.NewExitError(err.Error(), 2)
+			}
+			log.WithFields(logrus.Fields{
+				"type":  "key-watch",
+				"key":   k,
+				"store": store.String(),
+			}).Info("wait for key is over")
+		}
+		if err := action(c); err != nil {
+			return err
+		}
+		if c.GlobalBool("dry-run") {
+			return nil
+		}
+		value := fmt.Sprintf("%s %s", c.Command.Name, time.Now().Format(time.RFC3339))
+		for _, k := range rkeys {
+			if err := store.Register(k, value); err != nil {
+				log.WithFields(logrus.Fields{
+					"type":  "key-register",
+					"key":   k,
+					"store": store.String(),
+				}).Error(err)
+				return cli.NewExitError(err.Error(), 2)
+			}
+			log.WithFields(logrus.Fields{
+				"type":  "key-register",
+				"key":   k,
+				"store": store.String(),
+			}).Info("registered key")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// fakeEtcd serves the range and put calls of the JSON gateway of etcd v3
+// from a map. Keys can be attached to a lease that expires them the way
+// another client's keys disappear, and keys can be held so that a put gets
+// the error a failed transaction of the gateway returns.
+type fakeEtcd struct {
+	sync.Mutex
+	kv     map[string]string
+	leases map[string]int64
+	held   map[string]bool
+	calls  []string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		kv:     make(map[string]string),
+		leases: make(map[string]int64),
+		held:   make(map[string]bool),
+	}
+}
+
+// putWithLease stores a key the way a client holding a lease does
+func (f *fakeEtcd) putWithLease(key, value string, lease int64) {
+	f.Lock()
+	defer f.Unlock()
+	f.kv[key] = value
+	f.leases[key] = lease
+}
+
+// expire drops the keys of the lease as etcd does once its TTL is over
+func (f *fakeEtcd) expire(lease int64) {
+	f.Lock()
+	defer f.Unlock()
+	for k, l := range f.leases {
+		if l == lease {
+			delete(f.kv, k)
+			delete(f.leases, k)
+		}
+	}
+}
+
+// gatewayError writes an error in the format of the grpc gateway
+func gatewayError(w http.ResponseWriter, status, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   msg,
+		"code":    code,
+		"message": msg,
+	})
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, r.URL.Path)
+	if r.Method != http.MethodPost {
+		gatewayError(w, http.StatusMethodNotAllowed, 12, "method not allowed")
+		return
+	}
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		gatewayError(w, http.StatusBadRequest, 3, err.Error())
+		return
+	}
+	key, err := base64.StdEncoding.DecodeString(req["key"])
+	if err != nil {
+		gatewayError(w, http.StatusBadRequest, 3, err.Error())
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		if _, ok := f.kv[string(key)]; !ok {
+			w.Write([]byte(`{"header":{"revision":"1"}}`))
+			return
+		}
+		w.Write([]byte(`{"header":{"revision":"1"},"kvs":[{"key":"` + req["key"] + `"}],"count":"1"}`))
+	case "/v3/kv/put":
+		if f.held[string(key)] {
+			gatewayError(w, http.StatusBadRequest, 9, "etcdserver: key is held by another transaction")
+			return
+		}
+		value, err := base64.StdEncoding.DecodeString(req["value"])
+		if err != nil {
+			gatewayError(w, http.StatusBadRequest, 3, err.Error())
+			return
+		}
+		f.kv[string(key)] = string(value)
+		delete(f.leases, string(key))
+		w.Write([]byte(`{"header":{"revision":"2"}}`))
+	default:
+		gatewayError(w, http.StatusNotFound, 5, "not found")
+	}
+}
+
+func TestEtcdStore(t *testing.T) {
+	etcd := newFakeEtcd()
+	srv := httptest.NewServer(etcd)
+	defer srv.Close()
+	store := newEtcdStore(srv.URL + "/")
+	ok, err := store.Exists("/migration/ontology")
+	if err != nil {
+		t.Fatalf("unable to look up key %s", err)
+	}
+	if ok {
+		t.Fatal("expected the key to be absent")
+	}
+	if err := store.Register("/migration/ontology", "onto 2017-10-02"); err != nil {
+		t.Fatalf("unable to register key %s", err)
+	}
+	if v := etcd.kv["/migration/ontology"]; v != "onto 2017-10-02" {
+		t.Fatalf("expected the value to be stored, got %q", v)
+	}
+	ok, err = store.Exists("/migration/ontology")
+	if err != nil {
+		t.Fatalf("unable to look up key %s", err)
+	}
+	if !ok {
+		t.Fatal("expected the key to exist after registering")
+	}
+	expected := []string{"/v3/kv/range", "/v3/kv/put", "/v3/kv/range"}
+	if len(etcd.calls) != len(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, etcd.calls)
+	}
+	for i, p := range expected {
+		if etcd.calls[i] != p {
+			t.Fatalf("expected calls %v, got %v", expected, etcd.calls)
+		}
+	}
+}
+
+func TestEtcdStoreLeaseExpiry(t *testing.T) {
+	etcd := newFakeEtcd()
+	srv := httptest.NewServer(etcd)
+	defer srv.Close()
+	store := newEtcdStore(srv.URL)
+	etcd.putWithLease("/migration/ontology", "onto", 7587)
+	if err := waitForKey(context.Background(), store, "/migration/ontology", time.Second); err != nil {
+		t.Fatalf("expected a key of a live lease to end the wait %s", err)
+	}
+	etcd.expire(7587)
+	ok, err := store.Exists("/migration/ontology")
+	if err != nil {
+		t.Fatalf("unable to look up key %s", err)
+	}
+	if ok {
+		t.Fatal("expected the key to be gone with its lease")
+	}
+	if err := waitForKey(context.Background(), store, "/migration/ontology", time.Nanosecond); err == nil {
+		t.Fatal("expected a wait for an expired key to time out")
+	}
+	// a registered key is not tied to the lease of the earlier one
+	etcd.putWithLease("/migration/ontology", "onto", 7588)
+	if err := store.Register("/migration/ontology", "onto"); err != nil {
+		t.Fatalf("unable to register key %s", err)
+	}
+	etcd.expire(7588)
+	if ok, _ := store.Exists("/migration/ontology"); !ok {
+		t.Fatal("expected the registered key to outlive the expired lease")
+	}
+}
+
+func TestEtcdStoreConflict(t *testing.T) {
+	etcd := newFakeEtcd()
+	etcd.held["/migration/genomes"] = true
+	srv := httptest.NewServer(etcd)
+	defer srv.Close()
+	store := newEtcdStore(srv.URL)
+	err := store.Register("/migration/genomes", "genomes")
+	if err == nil {
+		t.Fatal("expected an error for a conflicting put")
+	}
+	if !strings.Contains(err.Error(), "key is held by another transaction") {
+		t.Fatalf("expected the error of etcd, got %s", err)
+	}
+	if _, ok := etcd.kv["/migration/genomes"]; ok {
+		t.Fatal("expected the conflicting put to store nothing")
+	}
+}
+
+func TestEtcdStoreError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatewayError(w, http.StatusServiceUnavailable, 14, "etcdserver: request timed out")
+	}))
+	defer srv.Close()
+	store := newEtcdStore(srv.URL)
+	if _, err := store.Exists("/migration/ontology"); err == nil {
+		t.Fatal("expected an error from a failed range")
+	}
+	if err := store.Register("/migration/ontology", "onto"); err == nil {
+		t.Fatal("expected an error from a failed put")
+	}
+	start := time.Now()
+	if err := waitForKey(context.Background(), store, "/migration/ontology", 0); err == nil {
+		t.Fatal("expected a failed range to end the wait")
+	}
+	if time.Since(start) >= keyPollInterval {
+		t.Fatal("expected the wait to fail without polling again")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &fileStore{dir: dir}
+	ok, err := store.Exists("/migration/genomes")
+	if err != nil {
+		t.Fatalf("unable to look up key %s", err)
+	}
+	if ok {
+		t.Fatal("expected the key to be absent")
+	}
+	if err := store.Register("/migration/genomes", "genomes"); err != nil {
+		t.Fatalf("unable to register key %s", err)
+	}
+	ct, err := ioutil.ReadFile(filepath.Join(dir, "migration", "genomes"))
+	if err != nil {
+		t.Fatalf("expected the key as a file %s", err)
+	}
+	if string(ct) != "genomes" {
+		t.Fatalf("expected the value in the file, got %q", string(ct))
+	}
+	ok, err = store.Exists("/migration/genomes")
+	if err != nil {
+		t.Fatalf("unable to look up key %s", err)
+	}
+	if !ok {
+		t.Fatal("expected the key to exist after registering")
+	}
+}
+
+func TestWaitForKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := &fileStore{dir: dir}
+	if err := store.Register("/migration/ontology", "onto"); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForKey(context.Background(), store, "/migration/ontology", time.Second); err != nil {
+		t.Fatalf("expected a registered key to end the wait %s", err)
+	}
+	if err := waitForKey(context.Background(), store, "/migration/genomes", time.Nanosecond); err == nil {
+		t.Fatal("expected a timeout for a missing key")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := waitForKey(ctx, store, "/migration/genomes", 0); err == nil {
+		t.Fatal("expected a cancelled wait to fail")
+	}
+}
+
+// keyContext returns the context of a subcommand that has a key-watch flag
+// with a default the way genomes does
+func keyContext(t *testing.T, global, local []string) *cli.Context {
+	app := cli.NewApp()
+	gset := flag.NewFlagSet("import", flag.ContinueOnError)
+	for _, f := range []cli.Flag{
+		cli.StringFlag{Name: "etcd-host"},
+		cli.StringFlag{Name: "etcd-port"},
+		cli.StringFlag{Name: "key-dir"},
+		cli.StringSliceFlag{Name: "key-watch", Value: &cli.StringSlice{}},
+		cli.StringSliceFlag{Name: "key-register", Value: &cli.StringSlice{}},
+	} {
+		f.Apply(gset)
+	}
+	if err := gset.Parse(global); err != nil {
+		t.Fatal(err)
+	}
+	lset := flag.NewFlagSet("genomes", flag.ContinueOnError)
+	cli.StringFlag{Name: "key-watch", Value: "/migration/ontology"}.Apply(lset)
+	if err := lset.Parse(local); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, lset, cli.NewContext(app, gset, nil))
+}
+
+func TestCoordinateWithoutStore(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		global []string
+		local  []string
+		fail   bool
+	}{
+		{name: "default key", fail: false},
+		{name: "global watch", global: []string{"--key-watch", "/migration/ontology"}, fail: true},
+		{name: "global register", global: []string{"--key-register", "/migration/ontology"}, fail: true},
+		{name: "command watch", local: []string{"--key-watch", "/migration/stock"}, fail: true},
+	} {
+		ran := false
+		action := coordinate(func(c *cli.Context) error {
+			ran = true
+			return nil
+		})
+		err := action(keyContext(t, tc.global, tc.local))
+		if tc.fail && (err == nil || ran) {
+			t.Errorf("%s: expected an error without running the action", tc.name)
+		}
+		if !tc.fail && (err != nil || !ran) {
+			t.Errorf("%s: expected the action to run, got error %v", tc.name, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"sync"
 
 	"github.com/jackc/pgx"
 	_ "github.com/jackc/pgx/stdlib"
@@ -10,6 +11,9 @@ import (
 	"gopkg.in/urfave/cli.v1"
 )
 
+// connsPerStep is the size of connection pool needed by a single import
+const connsPerStep = 3
+
 func afterConnect(conn *pgx.Conn) error {
 	_, err := conn.Prepare("getOrganism", `
 		SELECT organism_id FROM organism WHERE genus=$1 and species=$2
@@ -62,12 +66,26 @@ func getConnPoolConfig(c *cli.Context) (pgx.ConnPoolConfig, error) {
 	}
 	return pgx.ConnPoolConfig{
 		ConnConfig:     connConfig,
-		MaxConnections: 3,
+		MaxConnections: connsPerStep * parallelSteps(c),
 		AfterConnect:   afterConnect,
 	}, nil
 }
 
+// parallelSteps is the number of pipeline steps that could run at the same
+// time, it is one outside of the pipeline
+func parallelSteps(c *cli.Context) int {
+	if n := c.GlobalInt("max-parallel"); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// getConnPool returns the pool shared by the steps of a parallel pipeline,
+// otherwise a new pool that has to be closed by the caller
 func getConnPool(c *cli.Context) (*pgx.ConnPool, error) {
+	if sp, ok := c.App.Metadata["pool"].(*sharedPool); ok {
+		return sp.get(c)
+	}
 	connConfig, err := getConnPoolConfig(c)
 	if err != nil {
 		return &pgx.ConnPool{}, err
@@ -75,6 +93,64 @@ func getConnPool(c *cli.Context) (*pgx.ConnPool, error) {
 	return pgx.NewConnPool(connConfig)
 }
 
+func isSharedConnPool(c *cli.Context) bool {
+	_, ok := c.App.Metadata["pool"].(*sharedPool)
+	return ok
+}
+
+// sharedPool keeps the connection pools created on first use by any of the
+// pipeline steps, both the pgx pool and the database handle of the dat runner
+// are sized for all the steps that could run at the same time
+type sharedPool struct {
+	sync.Mutex
+	pool *pgx.ConnPool
+	dbh  *runner.DB
+}
+
+func (sp *sharedPool) get(c *cli.Context) (*pgx.ConnPool, error) {
+	sp.Lock()
+	defer sp.Unlock()
+	if sp.pool != nil {
+		return sp.pool, nil
+	}
+	connConfig, err := getConnPoolConfig(c)
+	if err != nil {
+		return &pgx.ConnPool{}, err
+	}
+	pool, err := pgx.NewConnPool(connConfig)
+	if err != nil {
+		return pool, err
+	}
+	sp.pool = pool
+	return pool, nil
+}
+
+func (sp *sharedPool) wrapper(c *cli.Context) (*runner.DB, error) {
+	sp.Lock()
+	defer sp.Unlock()
+	if sp.dbh != nil {
+		return sp.dbh, nil
+	}
+	h, err := getPgxDbHandler(c)
+	if err != nil {
+		return sp.dbh, err
+	}
+	h.SetMaxOpenConns(connsPerStep * parallelSteps(c))
+	sp.dbh = runner.NewDB(h, "postgres")
+	return sp.dbh, nil
+}
+
+func (sp *sharedPool) close() {
+	sp.Lock()
+	defer sp.Unlock()
+	if sp.pool != nil {
+		sp.pool.Close()
+	}
+	if sp.dbh != nil {
+		sp.dbh.DB.Close()
+	}
+}
+
 func sendNotification(c *cli.Context, channel, payload string) error {
 	connConfig, err := getConnConfig(c)
 	if err != nil {
@@ -100,7 +176,12 @@ func sendNotificationWithConn(conn *pgx.Conn, channel, payload string) error {
 	return nil
 }
 
+// getPgWrapper returns the handle shared by the steps of a parallel
+// pipeline, otherwise a new one
 func getPgWrapper(c *cli.Context) (*runner.DB, error) {
+	if sp, ok := c.App.Metadata["pool"].(*sharedPool); ok {
+		return sp.wrapper(c)
+	}
 	var dbh *runner.DB
 	h, err := getPgxDbHandler(c)
 	if err != nil {
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const sampleSize = 5
+
+const (
+	changeInsert = "insert"
+	changeUpdate = "update"
+	changeUpsert = "upsert"
+	changeDelete = "delete"
+)
+
+// changeReport collects the changes made to every table within a
+// transaction along with a few sample rows
+type changeReport struct {
+	dryRun  bool
+	tables  []string
+	counts  map[string]map[string]int64
+	samples map[string]map[string][]interface{}
+}
+
+type tableChange struct {
+	Table   string                   `json:"table"`
+	Counts  map[string]int64         `json:"counts"`
+	Samples map[string][]interface{} `json:"samples,omitempty"`
+}
+
+// validateNoDryRun rejects --dry-run for the commands that write outside of
+// a transaction of this process, such as the modware-load subprocesses
+func validateNoDryRun(c *cli.Context, cmd string) error {
+	if c.GlobalBool("dry-run") {
+		return cli.NewExitError(fmt.Sprintf("--dry-run is not supported by %s", cmd), 2)
+	}
+	return nil
+}
+
+func newChangeReport(c *cli.Context) *changeReport {
+	return &changeReport{
+		dryRun:  c.GlobalBool("dry-run"),
+		counts:  make(map[string]map[string]int64),
+		samples: make(map[string]map[string][]interface{}),
+	}
+}
+
+func (r *changeReport) table(name string) map[string]int64 {
+	if _, ok := r.counts[name]; !ok {
+		r.tables = append(r.tables, name)
+		r.counts[name] = make(map[string]int64)
+		r.samples[name] = make(map[string][]interface{})
+	}
+	return r.counts[name]
+}
+
+// add records the number of rows of a table affected by a kind of change
+func (r *changeReport) add(table, kind string, count int64) {
+	r.table(table)[kind] += count
+}
+
+// sample keeps a row of a table for the report, only the first few rows
+// of every kind of change are retained
+func (r *changeReport) sample(table, kind string, row interface{}) {
+	r.table(table)
+	if len(r.samples[table][kind]) < sampleSize {
+		r.samples[table][kind] = append(r.samples[table][kind], row)
+	}
+}
+
+func (r *changeReport) changes() []*tableChange {
+	var changes []*tableChange
+	for _, t := range r.tables {
+		changes = append(changes, &tableChange{
+			Table:   t,
+			Counts:  r.counts[t],
+			Samples: r.samples[t],
+		})
+	}
+	return changes
+}
+
+func (r *changeReport) log(log *logrus.Logger) {
+	for _, ch := range r.changes() {
+		fields := logrus.Fields{
+			"type":    "dry-run",
+			"table":   ch.Table,
+			"dry-run": r.dryRun,
+		}
+		for k, v := range ch.Counts {
+			fields[k] = v
+		}
+		if len(ch.Samples) > 0 {
+			if ct, err := json.Marshal(ch.Samples); err == nil {
+				fields["samples"] = string(ct)
+			}
+		}
+		log.WithFields(fields).Info("table changes")
+	}
+}
+
+func (r *changeReport) print(w io.Writer, command string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "dry run of %s, no change is committed\n", command)
+	fmt.Fprintln(tw, "TABLE\tINSERT\tUPDATE\tUPSERT\tDELETE")
+	for _, ch := range r.changes() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n",
+			ch.Table,
+			ch.Counts[changeInsert],
+			ch.Counts[changeUpdate],
+			ch.Counts[changeUpsert],
+			ch.Counts[changeDelete],
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, ch := range r.changes() {
+		for _, kind := range []string{changeInsert, changeUpdate, changeUpsert, changeDelete} {
+			for _, s := range ch.Samples[kind] {
+				ct, err := json.Marshal(s)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s %s: %s\n", kind, ch.Table, string(ct))
+			}
+		}
+	}
+	return nil
+}
+
+// commitTx commits the transaction, in dry run mode it reports the changes
+// and rolls back instead. The transaction is always rolled back once the
+// application is cancelled.
+func commitTx(c *cli.Context, tx *runner.Tx, report *changeReport, log *logrus.Logger) error {
+	if isCancelled(c) {
+		if err := tx.Rollback(); err != nil {
+			return fmt.Errorf("error in rolling back cancelled transaction %s", err)
+		}
+		log.WithFields(logrus.Fields{
+			"type": "signal",
+			"kind": "rollback",
+		}).Warn("rolled back all changes of cancelled run")
+		return errCancelled
+	}
+	if !report.dryRun {
+		return tx.Commit()
+	}
+	report.log(log)
+	if err := report.print(c.App.Writer, c.Command.Name); err != nil {
+		return fmt.Errorf("error in writing dry run report %s", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("error in rolling back dry run %s", err)
+	}
+	log.WithFields(logrus.Fields{
+		"type": "dry-run",
+	}).Info("rolled back all changes of dry run")
+	return nil
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const exportFeatureSQL = `
+SELECT feature.feature_id,feature.uniquename,feature.name,cvterm.name AS type,feature.seqlen
+FROM feature
+JOIN cvterm ON cvterm.cvterm_id = feature.type_id
+WHERE feature.organism_id = $1
+ORDER BY feature.feature_id
+	`
+
+const exportLocationSQL = `
+SELECT featureloc.feature_id,src.uniquename AS srcfeature,featureloc.fmin,featureloc.fmax,
+	featureloc.strand,featureloc.phase
+FROM featureloc
+JOIN feature ON feature.feature_id = featureloc.feature_id
+JOIN feature src ON src.feature_id = featureloc.srcfeature_id
+WHERE feature.organism_id = $1
+ORDER BY featureloc.feature_id,featureloc.rank
+	`
+
+const exportPropertySQL = `
+SELECT featureprop.feature_id,cvterm.name,featureprop.value
+FROM featureprop
+JOIN feature ON feature.feature_id = featureprop.feature_id
+JOIN cvterm ON cvterm.cvterm_id = featureprop.type_id
+WHERE feature.organism_id = $1
+ORDER BY featureprop.feature_id,cvterm.name,featureprop.rank
+	`
+
+const exportDbxrefSQL = `
+SELECT feature_dbxref.feature_id,db.name,dbxref.accession
+FROM feature_dbxref
+JOIN feature ON feature.feature_id = feature_dbxref.feature_id
+JOIN dbxref ON dbxref.dbxref_id = feature_dbxref.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE feature.organism_id = $1
+ORDER BY feature_dbxref.feature_id,feature_dbxref.feature_dbxref_id
+	`
+
+const exportRelationshipSQL = `
+SELECT feature_relationship.subject_id AS feature_id,object.uniquename AS name,cvterm.name AS value
+FROM feature_relationship
+JOIN feature subject ON subject.feature_id = feature_relationship.subject_id
+JOIN feature object ON object.feature_id = feature_relationship.object_id
+JOIN cvterm ON cvterm.cvterm_id = feature_relationship.type_id
+WHERE subject.organism_id = $1
+ORDER BY feature_relationship.subject_id,feature_relationship.feature_relationship_id
+	`
+
+const exportResidueSQL = `
+SELECT uniquename,residues FROM feature
+WHERE organism_id = $1 AND residues IS NOT NULL
+ORDER BY feature_id
+	`
+
+// gff3 attributes of the feature relationships
+var relationshipAttrs = map[string]string{
+	"part_of":      "Parent",
+	"derives_from": "Derives_from",
+}
+
+type exportFeature struct {
+	ID         int64          `db:"feature_id"`
+	Uniquename string         `db:"uniquename"`
+	Name       dat.NullString `db:"name"`
+	Type       string         `db:"type"`
+	Seqlen     dat.NullInt64  `db:"seqlen"`
+}
+
+type exportLocation struct {
+	FeatureID  int64         `db:"feature_id"`
+	Srcfeature string        `db:"srcfeature"`
+	Fmin       int64         `db:"fmin"`
+	Fmax       int64         `db:"fmax"`
+	Strand     dat.NullInt64 `db:"strand"`
+	Phase      dat.NullInt64 `db:"phase"`
+}
+
+// exportValue is a named value tied to a feature, shared by the queries of
+// properties, dbxrefs and relationships
+type exportValue struct {
+	FeatureID int64  `db:"feature_id"`
+	Name      string `db:"name"`
+	Value     string `db:"value"`
+}
+
+type exportResidue struct {
+	Uniquename string `db:"uniquename"`
+	Residues   string `db:"residues"`
+}
+
+func validateGenomeExport(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(strings.Fields(c.String("organism"))) != 2 {
+		return cli.NewExitError("organism should be given as genus and species", 2)
+	}
+	return nil
+}
+
+func ExportGenomeAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "export-genome")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	organismId, err := findOrganismId(dbh, c.String("organism"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	g, err := chadoGenome(dbh, organismId, log)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "export-genome",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if err := writeExport(c.String("output"), c.App.Writer, func(w io.Writer) error {
+		return writeGFF3(w, g)
+	}); err != nil {
+		return cli.NewExitError(fmt.Sprintf("error in writing gff3 %s", err), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":     "export-genome",
+		"features": len(g.Features),
+	}).Info("exported gff3")
+	if len(c.String("fasta")) == 0 {
+		return nil
+	}
+	var residues []*exportResidue
+	err = dbh.SQL(exportResidueSQL, organismId).QueryStructs(&residues)
+	if err != nil && err != dat.ErrNotFound {
+		return cli.NewExitError(fmt.Sprintf("error in querying residues %s", err), 2)
+	}
+	if err := writeExport(c.String("fasta"), c.App.Writer, func(w io.Writer) error {
+		return writeFasta(w, residues)
+	}); err != nil {
+		return cli.NewExitError(fmt.Sprintf("error in writing fasta %s", err), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":      "export-genome",
+		"sequences": len(residues),
+	}).Info("exported fasta")
+	return nil
+}
+
+// writeExport writes to the given file, or to the standard output of the
+// application for -
+func writeExport(file string, stdout io.Writer, write func(io.Writer) error) error {
+	if file == "-" {
+		return write(stdout)
+	}
+	w, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func writeFasta(w io.Writer, residues []*exportResidue) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range residues {
+		fmt.Fprintf(bw, ">%s\n", r.Uniquename)
+		for i := 0; i < len(r.Residues); i += 60 {
+			end := i + 60
+			if end > len(r.Residues) {
+				end = len(r.Residues)
+			}
+			fmt.Fprintln(bw, r.Residues[i:end])
+		}
+	}
+	return bw.Flush()
+}
+
+func findOrganismId(dbh *runner.DB, organism string) (int64, error) {
+	var id int64
+	genus, species := splitOrganism(organism)
+	err := dbh.Select("organism_id").
+		From("organism").
+		Where("genus = $1 AND species = $2", genus, species).
+		QueryScalar(&id)
+	if err != nil {
+		if err == dat.ErrNotFound {
+			return id, fmt.Errorf("organism %s is not loaded", organism)
+		}
+		return id, fmt.Errorf("error in looking up organism %s", err)
+	}
+	return id, nil
+}
+
+// chadoGenome reads the features of the organism in the same form as they
+// are parsed from gff3. A feature without any location becomes a
+// sequence-region, and also a feature unless it was created from one.
+func chadoGenome(dbh *runner.DB, organismId int64, log *logrus.Logger) (*gffFile, error) {
+	g := newGFFFile("chado")
+	var features []*exportFeature
+	err := dbh.SQL(exportFeatureSQL, organismId).QueryStructs(&features)
+	if err != nil && err != dat.ErrNotFound {
+		return g, fmt.Errorf("error in querying features %s", err)
+	}
+	byId := make(map[int64]*gffFeature)
+	for _, ef := range features {
+		f := &gffFeature{
+			Uniquename: ef.Uniquename,
+			Type:       ef.Type,
+			Attributes: make(map[string][]string),
+		}
+		if ef.Name.Valid && len(ef.Name.String) > 0 {
+			f.Attributes["Name"] = []string{ef.Name.String}
+		}
+		byId[ef.ID] = f
+	}
+	var locs []*exportLocation
+	err = dbh.SQL(exportLocationSQL, organismId).QueryStructs(&locs)
+	if err != nil && err != dat.ErrNotFound {
+		return g, fmt.Errorf("error in querying locations %s", err)
+	}
+	for _, l := range locs {
+		f := byId[l.FeatureID]
+		loc := &gffLocation{Seqid: l.Srcfeature, Fmin: l.Fmin, Fmax: l.Fmax, Strand: ".", Phase: "."}
+		if l.Strand.Valid {
+			switch l.Strand.Int64 {
+			case 1:
+				loc.Strand = "+"
+			case -1:
+				loc.Strand = "-"
+			}
+		}
+		if l.Phase.Valid {
+			loc.Phase = fmt.Sprintf("%d", l.Phase.Int64)
+		}
+		f.Locations = append(f.Locations, loc)
+	}
+	var values []*exportValue
+	err = dbh.SQL(exportPropertySQL, organismId).QueryStructs(&values)
+	if err != nil && err != dat.ErrNotFound {
+		return g, fmt.Errorf("error in querying properties %s", err)
+	}
+	for _, v := range values {
+		f := byId[v.FeatureID]
+		f.Attributes[v.Name] = append(f.Attributes[v.Name], v.Value)
+	}
+	values = nil
+	err = dbh.SQL(exportDbxrefSQL, organismId).QueryStructs(&values)
+	if err != nil && err != dat.ErrNotFound {
+		return g, fmt.Errorf("error in querying dbxrefs %s", err)
+	}
+	for _, v := range values {
+		f := byId[v.FeatureID]
+		if v.Name == "GFF_source" {
+			f.Source = v.Value
+			continue
+		}
+		f.Attributes["Dbxref"] = append(f.Attributes["Dbxref"], v.Name+":"+v.Value)
+	}
+	values = nil
+	err = dbh.SQL(exportRelationshipSQL, organismId).QueryStructs(&values)
+	if err != nil && err != dat.ErrNotFound {
+		return g, fmt.Errorf("error in querying relationships %s", err)
+	}
+	for _, v := range values {
+		attr, ok := relationshipAttrs[v.Value]
+		if !ok {
+			continue
+		}
+		f := byId[v.FeatureID]
+		f.Attributes[attr] = append(f.Attributes[attr], v.Name)
+	}
+	for _, ef := range features {
+		f := byId[ef.ID]
+		if len(f.Locations) == 0 {
+			if !ef.Seqlen.Valid {
+				log.WithFields(logrus.Fields{
+					"type":    "export-genome",
+					"feature": f.Uniquename,
+				}).Warn("skipping feature without any location or length")
+				continue
+			}
+			g.Regions[f.Uniquename] = ef.Seqlen.Int64
+			if f.Type == "region" {
+				continue
+			}
+			f.Locations = []*gffLocation{{
+				Seqid: f.Uniquename, Fmax: ef.Seqlen.Int64, Strand: ".", Phase: ".",
+			}}
+		}
+		g.Features = append(g.Features, f)
+		g.byName[f.Uniquename] = f
+	}
+	return g, nil
+}
+
+func VerifyGenomeAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "verify-genome")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	files, err := listGFF3Files(c.String("folder"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	expected := newGFFFile(c.String("folder"))
+	for _, f := range files {
+		g, err := readGFF3File(f)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		for r, l := range g.Regions {
+			expected.Regions[r] = l
+		}
+		for _, gf := range g.Features {
+			if err := expected.add(gf); err != nil {
+				return cli.NewExitError(err.Error(), 2)
+			}
+		}
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	organismId, err := findOrganismId(dbh, c.String("organism"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	actual, err := chadoGenome(dbh, organismId, log)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	diffs := diffGenome(expected, actual)
+	w := tabwriter.NewWriter(c.App.Writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tFEATURE\tTYPE\tDETAIL")
+	counts := make(map[string]int)
+	for _, d := range diffs {
+		counts[d.Status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Status, d.Uniquename, d.Type, d.Detail)
+	}
+	if err := w.Flush(); err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":    "verify-genome",
+		"missing": counts[diffMissing],
+		"extra":   counts[diffExtra],
+		"changed": counts[diffChanged],
+	}).Info("verified genome")
+	if len(diffs) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf(
+				"genome differs from gff3, %d missing, %d extra and %d changed features",
+				counts[diffMissing], counts[diffExtra], counts[diffChanged],
+			),
+			2,
+		)
+	}
+	return nil
+}
+
+const (
+	diffMissing = "missing"
+	diffExtra   = "extra"
+	diffChanged = "changed"
+)
+
+type featureDiff struct {
+	Status     string
+	Uniquename string
+	Type       string
+	Detail     string
+}
+
+// diffGenome compares the features of gff3 files with the ones exported
+// from chado. Features of chado that are only sequence-regions of the files
+// are not considered to be extra.
+func diffGenome(expected, actual *gffFile) []*featureDiff {
+	var diffs []*featureDiff
+	for _, ef := range expected.Features {
+		af, ok := actual.Feature(ef.Uniquename)
+		if !ok {
+			diffs = append(diffs, &featureDiff{
+				Status:     diffMissing,
+				Uniquename: ef.Uniquename,
+				Type:       ef.Type,
+			})
+			continue
+		}
+		if changed := featureChanges(ef, af); len(changed) > 0 {
+			diffs = append(diffs, &featureDiff{
+				Status:     diffChanged,
+				Uniquename: ef.Uniquename,
+				Type:       ef.Type,
+				Detail:     strings.Join(changed, ","),
+			})
+		}
+	}
+	for _, af := range actual.Features {
+		if _, ok := expected.Feature(af.Uniquename); ok {
+			continue
+		}
+		if _, ok := expected.Regions[af.Uniquename]; ok {
+			continue
+		}
+		diffs = append(diffs, &featureDiff{
+			Status:     diffExtra,
+			Uniquename: af.Uniquename,
+			Type:       af.Type,
+		})
+	}
+	return diffs
+}
+
+// featureChanges returns the name of the parts of the feature that differ
+func featureChanges(ef, af *gffFeature) []string {
+	var changed []string
+	if ef.Type != af.Type {
+		changed = append(changed, "type")
+	}
+	if normalSource(ef.Source) != normalSource(af.Source) {
+		changed = append(changed, "source")
+	}
+	if !reflect.DeepEqual(locationKeys(ef), locationKeys(af)) {
+		changed = append(changed, "location")
+	}
+	names := make(map[string]bool)
+	for k := range ef.Attributes {
+		names[k] = true
+	}
+	for k := range af.Attributes {
+		names[k] = true
+	}
+	delete(names, "ID")
+	var attrs []string
+	for k := range names {
+		attrs = append(attrs, k)
+	}
+	sort.Strings(attrs)
+	for _, k := range attrs {
+		if !sameValues(ef.Attributes[k], af.Attributes[k]) {
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
+func normalSource(s string) string {
+	if s == "." {
+		return ""
+	}
+	return s
+}
+
+// locationKeys returns the locations of the feature in a comparable form,
+// a landmark is not located on itself in chado
+func locationKeys(f *gffFeature) []string {
+	var keys []string
+	for _, l := range f.Locations {
+		if l.Seqid == f.Uniquename {
+			continue
+		}
+		strand := l.Strand
+		if strand != "+" && strand != "-" {
+			strand = "."
+		}
+		phase := l.Phase
+		switch phase {
+		case "0", "1", "2":
+		default:
+			phase = "."
+		}
+		keys = append(keys, fmt.Sprintf("%s:%d:%d:%s:%s", l.Seqid, l.Fmin, l.Fmax, strand, phase))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sa := append([]string{}, a...)
+	sb := append([]string{}, b...)
+	sort.Strings(sa)
+	sort.Strings(sb)
+	return reflect.DeepEqual(sa, sb)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+)
+
+type fastaRecord struct {
+	ID          string
+	Description string
+	Residues    []byte
+}
+
+// Header is the full definition line without the leading >
+func (r *fastaRecord) Header() string {
+	if len(r.Description) == 0 {
+		return r.ID
+	}
+	return r.ID + " " + r.Description
+}
+
+// fastaReader streams the records of a multi-FASTA file one at a time
+type fastaReader struct {
+	rd        *bufio.Reader
+	header    string
+	hasHeader bool
+}
+
+// newFastaReader reads plain or gzip compressed FASTA, the compression is
+// detected from the content as the downloaded files lose their extension
+func newFastaReader(r io.Reader) (*fastaReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gr)
+	}
+	return &fastaReader{rd: br}, nil
+}
+
+// Next returns the next record or io.EOF once all the records are read, a
+// record with an empty header is returned without any id and it is up to
+// the caller to skip it
+func (f *fastaReader) Next() (*fastaRecord, error) {
+	for !f.hasHeader {
+		line, err := f.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, ">") {
+			f.header = line[1:]
+			f.hasHeader = true
+		}
+	}
+	rec := new(fastaRecord)
+	fields := strings.SplitN(strings.TrimSpace(f.header), " ", 2)
+	rec.ID = fields[0]
+	if len(fields) == 2 {
+		rec.Description = strings.TrimSpace(fields[1])
+	}
+	f.header = ""
+	f.hasHeader = false
+	var residues bytes.Buffer
+	for {
+		line, err := f.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, ">") {
+			f.header = line[1:]
+			f.hasHeader = true
+			break
+		}
+		residues.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	rec.Residues = residues.Bytes()
+	return rec, nil
+}
+
+func (f *fastaReader) readLine() (string, error) {
+	line, err := f.rd.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
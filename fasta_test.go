@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFasta = `; a comment before the first record
+>DDB0232428 chromosome 1 of Dictyostelium
+ACGT ACGT
+acgt
+>
+TTTT
+>DDB0232429
+>DDB0232430   mitochondrial  genome
+GG
+CC
+`
+
+func readFastaRecords(t *testing.T, r io.Reader) []*fastaRecord {
+	fr, err := newFastaReader(r)
+	if err != nil {
+		t.Fatalf("unable to open fasta %s", err)
+	}
+	var recs []*fastaRecord
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("unable to read record %s", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestFastaReader(t *testing.T) {
+	expected := []*fastaRecord{
+		{ID: "DDB0232428", Description: "chromosome 1 of Dictyostelium", Residues: []byte("ACGTACGTacgt")},
+		{ID: "", Residues: []byte("TTTT")},
+		{ID: "DDB0232429"},
+		{ID: "DDB0232430", Description: "mitochondrial  genome", Residues: []byte("GGCC")},
+	}
+	recs := readFastaRecords(t, strings.NewReader(strings.Replace(testFasta, "\n", "\r\n", 2)))
+	if len(recs) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(recs))
+	}
+	for i, rec := range recs {
+		if rec.ID != expected[i].ID || rec.Description != expected[i].Description {
+			t.Errorf("record %d: expected header %q, got %q", i, expected[i].Header(), rec.Header())
+		}
+		if !bytes.Equal(rec.Residues, expected[i].Residues) {
+			t.Errorf("record %d: expected residues %s, got %s", i, expected[i].Residues, rec.Residues)
+		}
+	}
+	if h := recs[0].Header(); h != "DDB0232428 chromosome 1 of Dictyostelium" {
+		t.Fatalf("unexpected header %q", h)
+	}
+	if h := recs[2].Header(); h != "DDB0232429" {
+		t.Fatalf("unexpected header %q", h)
+	}
+}
+
+func TestFastaReaderGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte(testFasta)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	plain := readFastaRecords(t, strings.NewReader(testFasta))
+	compressed := readFastaRecords(t, &buf)
+	if !reflect.DeepEqual(plain, compressed) {
+		t.Fatalf("expected the same records from gzip, got %+v", compressed)
+	}
+}
+
+func TestFastaReaderEmpty(t *testing.T) {
+	for _, ct := range []string{"", "\n\n", "ACGT\n"} {
+		if recs := readFastaRecords(t, strings.NewReader(ct)); len(recs) != 0 {
+			t.Errorf("%q: expected no records, got %d", ct, len(recs))
+		}
+	}
+}
+
+func TestSequenceRecordId(t *testing.T) {
+	sl := &sequenceLoader{}
+	if _, ok := sl.recordId(&fastaRecord{Residues: []byte("ACGT")}); ok {
+		t.Fatal("expected a record without id to be unmatched")
+	}
+	if id, ok := sl.recordId(&fastaRecord{ID: "DDB0232428"}); !ok || id != "DDB0232428" {
+		t.Fatalf("expected the id of the record, got %q", id)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	formatGAF  = "gaf"
+	formatGPAD = "gpad"
+)
+
+// ecoEvidenceCodes maps the ECO ids of GPAD to the GO evidence codes of GAF
+// after the default mapping of the GO consortium, the automatic assertions
+// that are referred by more than one ECO id are all IEA
+var ecoEvidenceCodes = map[string]string{
+	"ECO:0000269": "EXP",
+	"ECO:0000314": "IDA",
+	"ECO:0000353": "IPI",
+	"ECO:0000315": "IMP",
+	"ECO:0000316": "IGI",
+	"ECO:0000270": "IEP",
+	"ECO:0006056": "HTP",
+	"ECO:0007005": "HDA",
+	"ECO:0007001": "HMP",
+	"ECO:0007003": "HGI",
+	"ECO:0007007": "HEP",
+	"ECO:0000250": "ISS",
+	"ECO:0000266": "ISO",
+	"ECO:0000247": "ISA",
+	"ECO:0000255": "ISM",
+	"ECO:0000317": "IGC",
+	"ECO:0000318": "IBA",
+	"ECO:0000319": "IBD",
+	"ECO:0000320": "IKR",
+	"ECO:0000321": "IRD",
+	"ECO:0000245": "RCA",
+	"ECO:0000304": "TAS",
+	"ECO:0000303": "NAS",
+	"ECO:0000305": "IC",
+	"ECO:0000307": "ND",
+	"ECO:0000501": "IEA",
+	"ECO:0007669": "IEA",
+	"ECO:0000256": "IEA",
+	"ECO:0000265": "IEA",
+	"ECO:0000203": "IEA",
+}
+
+// goAnnotation is a single line of GAF 2.x or GPAD 1.1 file
+type goAnnotation struct {
+	DB         string
+	ObjectID   string
+	Qualifiers []string
+	Not        bool
+	GOID       string
+	References []string
+	Evidence   string
+	With       []string
+	Date       string
+	AssignedBy string
+	Extensions []string
+	Line       int
+	Text       string
+}
+
+// goRejectedLine is a line of an annotation file that could not be parsed
+type goRejectedLine struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+type goAnnotationFile struct {
+	Name        string
+	Format      string
+	Annotations []*goAnnotation
+	Rejects     []*goRejectedLine
+}
+
+// annotationFormat guesses the format from the name of the file, GAF is the
+// default
+func annotationFormat(file string) string {
+	name := strings.TrimSuffix(file, ".gz")
+	if strings.HasSuffix(name, ".gpad") || strings.HasSuffix(name, ".gpa") {
+		return formatGPAD
+	}
+	return formatGAF
+}
+
+func readAnnotationFile(file string) (*goAnnotationFile, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var rd io.Reader = r
+	if strings.HasSuffix(file, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip file %s %s", file, err)
+		}
+		defer gr.Close()
+		rd = gr
+	}
+	af, err := readAnnotations(rd, annotationFormat(file))
+	if err != nil {
+		return nil, fmt.Errorf("error in file %s %s", file, err)
+	}
+	af.Name = file
+	return af, nil
+}
+
+// readAnnotations parses the annotations, a malformed line is kept as
+// rejected instead of failing the whole file
+func readAnnotations(r io.Reader, format string) (*goAnnotationFile, error) {
+	af := &goAnnotationFile{Format: format}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(text, "!") {
+			switch {
+			case strings.HasPrefix(text, "!gpa-version"):
+				af.Format = formatGPAD
+			case strings.HasPrefix(text, "!gaf-version"):
+				af.Format = formatGAF
+			}
+			continue
+		}
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+		var a *goAnnotation
+		var err error
+		if af.Format == formatGPAD {
+			a, err = parseGPADLine(text)
+		} else {
+			a, err = parseGAFLine(text)
+		}
+		if err != nil {
+			af.Rejects = append(af.Rejects, &goRejectedLine{Line: line, Text: text, Reason: err.Error()})
+			continue
+		}
+		a.Line = line
+		a.Text = text
+		af.Annotations = append(af.Annotations, a)
+	}
+	return af, scanner.Err()
+}
+
+func parseGAFLine(text string) (*goAnnotation, error) {
+	cols := strings.Split(text, "\t")
+	if len(cols) < 15 {
+		return nil, fmt.Errorf("expected at least 15 columns, got %d", len(cols))
+	}
+	a := &goAnnotation{
+		DB:         cols[0],
+		ObjectID:   cols[1],
+		GOID:       cols[4],
+		References: splitGOColumn(cols[5], "|"),
+		Evidence:   cols[6],
+		With:       splitGOColumn(cols[7], "|", ","),
+		Date:       cols[13],
+		AssignedBy: cols[14],
+	}
+	setGOQualifiers(a, cols[3])
+	if len(cols) > 15 {
+		a.Extensions = splitGOColumn(cols[15], "|")
+	}
+	return a, checkAnnotation(a)
+}
+
+func parseGPADLine(text string) (*goAnnotation, error) {
+	cols := strings.Split(text, "\t")
+	if len(cols) < 10 {
+		return nil, fmt.Errorf("expected at least 10 columns, got %d", len(cols))
+	}
+	a := &goAnnotation{
+		DB:         cols[0],
+		ObjectID:   cols[1],
+		GOID:       cols[3],
+		References: splitGOColumn(cols[4], "|"),
+		Evidence:   cols[5],
+		With:       splitGOColumn(cols[6], "|", ","),
+		Date:       cols[8],
+		AssignedBy: cols[9],
+	}
+	setGOQualifiers(a, cols[2])
+	if len(cols) > 10 {
+		a.Extensions = splitGOColumn(cols[10], "|")
+	}
+	if err := checkAnnotation(a); err != nil {
+		return a, err
+	}
+	// the evidence is stored as GO code whatever the format of the file
+	code, ok := ecoEvidenceCodes[a.Evidence]
+	if !ok {
+		return a, fmt.Errorf("no GO evidence code for %s", a.Evidence)
+	}
+	a.Evidence = code
+	return a, nil
+}
+
+func setGOQualifiers(a *goAnnotation, col string) {
+	for _, q := range splitGOColumn(col, "|") {
+		if q == "NOT" {
+			a.Not = true
+			continue
+		}
+		a.Qualifiers = append(a.Qualifiers, q)
+	}
+}
+
+func checkAnnotation(a *goAnnotation) error {
+	switch {
+	case len(a.ObjectID) == 0:
+		return fmt.Errorf("missing object id")
+	case !strings.HasPrefix(a.GOID, "GO:"):
+		return fmt.Errorf("invalid GO id %s", a.GOID)
+	case len(a.Evidence) == 0:
+		return fmt.Errorf("missing evidence code")
+	}
+	return nil
+}
+
+// splitGOColumn splits a column by any of the separators and drops the
+// empty values
+func splitGOColumn(col string, seps ...string) []string {
+	values := []string{col}
+	for _, sep := range seps {
+		var split []string
+		for _, v := range values {
+			split = append(split, strings.Split(v, sep)...)
+		}
+		values = split
+	}
+	var result []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) > 0 {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testGAF = `!gaf-version 2.1
+dictyBase	DDB_G0267178	pdsA	enables	GO:0004115	PMID:2542|GO_REF:0000024	IDA		F	phosphodiesterase		gene	taxon:44689	20090910	dictyBase	occurs_in(CL:0000000)
+dictyBase	DDB_G0267178	pdsA	NOT|involved_in	GO:0006935	PMID:2542	IMP	dictyBase:DDB_G0276577, UniProt:P34261|UniProt:P1	P	phosphodiesterase		gene	taxon:44689	20090910	dictyBase
+dictyBase	DDB_G0267178	pdsA	enables	GO:0004115	PMID:2542	IDA		F
+dictyBase		pdsA	enables	GO:0004115	PMID:2542	IDA		F	phosphodiesterase		gene	taxon:44689	20090910	dictyBase
+
+`
+
+const testGPAD = `!gpa-version 1.1
+dictyBase	DDB_G0267178	enables	GO:0004115	PMID:2542|GO_REF:0000024	ECO:0000314		gene	20090910	dictyBase	occurs_in(CL:0000000)
+dictyBase	DDB_G0267178	NOT|involved_in	GO:0006935	PMID:2542	ECO:0000315	dictyBase:DDB_G0276577, UniProt:P34261|UniProt:P1		20090910	dictyBase
+dictyBase	DDB_G0267178	enables	GO:0004115	PMID:2542	ECO:0000501		gene	20090910	dictyBase
+dictyBase	DDB_G0267178	enables	GO:0004115	PMID:2542	ECO:9999999		gene	20090910	dictyBase
+dictyBase	DDB_G0267178	enables	GO:0004115	PMID:2542	ECO:0000314
+`
+
+func TestReadGAF(t *testing.T) {
+	af, err := readAnnotations(strings.NewReader(testGAF), formatGAF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(af.Annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %d", len(af.Annotations))
+	}
+	a := af.Annotations[0]
+	expected := &goAnnotation{
+		DB:         "dictyBase",
+		ObjectID:   "DDB_G0267178",
+		Qualifiers: []string{"enables"},
+		GOID:       "GO:0004115",
+		References: []string{"PMID:2542", "GO_REF:0000024"},
+		Evidence:   "IDA",
+		Date:       "20090910",
+		AssignedBy: "dictyBase",
+		Extensions: []string{"occurs_in(CL:0000000)"},
+		Line:       2,
+		Text:       strings.Split(testGAF, "\n")[1],
+	}
+	if !reflect.DeepEqual(a, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, a)
+	}
+	n := af.Annotations[1]
+	if !n.Not || !reflect.DeepEqual(n.Qualifiers, []string{"involved_in"}) {
+		t.Fatalf("expected a negated involved_in, got %v %v", n.Not, n.Qualifiers)
+	}
+	with := []string{"dictyBase:DDB_G0276577", "UniProt:P34261", "UniProt:P1"}
+	if !reflect.DeepEqual(n.With, with) {
+		t.Fatalf("expected with %v, got %v", with, n.With)
+	}
+	if len(n.Extensions) != 0 {
+		t.Fatalf("expected no extensions, got %v", n.Extensions)
+	}
+	rejects := map[int]string{
+		4: "expected at least 15 columns, got 9",
+		5: "missing object id",
+	}
+	if len(af.Rejects) != len(rejects) {
+		t.Fatalf("expected %d rejected lines, got %d", len(rejects), len(af.Rejects))
+	}
+	for _, r := range af.Rejects {
+		if rejects[r.Line] != r.Reason {
+			t.Errorf("line %d: expected reason %q, got %q", r.Line, rejects[r.Line], r.Reason)
+		}
+	}
+}
+
+func TestReadGPAD(t *testing.T) {
+	// the version header overrides the format guessed from the name
+	af, err := readAnnotations(strings.NewReader(testGPAD), formatGAF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if af.Format != formatGPAD {
+		t.Fatalf("expected gpad format, got %s", af.Format)
+	}
+	if len(af.Annotations) != 3 {
+		t.Fatalf("expected 3 annotations, got %d", len(af.Annotations))
+	}
+	gaf, err := readAnnotations(strings.NewReader(testGAF), formatGAF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same annotations of both formats are stored alike
+	for i, ga := range gaf.Annotations {
+		a := af.Annotations[i]
+		if a.Evidence != ga.Evidence {
+			t.Errorf("annotation %d: expected evidence %s, got %s", i, ga.Evidence, a.Evidence)
+		}
+		if a.Not != ga.Not || !reflect.DeepEqual(a.Qualifiers, ga.Qualifiers) {
+			t.Errorf("annotation %d: expected qualifiers %v, got %v", i, ga.Qualifiers, a.Qualifiers)
+		}
+		if !reflect.DeepEqual(a.With, ga.With) || !reflect.DeepEqual(a.References, ga.References) {
+			t.Errorf("annotation %d: expected with %v and references %v, got %v %v",
+				i, ga.With, ga.References, a.With, a.References)
+		}
+		if !reflect.DeepEqual(a.Extensions, ga.Extensions) {
+			t.Errorf("annotation %d: expected extensions %v, got %v", i, ga.Extensions, a.Extensions)
+		}
+	}
+	if af.Annotations[2].Evidence != "IEA" {
+		t.Fatalf("expected IEA for an automatic assertion, got %s", af.Annotations[2].Evidence)
+	}
+	rejects := map[int]string{
+		5: "no GO evidence code for ECO:9999999",
+		6: "expected at least 10 columns, got 6",
+	}
+	if len(af.Rejects) != len(rejects) {
+		t.Fatalf("expected %d rejected lines, got %d", len(rejects), len(af.Rejects))
+	}
+	for _, r := range af.Rejects {
+		if rejects[r.Line] != r.Reason {
+			t.Errorf("line %d: expected reason %q, got %q", r.Line, rejects[r.Line], r.Reason)
+		}
+	}
+}
+
+func TestParseAnnotationMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		parse func(string) (*goAnnotation, error)
+		text  string
+		err   string
+	}{
+		{
+			name:  "gaf invalid go id",
+			parse: parseGAFLine,
+			text:  "dictyBase\tDDB_G1\tp\tenables\tGO0004115\tPMID:1\tIDA\t\tF\t\t\tgene\ttaxon:44689\t20090910\tdictyBase",
+			err:   "invalid GO id GO0004115",
+		},
+		{
+			name:  "gaf missing evidence",
+			parse: parseGAFLine,
+			text:  "dictyBase\tDDB_G1\tp\tenables\tGO:0004115\tPMID:1\t\t\tF\t\t\tgene\ttaxon:44689\t20090910\tdictyBase",
+			err:   "missing evidence code",
+		},
+		{
+			name:  "gpad missing evidence",
+			parse: parseGPADLine,
+			text:  "dictyBase\tDDB_G1\tenables\tGO:0004115\tPMID:1\t\t\tgene\t20090910\tdictyBase",
+			err:   "missing evidence code",
+		},
+		{
+			name:  "gpad missing object id",
+			parse: parseGPADLine,
+			text:  "dictyBase\t\tenables\tGO:0004115\tPMID:1\tECO:0000314\t\tgene\t20090910\tdictyBase",
+			err:   "missing object id",
+		},
+	} {
+		if _, err := tc.parse(tc.text); err == nil || err.Error() != tc.err {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestAnnotationFormat(t *testing.T) {
+	for file, format := range map[string]string{
+		"gene_association.dictyBase":    formatGAF,
+		"dicty.gaf.gz":                  formatGAF,
+		"dicty.gpad":                    formatGPAD,
+		"dicty.gpa.gz":                  formatGPAD,
+		"/data/annotations/dicty.gpad1": formatGAF,
+	} {
+		if f := annotationFormat(file); f != format {
+			t.Errorf("%s: expected %s, got %s", file, format, f)
+		}
+	}
+}
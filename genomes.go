@@ -1,69 +1,544 @@
 package main
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
 	"gopkg.in/urfave/cli.v1"
 )
 
+const upsertFeature = `
+INSERT INTO feature (organism_id,name,uniquename,type_id,seqlen)
+VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (organism_id,uniquename,type_id)
+DO UPDATE
+SET name = $2,
+	seqlen = COALESCE($5,feature.seqlen)
+RETURNING feature_id
+	`
+
+const upsertCv = `
+INSERT INTO cv (name) VALUES ($1)
+ON CONFLICT (name)
+DO UPDATE SET name = EXCLUDED.name
+RETURNING cv_id
+	`
+
+const upsertDb = `
+INSERT INTO db (name) VALUES ($1)
+ON CONFLICT (name)
+DO UPDATE SET name = EXCLUDED.name
+RETURNING db_id
+	`
+
+const upsertDbxref = `
+INSERT INTO dbxref (db_id,accession) VALUES ($1,$2)
+ON CONFLICT (db_id,accession,version)
+DO UPDATE SET accession = EXCLUDED.accession
+RETURNING dbxref_id
+	`
+
+const insertFeatureRelationship = `
+INSERT INTO feature_relationship (subject_id,object_id,type_id)
+VALUES ($1,$2,$3)
+ON CONFLICT DO NOTHING
+	`
+
+const sequenceTypeSQL = `
+SELECT cvterm.cvterm_id FROM cvterm
+JOIN cv ON cv.cv_id = cvterm.cv_id
+WHERE cv.name = $1 AND cvterm.name = $2 AND cvterm.is_obsolete = 0
+	`
+
+// cv namespaces searched for the relationship types of features
+var relationshipCvs = []string{"sequence", "relationship", "ro"}
+
+const (
+	// cv of the types of feature properties, created as needed
+	featurePropCv = "feature_property"
+	// db of the dbxrefs of the property types
+	internalDb = "internal"
+)
+
+func validateGenomes(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(strings.Fields(c.String("organism"))) != 2 {
+		return cli.NewExitError("organism should be given as genus and species", 2)
+	}
+	if c.Int("batch-size") < 1 {
+		return cli.NewExitError("batch-size should be at least 1", 2)
+	}
+	return nil
+}
+
 func GenomesAction(c *cli.Context) error {
-	//if hasEtcd(c) {
-	//key := c.String("key-watch")
-	//if err := waitForEtcd(key, c); err != nil {
-	//log.WithFields(log.Fields{
-	//"type": "genome-loader",
-	//"key":  key,
-	//"kind": "etcd-wait",
-	//}).Error(err)
-	//return cli.NewExitError(err.Error(), 2)
-	//}
-	//log.WithFields(log.Fields{
-	//"type": "genome-loader",
-	//"key":  key,
-	//"kind": "etcd-wait",
-	//}).Info("wait for key is over")
-	//}
-	//if !definedPostgres(c) {
-	//log.WithFields(log.Fields{
-	//"type": "genome-loader",
-	//"kind": "command-options",
-	//}).Error("postgres options are not defined")
-	//return cli.NewExitError("postgres options are not defined", 2)
-	//}
-	//if !definedChadoUser(c) {
-	//log.WithFields(log.Fields{
-	//"type": "genome-loader",
-	//"kind": "command-options",
-	//}).Error("chado database options are not defined")
-	//return cli.NewExitError("chado database options are not defined", 2)
-	//}
-
-	//dsn := getPostgresDsn(c)
-	//ml, err := exec.LookPath("modware-load")
-	//if err != nil {
-	//log.WithFields(log.Fields{
-	//"type": "binary-lookup",
-	//"name": "modware-load",
-	//}).Error(err)
-	//return cli.NewExitError(err.Error(), 2)
-	//}
-	//// basic command line options for all genomes
-	//gcmd := []string{
-	//"adhocobo2chado",
-	//"--dsn",
-	//dsn,
-	//"--user",
-	//c.GlobalString("chado-user"),
-	//"--password",
-	//c.GlobalString("chado-pass"),
-	//"--log_level",
-	//"debug",
-	//"--include_metadata",
-	//"--input",
-	//filepath.Join(c.String("folder"), "cv_property.obo"),
-	//}
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "genomes")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	files, err := listGFF3Files(c.String("folder"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type":   "dir-lookup",
+			"folder": c.String("folder"),
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if len(files) == 0 {
+		log.WithFields(logrus.Fields{
+			"type":   "dir-lookup",
+			"folder": c.String("folder"),
+		}).Warn("no gff3 file to load")
+		return nil
+	}
+	// read and validate all files before writing anything
+	var gffs []*gffFile
+	for _, f := range files {
+		g, err := readGFF3File(f)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "gff3-parser",
+				"file": f,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		if err := g.validate(); err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "gff3-validation",
+				"file": f,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		gffs = append(gffs, g)
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	batch, err := newBatchTx(c, dbh, log, c.Int("batch-size"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	defer batch.rollback()
+	gl := newGenomeLoader(c, batch, log)
+	if err := gl.resolve(gffs); err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "gff3-validation",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	for _, g := range gffs {
+		if err := gl.load(g); err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "genome-loader",
+				"file": g.Name,
+			}).Error(err)
+			if err == errCancelled {
+				return err
+			}
+			return cli.NewExitError(err.Error(), 2)
+		}
+		log.WithFields(logrus.Fields{
+			"type":     "genome-loader",
+			"file":     g.Name,
+			"features": len(g.Features),
+		}).Info("loaded gff3 file")
+	}
+	if err := batch.commit(true); err != nil {
+		if err == errCancelled {
+			return err
+		}
+		return cli.NewExitError(err.Error(), 2)
+	}
+	gl.logCounts()
 	return nil
 }
 
-func GenomeAnnoAction(c *cli.Context) error {
+func listGFF3Files(folder string) ([]string, error) {
+	all, err := listFiles(folder)
+	if err != nil {
+		return all, err
+	}
+	var files []string
+	for _, f := range all {
+		name := strings.TrimSuffix(f, ".gz")
+		switch filepath.Ext(name) {
+		case ".gff3", ".gff":
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// genomeLoader writes the features of gff3 files in batched transactions
+type genomeLoader struct {
+	c          *cli.Context
+	batch      *batchTx
+	log        *logrus.Logger
+	organismId int64
+	typeIds    map[string]int64
+	relIds     map[string]int64
+	propIds    map[string]int64
+	dbxrefIds  map[string]int64
+	featureIds map[string]int64
+	counts     map[string]int64
+	types      []string
+}
+
+func newGenomeLoader(c *cli.Context, batch *batchTx, log *logrus.Logger) *genomeLoader {
+	return &genomeLoader{
+		c:          c,
+		batch:      batch,
+		log:        log,
+		typeIds:    make(map[string]int64),
+		relIds:     make(map[string]int64),
+		propIds:    make(map[string]int64),
+		dbxrefIds:  make(map[string]int64),
+		featureIds: make(map[string]int64),
+		counts:     make(map[string]int64),
+	}
+}
+
+// resolve looks up the organism and every cvterm needed by the files and
+// makes sure that all landmarks are known. A landmark that is not a feature
+// of the file is looked up in the database, otherwise it is created from the
+// sequence-region pragma. Only the cv and db for the property types are
+// written here.
+func (gl *genomeLoader) resolve(gffs []*gffFile) error {
+	genus, species := splitOrganism(gl.c.String("organism"))
+	err := gl.batch.tx.Select("organism_id").
+		From("organism").
+		Where("genus = $1 AND species = $2", genus, species).
+		QueryScalar(&gl.organismId)
+	if err != nil {
+		if err == dat.ErrNotFound {
+			return fmt.Errorf("organism %s is not loaded", gl.c.String("organism"))
+		}
+		return fmt.Errorf("error in looking up organism %s", err)
+	}
+	var errs []string
+	var types []string
+	props := false
+	for _, g := range gffs {
+		types = append(types, g.Types()...)
+		for _, f := range g.Features {
+			if len(f.Properties()) > 0 {
+				props = true
+			}
+		}
+		for _, l := range g.Landmarks() {
+			if _, ok := g.Feature(l); ok {
+				continue
+			}
+			if _, ok := gl.featureIds[l]; ok {
+				continue
+			}
+			id, err := gl.landmarkId(l)
+			if err == nil {
+				gl.featureIds[l] = id
+				continue
+			}
+			if _, ok := g.Regions[l]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s", g.Name, err))
+				continue
+			}
+			types = append(types, "region")
+		}
+	}
+	for _, t := range types {
+		if _, ok := gl.typeIds[t]; ok {
+			continue
+		}
+		var id int64
+		err := gl.batch.tx.SQL(sequenceTypeSQL, "sequence", t).QueryScalar(&id)
+		if err != nil {
+			if err == dat.ErrNotFound {
+				errs = append(errs, fmt.Sprintf("feature type %s is not in the sequence ontology", t))
+				continue
+			}
+			return fmt.Errorf("error in looking up feature type %s %s", t, err)
+		}
+		gl.typeIds[t] = id
+	}
+	for _, r := range []string{"part_of", "derives_from"} {
+		id, err := gl.relationshipType(r)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		gl.relIds[r] = id
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to load gff3 files\n%s", strings.Join(errs, "\n"))
+	}
+	if props {
+		return gl.propertySupport()
+	}
 	return nil
+}
+
+// propertySupport creates the cv and db that findOrCreateCvterm expects for
+// the types of feature properties
+func (gl *genomeLoader) propertySupport() error {
+	if _, err := gl.batch.tx.SQL(upsertCv, featurePropCv).Exec(); err != nil {
+		return fmt.Errorf("error in finding or creating cv %s %s", featurePropCv, err)
+	}
+	if _, err := gl.batch.tx.SQL(upsertDb, internalDb).Exec(); err != nil {
+		return fmt.Errorf("error in finding or creating db %s %s", internalDb, err)
+	}
+	return nil
+}
+
+func (gl *genomeLoader) relationshipType(name string) (int64, error) {
+	var id int64
+	for _, cv := range relationshipCvs {
+		err := gl.batch.tx.SQL(sequenceTypeSQL, cv, name).QueryScalar(&id)
+		if err == nil {
+			return id, nil
+		}
+		if err != dat.ErrNotFound {
+			return id, fmt.Errorf("error in looking up relationship %s %s", name, err)
+		}
+	}
+	return id, fmt.Errorf("relationship type %s is not found in any of %s", name, strings.Join(relationshipCvs, ","))
+}
+
+func (gl *genomeLoader) landmarkId(name string) (int64, error) {
+	var id int64
+	err := gl.batch.tx.Select("feature_id").
+		From("feature").
+		Where("organism_id = $1 AND uniquename = $2", gl.organismId, name).
+		QueryScalar(&id)
+	if err != nil {
+		if err == dat.ErrNotFound {
+			return id, fmt.Errorf("landmark %s is neither in the file nor in the database", name)
+		}
+		return id, fmt.Errorf("error in looking up landmark %s %s", name, err)
+	}
+	return id, nil
+}
+
+// load writes the landmarks first, followed by the rest of the features
+// and finally their relationships
+func (gl *genomeLoader) load(g *gffFile) error {
+	done := make(map[string]bool)
+	for _, l := range g.Landmarks() {
+		if _, ok := gl.featureIds[l]; ok {
+			continue
+		}
+		if f, ok := g.Feature(l); ok {
+			if err := gl.loadFeature(f, dat.NullInt64From(f.Locations[0].Fmax)); err != nil {
+				return err
+			}
+			done[l] = true
+			continue
+		}
+		region := &gffFeature{
+			Uniquename: l,
+			Type:       "region",
+			Attributes: make(map[string][]string),
+		}
+		if err := gl.loadFeature(region, dat.NullInt64From(g.Regions[l])); err != nil {
+			return err
+		}
+	}
+	for _, f := range g.Features {
+		if done[f.Uniquename] {
+			continue
+		}
+		if err := gl.loadFeature(f, dat.NullInt64{}); err != nil {
+			return err
+		}
+	}
+	for _, f := range g.Features {
+		if err := gl.loadRelationships(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFeature upserts the feature by its uniquename and replaces all of its
+// locations, properties and dbxrefs. Its relationships to other features
+// are removed here and added back once every feature of the file is loaded.
+func (gl *genomeLoader) loadFeature(f *gffFeature, seqlen dat.NullInt64) error {
+	if isCancelled(gl.c) {
+		return errCancelled
+	}
+	var name dat.NullString
+	if n := f.Name(); len(n) > 0 {
+		name = dat.NullStringFrom(n)
+	}
+	var id int64
+	err := gl.batch.tx.SQL(
+		upsertFeature, gl.organismId, name, f.Uniquename, gl.typeIds[f.Type], seqlen,
+	).QueryScalar(&id)
+	if err != nil {
+		return fmt.Errorf("error in upserting feature %s %s", f.Uniquename, err)
+	}
+	gl.featureIds[f.Uniquename] = id
+	gl.batch.add("feature", changeUpsert, 1)
+	gl.batch.report.sample("feature", changeUpsert, map[string]interface{}{
+		"feature_id": id,
+		"uniquename": f.Uniquename,
+		"type":       f.Type,
+	})
+	for _, t := range []string{"featureloc", "featureprop", "feature_dbxref"} {
+		res, err := gl.batch.tx.DeleteFrom(t).Where("feature_id = $1", id).Exec()
+		if err != nil {
+			return fmt.Errorf("error in removing %s of %s %s", t, f.Uniquename, err)
+		}
+		gl.batch.add(t, changeDelete, res.RowsAffected)
+	}
+	res, err := gl.batch.tx.DeleteFrom("feature_relationship").Where("subject_id = $1", id).Exec()
+	if err != nil {
+		return fmt.Errorf("error in removing feature_relationship of %s %s", f.Uniquename, err)
+	}
+	gl.batch.add("feature_relationship", changeDelete, res.RowsAffected)
+	if err := gl.loadLocations(id, f); err != nil {
+		return err
+	}
+	if err := gl.loadProperties(id, f); err != nil {
+		return err
+	}
+	if err := gl.loadDbxrefs(id, f); err != nil {
+		return err
+	}
+	if _, ok := gl.counts[f.Type]; !ok {
+		gl.types = append(gl.types, f.Type)
+	}
+	gl.counts[f.Type]++
+	return gl.batch.done()
+}
+
+func (gl *genomeLoader) loadLocations(id int64, f *gffFeature) error {
+	for i, l := range f.Locations {
+		// a landmark is not located on itself
+		if l.Seqid == f.Uniquename {
+			continue
+		}
+		var strand, phase dat.NullInt64
+		switch l.Strand {
+		case "+":
+			strand = dat.NullInt64From(1)
+		case "-":
+			strand = dat.NullInt64From(-1)
+		}
+		switch l.Phase {
+		case "0", "1", "2":
+			phase = dat.NullInt64From(int64(l.Phase[0] - '0'))
+		}
+		_, err := gl.batch.tx.InsertInto("featureloc").
+			Columns("feature_id", "srcfeature_id", "fmin", "fmax", "strand", "phase", "rank").
+			Values(id, gl.featureIds[l.Seqid], l.Fmin, l.Fmax, strand, phase, i).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting location of %s %s", f.Uniquename, err)
+		}
+		gl.batch.add("featureloc", changeInsert, 1)
+	}
+	return nil
+}
+
+func (gl *genomeLoader) loadProperties(id int64, f *gffFeature) error {
+	for _, p := range f.Properties() {
+		typeId, ok := gl.propIds[p]
+		if !ok {
+			tid, err := findOrCreateCvterm(featurePropCv, p, "", gl.batch.tx)
+			if err != nil {
+				return err
+			}
+			gl.propIds[p] = tid
+			typeId = tid
+		}
+		for i, v := range f.Attributes[p] {
+			_, err := gl.batch.tx.InsertInto("featureprop").
+				Columns("feature_id", "type_id", "value", "rank").
+				Values(id, typeId, v, i).
+				Exec()
+			if err != nil {
+				return fmt.Errorf("error in inserting property %s of %s %s", p, f.Uniquename, err)
+			}
+			gl.batch.add("featureprop", changeInsert, 1)
+		}
+	}
+	return nil
+}
+
+func (gl *genomeLoader) loadDbxrefs(id int64, f *gffFeature) error {
+	xrefs := f.Dbxrefs()
+	if len(f.Source) > 0 && f.Source != "." {
+		xrefs = append(xrefs, "GFF_source:"+f.Source)
+	}
+	for _, x := range xrefs {
+		xid, err := gl.dbxrefId(x)
+		if err != nil {
+			return err
+		}
+		_, err = gl.batch.tx.InsertInto("feature_dbxref").
+			Columns("feature_id", "dbxref_id").
+			Values(id, xid).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting dbxref %s of %s %s", x, f.Uniquename, err)
+		}
+		gl.batch.add("feature_dbxref", changeInsert, 1)
+	}
+	return nil
+}
+
+func (gl *genomeLoader) dbxrefId(xref string) (int64, error) {
+	if id, ok := gl.dbxrefIds[xref]; ok {
+		return id, nil
+	}
+	var dbId, id int64
+	parts := strings.SplitN(xref, ":", 2)
+	if err := gl.batch.tx.SQL(upsertDb, parts[0]).QueryScalar(&dbId); err != nil {
+		return id, fmt.Errorf("error in finding or creating db %s %s", parts[0], err)
+	}
+	if err := gl.batch.tx.SQL(upsertDbxref, dbId, parts[1]).QueryScalar(&id); err != nil {
+		return id, fmt.Errorf("error in finding or creating dbxref %s %s", xref, err)
+	}
+	gl.dbxrefIds[xref] = id
+	return id, nil
+}
+
+func (gl *genomeLoader) loadRelationships(f *gffFeature) error {
+	rels := map[string][]string{
+		"part_of":      f.Parents(),
+		"derives_from": f.DerivesFrom(),
+	}
+	for _, r := range []string{"part_of", "derives_from"} {
+		for _, o := range rels[r] {
+			res, err := gl.batch.tx.SQL(
+				insertFeatureRelationship,
+				gl.featureIds[f.Uniquename], gl.featureIds[o], gl.relIds[r],
+			).Exec()
+			if err != nil {
+				return fmt.Errorf("error in relating %s to %s %s", f.Uniquename, o, err)
+			}
+			gl.batch.add("feature_relationship", changeInsert, res.RowsAffected)
+		}
+	}
+	return gl.batch.done()
+}
 
+func (gl *genomeLoader) logCounts() {
+	for _, t := range gl.types {
+		gl.log.WithFields(logrus.Fields{
+			"type":         "genome-loader",
+			"kind":         "feature-count",
+			"feature_type": t,
+			"count":        gl.counts[t],
+		}).Info("loaded features")
+	}
 }
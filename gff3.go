@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// attributes of gff3 that are not stored as feature properties
+var gffReservedAttrs = map[string]bool{
+	"ID":           true,
+	"Name":         true,
+	"Parent":       true,
+	"Derives_from": true,
+	"Dbxref":       true,
+}
+
+// gffLocation is a single line of a feature in gff3 file, the coordinates
+// are converted to the interbase system of chado
+type gffLocation struct {
+	Seqid  string
+	Fmin   int64
+	Fmax   int64
+	Strand string
+	Phase  string
+}
+
+// gffFeature is a feature of gff3 file. Lines sharing the same ID, and lines
+// without ID but with the same type and coordinates, are merged into a
+// single feature.
+type gffFeature struct {
+	Uniquename string
+	Source     string
+	Type       string
+	Locations  []*gffLocation
+	Attributes map[string][]string
+	Line       int
+}
+
+func (f *gffFeature) Name() string {
+	if v, ok := f.Attributes["Name"]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (f *gffFeature) Parents() []string {
+	return f.Attributes["Parent"]
+}
+
+func (f *gffFeature) DerivesFrom() []string {
+	return f.Attributes["Derives_from"]
+}
+
+func (f *gffFeature) Dbxrefs() []string {
+	return f.Attributes["Dbxref"]
+}
+
+// Properties returns the name of attributes that are stored as feature
+// properties in a stable order
+func (f *gffFeature) Properties() []string {
+	var names []string
+	for k := range f.Attributes {
+		if !gffReservedAttrs[k] {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type gffFile struct {
+	Name     string
+	Regions  map[string]int64
+	Features []*gffFeature
+	byName   map[string]*gffFeature
+}
+
+// Landmarks returns the name of reference sequences used by the features
+// in the order of their first appearance
+func (g *gffFile) Landmarks() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range g.Features {
+		for _, l := range f.Locations {
+			if !seen[l.Seqid] {
+				seen[l.Seqid] = true
+				names = append(names, l.Seqid)
+			}
+		}
+	}
+	return names
+}
+
+// Types returns the types of all features of the file
+func (g *gffFile) Types() []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, f := range g.Features {
+		if !seen[f.Type] {
+			seen[f.Type] = true
+			types = append(types, f.Type)
+		}
+	}
+	return types
+}
+
+func (g *gffFile) Feature(name string) (*gffFeature, bool) {
+	f, ok := g.byName[name]
+	return f, ok
+}
+
+func readGFF3File(file string) (*gffFile, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var rd io.Reader = r
+	if strings.HasSuffix(file, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read gzip file %s %s", file, err)
+		}
+		defer gr.Close()
+		rd = gr
+	}
+	g, err := readGFF3(rd)
+	if err != nil {
+		return nil, fmt.Errorf("error in file %s %s", file, err)
+	}
+	g.Name = file
+	return g, nil
+}
+
+func newGFFFile(name string) *gffFile {
+	return &gffFile{
+		Name:    name,
+		Regions: make(map[string]int64),
+		byName:  make(map[string]*gffFeature),
+	}
+}
+
+func readGFF3(r io.Reader) (*gffFile, error) {
+	g := newGFFFile("")
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(text, "##FASTA") {
+			break
+		}
+		if strings.HasPrefix(text, "##sequence-region") {
+			fields := strings.Fields(text)
+			if len(fields) != 4 {
+				return g, fmt.Errorf("line %d: malformed sequence-region pragma", line)
+			}
+			end, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return g, fmt.Errorf("line %d: invalid sequence-region end %s", line, fields[3])
+			}
+			g.Regions[fields[1]] = end
+			continue
+		}
+		if len(strings.TrimSpace(text)) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		f, err := parseGFF3Line(text)
+		if err != nil {
+			return g, fmt.Errorf("line %d: %s", line, err)
+		}
+		f.Line = line
+		if err := g.add(f); err != nil {
+			return g, fmt.Errorf("line %d: %s", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+func (g *gffFile) add(f *gffFeature) error {
+	ef, ok := g.byName[f.Uniquename]
+	if !ok {
+		g.byName[f.Uniquename] = f
+		g.Features = append(g.Features, f)
+		return nil
+	}
+	if ef.Type != f.Type {
+		return fmt.Errorf(
+			"feature %s is of type %s at line %d and %s here",
+			f.Uniquename, ef.Type, ef.Line, f.Type,
+		)
+	}
+	loc := f.Locations[0]
+	for _, l := range ef.Locations {
+		if *l == *loc {
+			loc = nil
+			break
+		}
+	}
+	if loc != nil {
+		ef.Locations = append(ef.Locations, loc)
+	}
+	for k, values := range f.Attributes {
+		for _, v := range values {
+			if !containsString(ef.Attributes[k], v) {
+				ef.Attributes[k] = append(ef.Attributes[k], v)
+			}
+		}
+	}
+	return nil
+}
+
+func parseGFF3Line(text string) (*gffFeature, error) {
+	cols := strings.Split(text, "\t")
+	if len(cols) != 9 {
+		return nil, fmt.Errorf("expected 9 columns, got %d", len(cols))
+	}
+	start, err := strconv.ParseInt(cols[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start %s", cols[3])
+	}
+	end, err := strconv.ParseInt(cols[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end %s", cols[4])
+	}
+	if start > end {
+		return nil, fmt.Errorf("start %d is after end %d", start, end)
+	}
+	seqid, err := url.PathUnescape(cols[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid seqid %s", cols[0])
+	}
+	attrs, err := parseGFF3Attributes(cols[8])
+	if err != nil {
+		return nil, err
+	}
+	f := &gffFeature{
+		Source:     cols[1],
+		Type:       cols[2],
+		Attributes: attrs,
+		Locations: []*gffLocation{{
+			Seqid:  seqid,
+			Fmin:   start - 1,
+			Fmax:   end,
+			Strand: cols[6],
+			Phase:  cols[7],
+		}},
+	}
+	if id, ok := attrs["ID"]; ok && len(id) > 0 {
+		f.Uniquename = id[0]
+	} else {
+		f.Uniquename = autoUniquename(f.Type, f.Locations[0])
+	}
+	return f, nil
+}
+
+// autoUniquename is the uniquename of a feature without any ID
+func autoUniquename(ftype string, l *gffLocation) string {
+	return fmt.Sprintf("%s:%s:%d..%d", ftype, l.Seqid, l.Fmin+1, l.Fmax)
+}
+
+func (f *gffFeature) hasAutoUniquename() bool {
+	return len(f.Locations) > 0 && f.Uniquename == autoUniquename(f.Type, f.Locations[0])
+}
+
+func parseGFF3Attributes(col string) (map[string][]string, error) {
+	attrs := make(map[string][]string)
+	if col == "." {
+		return attrs, nil
+	}
+	for _, pair := range strings.Split(col, ";") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return attrs, fmt.Errorf("malformed attribute %s", pair)
+		}
+		for _, v := range strings.Split(kv[1], ",") {
+			uv, err := url.PathUnescape(v)
+			if err != nil {
+				return attrs, fmt.Errorf("invalid value %s of attribute %s", v, kv[0])
+			}
+			attrs[kv[0]] = append(attrs[kv[0]], uv)
+		}
+	}
+	return attrs, nil
+}
+
+// validate checks that every parent and derived from reference of the
+// features could be resolved within the file
+func (g *gffFile) validate() error {
+	var errs []string
+	for _, f := range g.Features {
+		for _, p := range f.Parents() {
+			if _, ok := g.byName[p]; !ok {
+				errs = append(errs, fmt.Sprintf("line %d: %s has unknown parent %s", f.Line, f.Uniquename, p))
+			}
+		}
+		for _, p := range f.DerivesFrom() {
+			if _, ok := g.byName[p]; !ok {
+				errs = append(errs, fmt.Sprintf("line %d: %s derives from unknown feature %s", f.Line, f.Uniquename, p))
+			}
+		}
+		for _, x := range f.Dbxrefs() {
+			if !strings.Contains(x, ":") {
+				errs = append(errs, fmt.Sprintf("line %d: %s has malformed dbxref %s", f.Line, f.Uniquename, x))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid references in %s\n%s", g.Name, strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// gffEscaper escapes the characters with special meaning in the column
+// nine of gff3
+var gffEscaper = strings.NewReplacer(
+	"%", "%25",
+	";", "%3B",
+	"=", "%3D",
+	"&", "%26",
+	",", "%2C",
+	"\t", "%09",
+	"\n", "%0A",
+	"\r", "%0D",
+)
+
+// writeGFF3 writes the features, a line for every location of them
+func writeGFF3(w io.Writer, g *gffFile) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "##gff-version 3")
+	var regions []string
+	for r := range g.Regions {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	for _, r := range regions {
+		fmt.Fprintf(bw, "##sequence-region %s 1 %d\n", r, g.Regions[r])
+	}
+	for _, f := range g.Features {
+		attrs := f.attributeColumn()
+		source := f.Source
+		if len(source) == 0 {
+			source = "."
+		}
+		for _, l := range f.Locations {
+			strand, phase := l.Strand, l.Phase
+			if len(strand) == 0 {
+				strand = "."
+			}
+			if len(phase) == 0 {
+				phase = "."
+			}
+			fmt.Fprintf(bw, "%s\t%s\t%s\t%d\t%d\t.\t%s\t%s\t%s\n",
+				gffEscaper.Replace(l.Seqid), source, f.Type,
+				l.Fmin+1, l.Fmax, strand, phase, attrs,
+			)
+		}
+	}
+	return bw.Flush()
+}
+
+func (f *gffFeature) attributeColumn() string {
+	var pairs []string
+	if !f.hasAutoUniquename() {
+		pairs = append(pairs, "ID="+gffEscaper.Replace(f.Uniquename))
+	}
+	names := []string{"Name", "Parent", "Derives_from", "Dbxref"}
+	names = append(names, f.Properties()...)
+	for _, n := range names {
+		values := f.Attributes[n]
+		if len(values) == 0 {
+			continue
+		}
+		escaped := make([]string, len(values))
+		for i, v := range values {
+			escaped[i] = gffEscaper.Replace(v)
+		}
+		pairs = append(pairs, n+"="+strings.Join(escaped, ","))
+	}
+	if len(pairs) == 0 {
+		return "."
+	}
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGFF3Line(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		line string
+		err  string
+		want *gffFeature
+	}{
+		{
+			name: "feature with id",
+			line: "DDB0232428\tdictyBase\tgene\t101\t500\t.\t+\t.\tID=DDB_G0267178;Name=pdsA",
+			want: &gffFeature{
+				Uniquename: "DDB_G0267178",
+				Source:     "dictyBase",
+				Type:       "gene",
+				Attributes: map[string][]string{
+					"ID":   {"DDB_G0267178"},
+					"Name": {"pdsA"},
+				},
+				Locations: []*gffLocation{{
+					Seqid: "DDB0232428", Fmin: 100, Fmax: 500, Strand: "+", Phase: ".",
+				}},
+			},
+		},
+		{
+			name: "feature without id",
+			line: "chr%201\t.\tCDS\t10\t20\t.\t-\t2\tParent=DDB0216437",
+			want: &gffFeature{
+				Uniquename: "CDS:chr 1:10..20",
+				Source:     ".",
+				Type:       "CDS",
+				Attributes: map[string][]string{"Parent": {"DDB0216437"}},
+				Locations: []*gffLocation{{
+					Seqid: "chr 1", Fmin: 9, Fmax: 20, Strand: "-", Phase: "2",
+				}},
+			},
+		},
+		{
+			name: "too few columns",
+			line: "DDB0232428\tdictyBase\tgene\t101\t500",
+			err:  "expected 9 columns, got 5",
+		},
+		{
+			name: "invalid start",
+			line: "DDB0232428\t.\tgene\tone\t500\t.\t+\t.\tID=g1",
+			err:  "invalid start one",
+		},
+		{
+			name: "invalid end",
+			line: "DDB0232428\t.\tgene\t1\tfive\t.\t+\t.\tID=g1",
+			err:  "invalid end five",
+		},
+		{
+			name: "start after end",
+			line: "DDB0232428\t.\tgene\t500\t101\t.\t+\t.\tID=g1",
+			err:  "start 500 is after end 101",
+		},
+		{
+			name: "malformed attribute",
+			line: "DDB0232428\t.\tgene\t1\t5\t.\t+\t.\tID=g1;orphan",
+			err:  "malformed attribute orphan",
+		},
+		{
+			name: "bad seqid escape",
+			line: "chr%zz\t.\tgene\t1\t5\t.\t+\t.\tID=g1",
+			err:  "invalid seqid chr%zz",
+		},
+	} {
+		f, err := parseGFF3Line(tc.line)
+		if len(tc.err) > 0 {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, tc.want) {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, tc.want, f)
+		}
+	}
+}
+
+func TestParseGFF3Attributes(t *testing.T) {
+	for _, tc := range []struct {
+		col  string
+		err  bool
+		want map[string][]string
+	}{
+		{col: ".", want: map[string][]string{}},
+		{
+			col: "ID=g1; Note=a%3Bb%2Cc%3Dd ;;Dbxref=GenBank:X1,UniProt:P2",
+			want: map[string][]string{
+				"ID":     {"g1"},
+				"Note":   {"a;b,c=d"},
+				"Dbxref": {"GenBank:X1", "UniProt:P2"},
+			},
+		},
+		{col: "Name=a%26b%09c", want: map[string][]string{"Name": {"a&b\tc"}}},
+		{col: "Alias=one,two,one", want: map[string][]string{"Alias": {"one", "two", "one"}}},
+		{col: "Note", err: true},
+		{col: "Note=100%", err: true},
+	} {
+		attrs, err := parseGFF3Attributes(tc.col)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", tc.col)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.col, err)
+			continue
+		}
+		if !reflect.DeepEqual(attrs, tc.want) {
+			t.Errorf("%q: expected %v, got %v", tc.col, tc.want, attrs)
+		}
+	}
+}
+
+const testGFF3 = `##gff-version 3
+##sequence-region DDB0232428 1 8470628
+DDB0232428	dictyBase	gene	101	500	.	+	.	ID=DDB_G0267178;Name=pdsA
+DDB0232428	dictyBase	mRNA	101	500	.	+	.	ID=DDB0216437;Parent=DDB_G0267178;Dbxref=UniProt:P34261
+DDB0232428	dictyBase	CDS	101	200	.	+	0	ID=cds1;Parent=DDB0216437
+DDB0232428	dictyBase	CDS	301	500	.	+	2	ID=cds1;Parent=DDB0216437;Note=split
+DDB0232428	dictyBase	CDS	301	500	.	+	2	ID=cds1;Parent=DDB0216437;Note=split,coding
+# a comment
+
+##FASTA
+>DDB0232428
+ACGT
+`
+
+func TestReadGFF3Merge(t *testing.T) {
+	g, err := readGFF3(strings.NewReader(testGFF3))
+	if err != nil {
+		t.Fatalf("unable to read gff3 %s", err)
+	}
+	if g.Regions["DDB0232428"] != 8470628 {
+		t.Fatalf("expected the sequence region, got %v", g.Regions)
+	}
+	if len(g.Features) != 3 {
+		t.Fatalf("expected 3 features, got %d", len(g.Features))
+	}
+	cds, ok := g.Feature("cds1")
+	if !ok {
+		t.Fatal("expected feature cds1")
+	}
+	if cds.Line != 5 {
+		t.Fatalf("expected the line of the first location, got %d", cds.Line)
+	}
+	if len(cds.Locations) != 2 {
+		t.Fatalf("expected the duplicate location to be merged, got %d", len(cds.Locations))
+	}
+	if cds.Locations[1].Fmin != 300 || cds.Locations[1].Phase != "2" {
+		t.Fatalf("unexpected second location %+v", cds.Locations[1])
+	}
+	if !reflect.DeepEqual(cds.Attributes["Note"], []string{"split", "coding"}) {
+		t.Fatalf("expected merged notes, got %v", cds.Attributes["Note"])
+	}
+	if !reflect.DeepEqual(cds.Parents(), []string{"DDB0216437"}) {
+		t.Fatalf("expected a single parent, got %v", cds.Parents())
+	}
+	if !reflect.DeepEqual(cds.Properties(), []string{"Note"}) {
+		t.Fatalf("expected Note as the only property, got %v", cds.Properties())
+	}
+	if !reflect.DeepEqual(g.Landmarks(), []string{"DDB0232428"}) {
+		t.Fatalf("unexpected landmarks %v", g.Landmarks())
+	}
+	if !reflect.DeepEqual(g.Types(), []string{"gene", "mRNA", "CDS"}) {
+		t.Fatalf("unexpected types %v", g.Types())
+	}
+	if err := g.validate(); err != nil {
+		t.Fatalf("expected a valid file %s", err)
+	}
+}
+
+func TestGFF3AddConflict(t *testing.T) {
+	g := &gffFile{byName: make(map[string]*gffFeature)}
+	f1, _ := parseGFF3Line("chr1\t.\tgene\t1\t10\t.\t+\t.\tID=g1")
+	f2, _ := parseGFF3Line("chr1\t.\tmRNA\t1\t10\t.\t+\t.\tID=g1")
+	f1.Line = 1
+	if err := g.add(f1); err != nil {
+		t.Fatal(err)
+	}
+	err := g.add(f2)
+	if err == nil || !strings.Contains(err.Error(), "feature g1 is of type gene at line 1 and mRNA here") {
+		t.Fatalf("expected a type conflict, got %v", err)
+	}
+	_, err = readGFF3(strings.NewReader("chr1\t.\tgene\t1\t10\t.\t+\t.\tID=g1\nchr1\t.\tmRNA\t1\t10\t.\t+\t.\tID=g1\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("expected the error at line 2, got %v", err)
+	}
+}
+
+func TestGFF3Validate(t *testing.T) {
+	ct := `chr1	.	gene	1	10	.	+	.	ID=g1
+chr1	.	mRNA	1	10	.	+	.	ID=m1;Parent=g2
+chr1	.	polypeptide	1	10	.	+	.	ID=p1;Derives_from=m2;Dbxref=UniProt
+`
+	g, err := readGFF3(strings.NewReader(ct))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Name = "broken.gff3"
+	err = g.validate()
+	if err == nil {
+		t.Fatal("expected unresolved references")
+	}
+	for _, msg := range []string{
+		"invalid references in broken.gff3",
+		"line 2: m1 has unknown parent g2",
+		"line 3: p1 derives from unknown feature m2",
+		"line 3: p1 has malformed dbxref UniProt",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %s", msg, err)
+		}
+	}
+}
+
+func TestReadGFF3Malformed(t *testing.T) {
+	for _, tc := range []struct {
+		ct  string
+		err string
+	}{
+		{ct: "##sequence-region chr1 1\n", err: "line 1: malformed sequence-region pragma"},
+		{ct: "##sequence-region chr1 1 many\n", err: "line 1: invalid sequence-region end many"},
+		{ct: "##gff-version 3\nchr1\tgene\n", err: "line 2: expected 9 columns, got 2"},
+	} {
+		if _, err := readGFF3(strings.NewReader(tc.ct)); err == nil || err.Error() != tc.err {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestWriteGFF3RoundTrip(t *testing.T) {
+	g, err := readGFF3(strings.NewReader(testGFF3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeGFF3(&buf, g); err != nil {
+		t.Fatalf("unable to write gff3 %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "##gff-version 3" || lines[1] != "##sequence-region DDB0232428 1 8470628" {
+		t.Fatalf("unexpected pragmas %v", lines[:2])
+	}
+	// one line for every location of the merged features
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got %d\n%s", len(lines), buf.String())
+	}
+	rg, err := readGFF3(&buf)
+	if err != nil {
+		t.Fatalf("unable to read written gff3 %s", err)
+	}
+	assertSameGFF3(t, g, rg)
+}
+
+func TestWriteGFF3Escaping(t *testing.T) {
+	g := newGFFFile("escaped.gff3")
+	g.Regions["chr;1"] = 100
+	for _, f := range []*gffFeature{
+		{
+			Uniquename: "g=1",
+			Type:       "gene",
+			Attributes: map[string][]string{
+				"Name": {"a,b"},
+				"Note": {"50% done; see notes", "tab\there", "line\nbreak&more"},
+			},
+			Locations: []*gffLocation{{Seqid: "chr;1", Fmin: 0, Fmax: 10, Strand: "+"}},
+		},
+		{
+			Type:       "region",
+			Attributes: map[string][]string{},
+			Locations:  []*gffLocation{{Seqid: "chr;1", Fmin: 0, Fmax: 100}},
+		},
+	} {
+		if len(f.Uniquename) == 0 {
+			f.Uniquename = autoUniquename(f.Type, f.Locations[0])
+		}
+		if err := g.add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeGFF3(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"##sequence-region chr;1 1 100",
+		"chr%3B1\t.\tgene\t1\t10\t.\t+\t.\tID=g%3D1;Name=a%2Cb;Note=50%25 done%3B see notes,tab%09here,line%0Abreak%26more\n",
+		"chr%3B1\t.\tregion\t1\t100\t.\t.\t.\t.\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in\n%s", s, buf.String())
+		}
+	}
+	rg, err := readGFF3(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("unable to read escaped gff3 %s", err)
+	}
+	for _, f := range g.Features {
+		if f.Type == "gene" {
+			f.Attributes["ID"] = []string{f.Uniquename}
+		}
+	}
+	assertSameGFF3(t, g, rg)
+}
+
+func assertSameGFF3(t *testing.T, expected, got *gffFile) {
+	if !reflect.DeepEqual(expected.Regions, got.Regions) {
+		t.Errorf("expected regions %v, got %v", expected.Regions, got.Regions)
+	}
+	if len(expected.Features) != len(got.Features) {
+		t.Fatalf("expected %d features, got %d", len(expected.Features), len(got.Features))
+	}
+	for i, f := range expected.Features {
+		rf := got.Features[i]
+		if rf.Uniquename != f.Uniquename || rf.Type != f.Type {
+			t.Errorf("feature %d: expected %s %s, got %s %s", i, f.Type, f.Uniquename, rf.Type, rf.Uniquename)
+			continue
+		}
+		if !reflect.DeepEqual(rf.Attributes, f.Attributes) {
+			t.Errorf("%s: expected attributes %v, got %v", f.Uniquename, f.Attributes, rf.Attributes)
+		}
+		if len(rf.Locations) != len(f.Locations) {
+			t.Errorf("%s: expected %d locations, got %d", f.Uniquename, len(f.Locations), len(rf.Locations))
+			continue
+		}
+		for j, l := range f.Locations {
+			el := *l
+			if len(el.Strand) == 0 {
+				el.Strand = "."
+			}
+			if len(el.Phase) == 0 {
+				el.Phase = "."
+			}
+			if *rf.Locations[j] != el {
+				t.Errorf("%s: expected location %+v, got %+v", f.Uniquename, el, *rf.Locations[j])
+			}
+		}
+	}
+}
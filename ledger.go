@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	runStatusRunning = "running"
+	runStatusSuccess = "success"
+	runStatusFailed  = "failed"
+	runStatusDryRun  = "dry-run"
+	runStatusCancel  = "cancelled"
+)
+
+const importRunTable = `
+CREATE TABLE IF NOT EXISTS import_run (
+	import_run_id serial PRIMARY KEY,
+	command text NOT NULL,
+	flags jsonb,
+	remote_path text,
+	etag text,
+	started_at timestamp with time zone NOT NULL,
+	finished_at timestamp with time zone,
+	row_counts jsonb,
+	status text NOT NULL,
+	error text
+)
+`
+
+const importRunColumns = `
+	import_run_id,command,flags::text AS flags,remote_path,etag,
+	started_at,finished_at,row_counts::text AS row_counts,status,error
+`
+
+// flags that are never recorded in the ledger, filled in by secretFlag
+var secretFlags = make(map[string]bool)
+
+// secretFlag marks a flag as one that is never recorded in the ledger
+func secretFlag(f cli.Flag) cli.Flag {
+	for _, n := range strings.Split(f.GetName(), ",") {
+		secretFlags[strings.TrimSpace(n)] = true
+	}
+	return f
+}
+
+// importRun keeps track of a running command until it gets written
+// to the ledger
+type importRun struct {
+	sync.Mutex
+	ID         int64
+	Command    string
+	Flags      map[string]string
+	RemotePath string
+	ETag       string
+	StartedAt  time.Time
+	RowCounts  map[string]int64
+	// the rows changed by an external loader are not known
+	CountsUnknown bool
+}
+
+var activeRuns = struct {
+	sync.Mutex
+	runs map[*cli.Context]*importRun
+}{runs: make(map[*cli.Context]*importRun)}
+
+// finishedRuns keeps the id of a run once it is updated in the ledger, until
+// it is read by the notification that follows
+var finishedRuns = struct {
+	sync.Mutex
+	ids map[*cli.Context]int64
+}{ids: make(map[*cli.Context]int64)}
+
+// recordRun wraps an action so that every invocation of it is recorded in
+// the import_run table along with its outcome.
+func recordRun(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		dbh, err := getPgWrapper(c)
+		if err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("unable to create database connection %s", err),
+				2,
+			)
+		}
+		run := newImportRun(c)
+		if err := startRun(dbh, run); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("unable to record import run %s", err),
+				2,
+			)
+		}
+		activeRuns.Lock()
+		activeRuns.runs[c] = run
+		activeRuns.Unlock()
+		defer func() {
+			activeRuns.Lock()
+			delete(activeRuns.runs, c)
+			activeRuns.Unlock()
+		}()
+		aerr := action(c)
+		if err := finishRun(dbh, run, aerr, c.GlobalBool("dry-run"), isCancelled(c)); err != nil {
+			log, lerr := getLogger(c, "import-run")
+			if lerr == nil {
+				log.WithFields(logrus.Fields{
+					"type":       "import-run",
+					"kind":       "ledger-update",
+					"command":    run.Command,
+					"import_run": run.ID,
+				}).Error(err)
+			}
+		}
+		finishedRuns.Lock()
+		finishedRuns.ids[c] = run.ID
+		finishedRuns.Unlock()
+		return aerr
+	}
+}
+
+// finishedRun returns the id of the run of the context that was last
+// recorded in the ledger, it is forgotten afterwards
+func finishedRun(c *cli.Context) (int64, bool) {
+	finishedRuns.Lock()
+	defer finishedRuns.Unlock()
+	id, ok := finishedRuns.ids[c]
+	delete(finishedRuns.ids, c)
+	return id, ok
+}
+
+func newImportRun(c *cli.Context) *importRun {
+	flags := make(map[string]string)
+	for _, n := range c.GlobalFlagNames() {
+		if secretFlags[n] {
+			continue
+		}
+		if v, ok := c.GlobalGeneric(n).(flag.Value); ok {
+			flags[n] = v.String()
+		}
+	}
+	for _, n := range c.FlagNames() {
+		if secretFlags[n] {
+			continue
+		}
+		if v, ok := c.Generic(n).(flag.Value); ok {
+			flags[n] = v.String()
+		}
+	}
+	return &importRun{
+		Command:    c.Command.Name,
+		Flags:      flags,
+		RemotePath: c.String("remote-path"),
+		StartedAt:  time.Now(),
+		RowCounts:  make(map[string]int64),
+	}
+}
+
+func currentRun(c *cli.Context) (*importRun, bool) {
+	activeRuns.Lock()
+	defer activeRuns.Unlock()
+	run, ok := activeRuns.runs[c]
+	return run, ok
+}
+
+// addRowCount adds to the number of rows of a table that was modified by
+// the running command
+func addRowCount(c *cli.Context, table string, count int64) {
+	run, ok := currentRun(c)
+	if !ok {
+		return
+	}
+	run.Lock()
+	defer run.Unlock()
+	run.RowCounts[table] += count
+}
+
+// unknownRowCounts marks the running command as one that loads through an
+// external command, its row counts are recorded as NULL instead of zero
+func unknownRowCounts(c *cli.Context) {
+	run, ok := currentRun(c)
+	if !ok {
+		return
+	}
+	run.Lock()
+	defer run.Unlock()
+	run.CountsUnknown = true
+}
+
+// setRunSource records the S3 object that the running command imports
+func setRunSource(c *cli.Context, path, etag string) {
+	run, ok := currentRun(c)
+	if !ok {
+		return
+	}
+	run.Lock()
+	defer run.Unlock()
+	run.RemotePath = path
+	run.ETag = etag
+}
+
+func startRun(dbh *runner.DB, run *importRun) error {
+	dat.EnableInterpolation = true
+	if _, err := dbh.SQL(importRunTable).Exec(); err != nil {
+		return fmt.Errorf("unable to create import_run table %s", err)
+	}
+	flags, err := json.Marshal(run.Flags)
+	if err != nil {
+		return err
+	}
+	err = dbh.InsertInto("import_run").
+		Columns("command", "flags", "remote_path", "started_at", "status").
+		Values(run.Command, string(flags), run.RemotePath, run.StartedAt, runStatusRunning).
+		Returning("import_run_id").
+		QueryScalar(&run.ID)
+	if err != nil {
+		return fmt.Errorf("unable to insert import run %s", err)
+	}
+	return nil
+}
+
+func finishRun(dbh *runner.DB, run *importRun, aerr error, dryRun, cancelled bool) error {
+	run.Lock()
+	defer run.Unlock()
+	counts, err := json.Marshal(run.RowCounts)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"remote_path": run.RemotePath,
+		"etag":        run.ETag,
+		"finished_at": time.Now(),
+		"row_counts":  string(counts),
+		"status":      runStatusSuccess,
+	}
+	if run.CountsUnknown {
+		values["row_counts"] = nil
+	}
+	if dryRun {
+		values["status"] = runStatusDryRun
+	}
+	if aerr != nil {
+		values["status"] = runStatusFailed
+		values["error"] = aerr.Error()
+	}
+	if cancelled {
+		values["status"] = runStatusCancel
+	}
+	_, err = dbh.Update("import_run").
+		SetMap(values).
+		Where("import_run_id = $1", run.ID).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("unable to update import run %d %s", run.ID, err)
+	}
+	return nil
+}
+
+func validateHistory(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	return nil
+}
+
+func HistoryAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	if _, err := dbh.SQL(importRunTable).Exec(); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create import_run table %s", err),
+			2,
+		)
+	}
+	if c.Int64("id") > 0 {
+		return showImportRun(c, dbh, c.Int64("id"))
+	}
+	return listImportRuns(c, dbh)
+}
+
+func listImportRuns(c *cli.Context, dbh *runner.DB) error {
+	var runs []*ImportRun
+	query := fmt.Sprintf("SELECT %s FROM import_run", importRunColumns)
+	args := []interface{}{}
+	if len(c.String("command")) > 0 {
+		query += " WHERE command = $1"
+		args = append(args, c.String("command"))
+	}
+	query += fmt.Sprintf(" ORDER BY import_run_id DESC LIMIT %d", c.Int("limit"))
+	err := dbh.SQL(query, args...).QueryStructs(&runs)
+	if err != nil && err != dat.ErrNotFound {
+		return cli.NewExitError(
+			fmt.Sprintf("error in querying import runs %s", err),
+			2,
+		)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMMAND\tSTATUS\tSTARTED\tFINISHED\tREMOTE PATH")
+	for _, r := range runs {
+		finished := ""
+		if r.FinishedAt.Valid {
+			finished = r.FinishedAt.Time.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID, r.Command, r.Status,
+			r.StartedAt.Format(time.RFC3339), finished,
+			r.RemotePath.String,
+		)
+	}
+	return w.Flush()
+}
+
+func showImportRun(c *cli.Context, dbh *runner.DB, id int64) error {
+	r := new(ImportRun)
+	err := dbh.SQL(
+		fmt.Sprintf("SELECT %s FROM import_run WHERE import_run_id = $1", importRunColumns),
+		id,
+	).QueryStruct(r)
+	if err != nil {
+		if err == dat.ErrNotFound {
+			return cli.NewExitError(fmt.Sprintf("no import run with id %d", id), 2)
+		}
+		return cli.NewExitError(
+			fmt.Sprintf("error in querying import run %d %s", id, err),
+			2,
+		)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%d\n", r.ID)
+	fmt.Fprintf(w, "command:\t%s\n", r.Command)
+	fmt.Fprintf(w, "status:\t%s\n", r.Status)
+	fmt.Fprintf(w, "started:\t%s\n", r.StartedAt.Format(time.RFC3339))
+	if r.FinishedAt.Valid {
+		fmt.Fprintf(w, "finished:\t%s\n", r.FinishedAt.Time.Format(time.RFC3339))
+		fmt.Fprintf(w, "duration:\t%s\n", r.FinishedAt.Time.Sub(r.StartedAt))
+	}
+	fmt.Fprintf(w, "remote path:\t%s\n", r.RemotePath.String)
+	fmt.Fprintf(w, "etag:\t%s\n", r.ETag.String)
+	fmt.Fprintf(w, "flags:\t%s\n", r.Flags.String)
+	if r.RowCounts.Valid {
+		fmt.Fprintf(w, "row counts:\t%s\n", r.RowCounts.String)
+	} else {
+		fmt.Fprintln(w, "row counts:\tunknown, loaded by an external command")
+	}
+	if r.Error.Valid {
+		fmt.Fprintf(w, "error:\t%s\n", r.Error.String)
+	}
+	return w.Flush()
+}
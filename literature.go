@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -16,6 +17,14 @@ type cmdFeedback struct {
 	Error  error
 	Output []byte
 	SubCmd string
+	File   string
+}
+
+func validateLiterature(c *cli.Context) error {
+	if err := validateNoDryRun(c, "literature"); err != nil {
+		return err
+	}
+	return validateCommon(c)
 }
 
 func LiteratureAction(c *cli.Context) error {
@@ -75,7 +84,7 @@ func LiteratureAction(c *cli.Context) error {
 
 	ch := make(chan cmdFeedback, len(files))
 	for _, f := range files {
-		runLitCmd(mi, append(litcmd, f), ch)
+		runLitCmd(c, mi, append(litcmd, f), ch)
 	}
 	for i := 0; i < len(files); i++ {
 		fback := <-ch
@@ -92,13 +101,49 @@ func LiteratureAction(c *cli.Context) error {
 			"output":  string(fback.Output),
 			"command": fback.SubCmd,
 		}).Info("loading success")
+		// bibtex2chado does not report its rows, every entry of the file
+		// is a pub
+		entries, err := bibtexEntries(fback.File)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "row-count",
+				"file": fback.File,
+			}).Error(err)
+			continue
+		}
+		addRowCount(c, "pub", entries)
 	}
 	return nil
 }
 
-func runLitCmd(cmd string, subCmd []string, wch chan<- cmdFeedback) {
+// bibtexEntries counts the entries of a bibtex file, every one starts with
+// an @ at the beginning of a line
+func bibtexEntries(file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var count int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		switch {
+		case !strings.HasPrefix(line, "@"):
+		case strings.HasPrefix(line, "@comment"),
+			strings.HasPrefix(line, "@string"),
+			strings.HasPrefix(line, "@preamble"):
+		default:
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+func runLitCmd(c *cli.Context, cmd string, subCmd []string, wch chan<- cmdFeedback) {
 	fb := cmdFeedback{}
-	out, err := exec.Command(cmd, subCmd...).CombinedOutput()
+	out, err := runCommand(c, cmd, subCmd...)
 	if err != nil {
 		fb.Error = err
 		fb.Output = out
@@ -106,6 +151,7 @@ func runLitCmd(cmd string, subCmd []string, wch chan<- cmdFeedback) {
 		fb.Output = out
 	}
 	fb.SubCmd = strings.Join(subCmd, " ")
+	fb.File = subCmd[len(subCmd)-1]
 	wch <- fb
 }
 
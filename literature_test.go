@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBibtexEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bibtex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pubs.bib")
+	ct := `@comment{exported from dictybase}
+@string{jbc = "J Biol Chem"}
+@article{PMID:1,
+  title = {Cell motility in Dictyostelium},
+  journal = jbc
+}
+  @Article{PMID:2,
+  title = {@ in a title does not start an entry}
+}
+@book{ISBN:3,
+  title = {Dictyostelium}
+}
+`
+	if err := ioutil.WriteFile(file, []byte(ct), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := bibtexEntries(file)
+	if err != nil {
+		t.Fatalf("unable to count entries %s", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 entries, got %d", n)
+	}
+	if _, err := bibtexEntries(filepath.Join(dir, "missing.bib")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	// lockNamespace is the first key of every advisory lock taken by the
+	// import commands, it keeps them apart from the locks of other clients
+	lockNamespace    int32 = 0x696d7074
+	migrationLock          = "migration"
+	lockPollInterval       = time.Second
+)
+
+// importLock is a postgresql session holding the advisory locks of an
+// import command, the locks are released once the session is closed.
+type importLock struct {
+	conn *pgx.Conn
+}
+
+// lockHolder is the session holding an advisory lock
+type lockHolder struct {
+	Pid     int32
+	Name    string
+	Started time.Time
+}
+
+func (h *lockHolder) String() string {
+	name := h.Name
+	if len(name) == 0 {
+		name = "unknown client"
+	}
+	return fmt.Sprintf("%s(pid %d since %s)", name, h.Pid, h.Started.Format(time.RFC3339))
+}
+
+func lockKey(name string) int32 {
+	return int32(crc32.ChecksumIEEE([]byte(name)))
+}
+
+// lockImport wraps an action to hold a lock for the command for the whole
+// run. Every command also shares the migration lock, which is taken
+// exclusively with --migration-lock to keep every other import out.
+func lockImport(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		log, err := getLogger(c, "lock")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 2)
+		}
+		lock, err := newImportLock(c)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "lock",
+				"kind": "connection",
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		defer lock.release()
+		timeout := c.GlobalDuration("lock-timeout")
+		exclusive := c.GlobalBool("migration-lock")
+		// the pipeline holds the exclusive migration lock for all of its steps
+		inPipeline := len(c.GlobalString("pipeline-step")) > 0
+		if !(exclusive && inPipeline) {
+			if err := lock.acquire(getContext(c), migrationLock, !exclusive, timeout); err != nil {
+				log.WithFields(logrus.Fields{
+					"type": "lock",
+					"lock": migrationLock,
+				}).Error(err)
+				return cli.NewExitError(err.Error(), 2)
+			}
+		}
+		if err := lock.acquire(getContext(c), c.Command.Name, false, timeout); err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "lock",
+				"lock": c.Command.Name,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		log.WithFields(logrus.Fields{
+			"type":      "lock",
+			"lock":      c.Command.Name,
+			"exclusive": exclusive,
+		}).Debug("acquired import locks")
+		return action(c)
+	}
+}
+
+// newImportLock opens the session for the locks, the session is named
+// after the command and its import run so that others could find out who
+// is holding the locks
+func newImportLock(c *cli.Context) (*importLock, error) {
+	conn, err := getConnection(c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create database connection %s", err)
+	}
+	name := fmt.Sprintf("import %s", c.Command.Name)
+	if run, ok := currentRun(c); ok {
+		name = fmt.Sprintf("%s run %d", name, run.ID)
+	}
+	if _, err := conn.Exec("SELECT set_config('application_name', $1, false)", name); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to name lock session %s", err)
+	}
+	return &importLock{conn: conn}, nil
+}
+
+// acquire tries to take the named lock until the timeout is over, a zero
+// timeout gives up right away
+func (l *importLock) acquire(ctx context.Context, name string, shared bool, timeout time.Duration) error {
+	fn := "pg_try_advisory_lock"
+	if shared {
+		fn = "pg_try_advisory_lock_shared"
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var ok bool
+		err := l.conn.QueryRow(
+			fmt.Sprintf("SELECT %s($1, $2)", fn),
+			lockNamespace, lockKey(name),
+		).Scan(&ok)
+		if err != nil {
+			return fmt.Errorf("unable to acquire lock %s %s", name, err)
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		if !sleepContext(ctx, lockPollInterval) {
+			return fmt.Errorf("waiting for lock %s %s", name, errCancelled)
+		}
+	}
+	holder, found, err := l.holder(name)
+	if err != nil {
+		return fmt.Errorf("lock %s is held by another session, unable to find it %s", name, err)
+	}
+	if !found {
+		return fmt.Errorf("lock %s is held by another session", name)
+	}
+	return fmt.Errorf("lock %s is held by %s", name, holder)
+}
+
+func (l *importLock) holder(name string) (*lockHolder, bool, error) {
+	h := new(lockHolder)
+	err := l.conn.QueryRow(`
+		SELECT a.pid, COALESCE(a.application_name, ''), a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 2
+		AND l.classid::bigint = $1 AND l.objid::bigint = $2
+		AND l.pid <> pg_backend_pid()
+		LIMIT 1
+	`, int64(uint32(lockNamespace)), int64(uint32(lockKey(name)))).Scan(&h.Pid, &h.Name, &h.Started)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return h, false, nil
+		}
+		return h, false, err
+	}
+	return h, true, nil
+}
+
+// lockMigration takes the migration lock exclusively
+func lockMigration(c *cli.Context) (*importLock, error) {
+	lock, err := newImportLock(c)
+	if err != nil {
+		return lock, err
+	}
+	err = lock.acquire(getContext(c), migrationLock, false, c.GlobalDuration("lock-timeout"))
+	if err != nil {
+		lock.release()
+		return lock, err
+	}
+	return lock, nil
+}
+
+func (l *importLock) release() {
+	l.conn.Exec("SELECT pg_advisory_unlock_all()")
+	l.conn.Close()
+}
+
+func LocksAction(c *cli.Context) error {
+	conn, err := getConnection(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	defer conn.Close()
+	names := map[int64]string{
+		int64(uint32(lockKey(migrationLock))): migrationLock,
+	}
+	for _, cmd := range c.App.Commands {
+		names[int64(uint32(lockKey(cmd.Name)))] = cmd.Name
+	}
+	rows, err := conn.Query(`
+		SELECT l.objid::bigint, l.mode, a.pid, COALESCE(a.application_name, ''), a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 2
+		AND l.classid::bigint = $1
+		ORDER BY a.backend_start
+	`, int64(uint32(lockNamespace)))
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("error in querying advisory locks %s", err),
+			2,
+		)
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(c.App.Writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCK\tMODE\tPID\tHOLDER\tSINCE")
+	for rows.Next() {
+		var key int64
+		var mode string
+		h := new(lockHolder)
+		if err := rows.Scan(&key, &mode, &h.Pid, &h.Name, &h.Started); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("error in reading advisory locks %s", err),
+				2,
+			)
+		}
+		name, ok := names[key]
+		if !ok {
+			name = fmt.Sprintf("%d", key)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+			name, mode, h.Pid, h.Name, h.Started.Format(time.RFC3339),
+		)
+	}
+	if err := rows.Err(); err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("error in reading advisory locks %s", err),
+			2,
+		)
+	}
+	return w.Flush()
+}
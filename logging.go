@@ -114,6 +114,23 @@ func getLogger(c *cli.Context, prefix string) (*logrus.Logger, error) {
 			continue
 		}
 	}
+	if step := c.GlobalString("pipeline-step"); len(step) > 0 {
+		lh.Add(&stepHook{step: step})
+	}
 	log.Hooks = lh
 	return log, nil
 }
+
+// stepHook labels every log entry with the pipeline step it belongs to
+type stepHook struct {
+	step string
+}
+
+func (h *stepHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *stepHook) Fire(entry *logrus.Entry) error {
+	entry.Data["step"] = h.step
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// manifest describes the steps of an import in a json file, for example
+//
+//	{
+//	  "name": "release-2017-09",
+//	  "steps": [
+//	    {
+//	      "name": "users",
+//	      "input": {"bucket": "dictybase", "object": "import/users.tar.gz"}
+//	    },
+//	    {
+//	      "name": "dicty-ontologies",
+//	      "command": "onto",
+//	      "flags": {"github": true, "obo": ["dicty_assay", "dicty_phenotypes"]}
+//	    },
+//	    {
+//	      "name": "stock-center-orders",
+//	      "depends_on": ["users"]
+//	    }
+//	  ]
+//	}
+type manifest struct {
+	Name  string          `json:"name"`
+	Steps []*manifestStep `json:"steps"`
+}
+
+type manifestStep struct {
+	Name      string                 `json:"name"`
+	Command   string                 `json:"command"`
+	DependsOn []string               `json:"depends_on"`
+	Input     *manifestInput         `json:"input"`
+	Flags     map[string]interface{} `json:"flags"`
+}
+
+// manifestInput is the S3 object imported by a step. The version is
+// matched against the ETag of the object before it is loaded.
+type manifestInput struct {
+	Bucket  string `json:"bucket"`
+	Object  string `json:"object"`
+	Version string `json:"version"`
+}
+
+func readManifest(file string) (*manifest, error) {
+	ct, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest %s %s", file, err)
+	}
+	m := new(manifest)
+	if err := json.Unmarshal(ct, m); err != nil {
+		return nil, fmt.Errorf("unable to decode manifest %s %s", file, err)
+	}
+	if len(m.Steps) == 0 {
+		return nil, fmt.Errorf("manifest %s has no step", file)
+	}
+	return m, nil
+}
+
+// pipelineSteps converts the manifest to steps runnable by the pipeline
+func (m *manifest) pipelineSteps(app *cli.App) ([]*pipelineStep, error) {
+	var steps []*pipelineStep
+	seen := make(map[string]bool)
+	for _, ms := range m.Steps {
+		if len(ms.Name) == 0 {
+			return steps, fmt.Errorf("manifest step without any name")
+		}
+		if seen[ms.Name] {
+			return steps, fmt.Errorf("duplicate manifest step %s", ms.Name)
+		}
+		seen[ms.Name] = true
+		s := &pipelineStep{
+			Name:      ms.Name,
+			Command:   ms.Command,
+			DependsOn: ms.DependsOn,
+			Globals:   make(map[string]string),
+		}
+		if app.Command(s.command()) == nil {
+			return steps, fmt.Errorf("step %s has unknown command %s", s.Name, s.command())
+		}
+		args, err := flagArgs(ms.Flags)
+		if err != nil {
+			return steps, fmt.Errorf("step %s %s", s.Name, err)
+		}
+		if ms.Input != nil {
+			if len(ms.Input.Object) > 0 {
+				args = append(args, "--remote-path", ms.Input.Object)
+			}
+			if len(ms.Input.Bucket) > 0 {
+				s.Globals["s3-bucket"] = ms.Input.Bucket
+			}
+			if len(ms.Input.Version) > 0 {
+				s.Globals["object-version"] = ms.Input.Version
+			}
+		}
+		s.Runs = [][]string{args}
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+// flagArgs converts the flags of a manifest step to command line arguments,
+// a list value repeats the flag and a boolean value toggles it
+func flagArgs(flags map[string]interface{}) ([]string, error) {
+	var names []string
+	for n := range flags {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	args := make([]string, 0)
+	for _, n := range names {
+		switch v := flags[n].(type) {
+		case bool:
+			if v {
+				args = append(args, "--"+n)
+			}
+		case string:
+			args = append(args, "--"+n, v)
+		case float64:
+			args = append(args, "--"+n, fmt.Sprintf("%v", v))
+		case []interface{}:
+			for _, e := range v {
+				args = append(args, "--"+n, fmt.Sprintf("%v", e))
+			}
+		default:
+			return args, fmt.Errorf("unsupported value %v for flag %s", v, n)
+		}
+	}
+	return args, nil
+}
+
+func validateRunManifest(c *cli.Context) error {
+	if err := validatePipeline(c); err != nil {
+		return err
+	}
+	if len(c.String("manifest")) == 0 {
+		return cli.NewExitError("argument manifest is missing", 2)
+	}
+	return nil
+}
+
+func RunManifestAction(c *cli.Context) error {
+	log, err := getLogger(c, "manifest")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	m, err := readManifest(c.String("manifest"))
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "manifest",
+			"file": c.String("manifest"),
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	steps, err := m.pipelineSteps(c.App)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "manifest",
+			"file": c.String("manifest"),
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":     "manifest",
+		"manifest": m.Name,
+		"steps":    len(steps),
+	}).Info("running manifest")
+	return executeSteps(c, log, steps)
+}
@@ -97,3 +97,16 @@ type Db struct {
 	Urlprefix   dat.NullString `db:"urlprefix"`
 	Url         dat.NullString `db:"url"`
 }
+
+type ImportRun struct {
+	ID         int64          `db:"import_run_id"`
+	Command    string         `db:"command"`
+	Flags      dat.NullString `db:"flags"`
+	RemotePath dat.NullString `db:"remote_path"`
+	ETag       dat.NullString `db:"etag"`
+	StartedAt  time.Time      `db:"started_at"`
+	FinishedAt dat.NullTime   `db:"finished_at"`
+	RowCounts  dat.NullString `db:"row_counts"`
+	Status     string         `db:"status"`
+	Error      dat.NullString `db:"error"`
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	eventSuccess = "success"
+	eventFailure = "failure"
+)
+
+// importEvent is the payload of notification sent after every run of an
+// import command
+type importEvent struct {
+	Event     string    `json:"event"`
+	Status    string    `json:"status"`
+	ImportRun int64     `json:"import_run,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// notifyRun wraps an action to send a notification through postgresql
+// once it is finished. It goes around recordRun, so that a listener finds
+// the run already finished in the ledger.
+func notifyRun(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		aerr := action(c)
+		id, recorded := finishedRun(c)
+		// nothing is loaded in a dry run, so nobody should get notified
+		if c.GlobalBool("dry-run") {
+			return aerr
+		}
+		ev := importEvent{
+			Event:  c.Command.Name,
+			Status: eventSuccess,
+			Time:   time.Now(),
+		}
+		if recorded {
+			ev.ImportRun = id
+		}
+		if aerr != nil {
+			ev.Status = eventFailure
+			ev.Error = aerr.Error()
+		}
+		payload, err := json.Marshal(ev)
+		if err == nil {
+			err = sendNotification(c, c.GlobalString("notify-channel"), string(payload))
+		}
+		// the data is already committed, a lost notification should not
+		// turn the run into a failed one
+		if err != nil {
+			log, lerr := getLogger(c, "notify")
+			if lerr == nil {
+				log.WithFields(logrus.Fields{
+					"type":    "notify",
+					"channel": c.GlobalString("notify-channel"),
+					"event":   ev.Event,
+				}).Error(fmt.Sprintf("unable to send notification %s", err))
+			}
+		}
+		return aerr
+	}
+}
+
+func validateWaitFor(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(c.String("event")) == 0 {
+		return cli.NewExitError("argument event is missing", 2)
+	}
+	return nil
+}
+
+func WaitForAction(c *cli.Context) error {
+	log, err := getLogger(c, "wait-for")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	conn, err := getConnection(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	defer conn.Close()
+	channel := c.String("channel")
+	event := c.String("event")
+	if err := conn.Listen(channel); err != nil {
+		log.WithFields(logrus.Fields{
+			"type":    "listen",
+			"channel": channel,
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	// the event might had been sent before the listening started
+	if c.Duration("since") > 0 {
+		status, found, err := recentRunStatus(conn, event, time.Now().Add(-c.Duration("since")))
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type":  "import-run",
+				"event": event,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		if found {
+			return eventOutcome(log, event, status, "")
+		}
+	}
+	ctx := getContext(c)
+	if c.Duration("timeout") > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Duration("timeout"))
+		defer cancel()
+	}
+	log.WithFields(logrus.Fields{
+		"type":    "listen",
+		"channel": channel,
+		"event":   event,
+	}).Info("waiting for event")
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type":    "listen",
+				"channel": channel,
+				"event":   event,
+			}).Error(err)
+			return cli.NewExitError(fmt.Sprintf("error in waiting for event %s %s", event, err), 2)
+		}
+		var ev importEvent
+		if err := json.Unmarshal([]byte(n.Payload), &ev); err != nil {
+			log.Warnf("skipping malformed notification %s", n.Payload)
+			continue
+		}
+		if ev.Event != event {
+			log.Debugf("skipping notification of event %s", ev.Event)
+			continue
+		}
+		return eventOutcome(log, event, ev.Status, ev.Error)
+	}
+}
+
+func eventOutcome(log *logrus.Logger, event, status, msg string) error {
+	if status == eventSuccess {
+		log.WithFields(logrus.Fields{
+			"type":  "listen",
+			"event": event,
+		}).Info("event finished successfully")
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		"type":   "listen",
+		"event":  event,
+		"status": status,
+	}).Error(msg)
+	return cli.NewExitError(fmt.Sprintf("event %s did not succeed %s", event, msg), 2)
+}
+
+// recentRunStatus looks up the import_run ledger for a run of the command
+// finished after the given time
+func recentRunStatus(conn *pgx.Conn, command string, after time.Time) (string, bool, error) {
+	var exists bool
+	err := conn.QueryRow("SELECT to_regclass('import_run') IS NOT NULL").Scan(&exists)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	var status string
+	err = conn.QueryRow(`
+		SELECT status FROM import_run
+		WHERE command = $1 AND finished_at > $2 AND status <> 'dry-run'
+		ORDER BY import_run_id DESC LIMIT 1
+	`, command, after).Scan(&status)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if status == runStatusSuccess {
+		return eventSuccess, true, nil
+	}
+	return eventFailure, true, nil
+}
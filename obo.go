@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// oboSynonym is a synonym of a term along with its scope, EXACT, BROAD,
+// NARROW or RELATED
+type oboSynonym struct {
+	Text  string
+	Scope string
+	Type  string
+	Xrefs []string
+}
+
+type oboRelation struct {
+	Type   string
+	Target string
+}
+
+type oboProperty struct {
+	Name  string
+	Value string
+}
+
+// oboTerm is a Term or a Typedef stanza
+type oboTerm struct {
+	ID            string
+	Name          string
+	Namespace     string
+	Def           string
+	DefXrefs      []string
+	Comment       string
+	Obsolete      bool
+	Typedef       bool
+	IsA           []string
+	Relationships []*oboRelation
+	Synonyms      []*oboSynonym
+	Xrefs         []string
+	AltIds        []string
+	Subsets       []string
+	ReplacedBy    []string
+	Consider      []string
+	Properties    []*oboProperty
+	Line          int
+}
+
+type oboDocument struct {
+	Header   map[string][]string
+	Terms    []*oboTerm
+	Typedefs []*oboTerm
+}
+
+// Tag returns the first value of a header tag
+func (d *oboDocument) Tag(name string) string {
+	if v, ok := d.Header[name]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Namespace is the cv of the terms without any namespace of their own
+func (d *oboDocument) Namespace() string {
+	if ns := d.Tag("default-namespace"); len(ns) > 0 {
+		return ns
+	}
+	return d.Tag("ontology")
+}
+
+// TermNamespace returns the cv of the term
+func (d *oboDocument) TermNamespace(t *oboTerm) string {
+	if len(t.Namespace) > 0 {
+		return t.Namespace
+	}
+	return d.Namespace()
+}
+
+func readOBOFile(file string) (*oboDocument, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var rd io.Reader = r
+	if strings.HasSuffix(file, ".gz") {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		rd = gr
+	}
+	d, err := readOBO(rd)
+	if err != nil {
+		return d, fmt.Errorf("error in parsing %s %s", file, err)
+	}
+	return d, nil
+}
+
+// readOBO parses an OBO 1.4 document, Instance stanzas are skipped
+func readOBO(r io.Reader) (*oboDocument, error) {
+	d := &oboDocument{Header: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var term *oboTerm
+	stanza := "header"
+	lnum := 0
+	for scanner.Scan() {
+		lnum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "!") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			stanza = line[1 : len(line)-1]
+			term = nil
+			switch stanza {
+			case "Term":
+				term = &oboTerm{Line: lnum}
+				d.Terms = append(d.Terms, term)
+			case "Typedef":
+				term = &oboTerm{Line: lnum, Typedef: true}
+				d.Typedefs = append(d.Typedefs, term)
+			}
+			continue
+		}
+		tag, value, err := splitOBOTag(line)
+		if err != nil {
+			return d, fmt.Errorf("line %d %s", lnum, err)
+		}
+		switch {
+		case stanza == "header":
+			d.Header[tag] = append(d.Header[tag], value)
+		case term != nil:
+			if err := term.set(tag, value); err != nil {
+				return d, fmt.Errorf("line %d %s", lnum, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return d, err
+	}
+	for _, t := range append(d.Typedefs, d.Terms...) {
+		if len(t.ID) == 0 {
+			return d, fmt.Errorf("stanza at line %d has no id", t.Line)
+		}
+	}
+	return d, nil
+}
+
+func (t *oboTerm) set(tag, value string) error {
+	switch tag {
+	case "id":
+		t.ID = value
+	case "name":
+		t.Name = oboUnescape(value)
+	case "namespace":
+		t.Namespace = value
+	case "def":
+		text, rest, err := parseOBOQuoted(value)
+		if err != nil {
+			return fmt.Errorf("invalid def %s", err)
+		}
+		t.Def = text
+		t.DefXrefs = parseOBOXrefList(rest)
+	case "comment":
+		t.Comment = oboUnescape(value)
+	case "is_obsolete":
+		t.Obsolete = value == "true"
+	case "is_a":
+		t.IsA = append(t.IsA, firstField(value))
+	case "relationship":
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return fmt.Errorf("invalid relationship %s", value)
+		}
+		t.Relationships = append(t.Relationships, &oboRelation{Type: fields[0], Target: fields[1]})
+	case "synonym", "exact_synonym", "narrow_synonym", "broad_synonym", "related_synonym":
+		s, err := parseOBOSynonym(tag, value)
+		if err != nil {
+			return err
+		}
+		t.Synonyms = append(t.Synonyms, s)
+	case "xref", "xref_analog", "xref_unk":
+		t.Xrefs = append(t.Xrefs, firstField(value))
+	case "alt_id":
+		t.AltIds = append(t.AltIds, value)
+	case "subset":
+		t.Subsets = append(t.Subsets, value)
+	case "replaced_by":
+		t.ReplacedBy = append(t.ReplacedBy, value)
+	case "consider":
+		t.Consider = append(t.Consider, value)
+	case "property_value":
+		p, err := parseOBOProperty(value)
+		if err != nil {
+			return err
+		}
+		t.Properties = append(t.Properties, p)
+	}
+	return nil
+}
+
+// splitOBOTag splits a tag-value pair and strips the trailing modifiers and
+// comment from the value
+func splitOBOTag(line string) (string, string, error) {
+	idx := strings.Index(line, ":")
+	if idx < 1 {
+		return "", "", fmt.Errorf("no tag in %s", line)
+	}
+	tag := line[:idx]
+	value := line[idx+1:]
+	quoted := false
+	brace := -1
+	end := len(value)
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '{':
+			if !quoted && brace < 0 {
+				brace = i
+			}
+		case '!':
+			if !quoted {
+				end = i
+			}
+		}
+		if end < len(value) {
+			break
+		}
+	}
+	// the offset of the brace holds as long as only the end is trimmed
+	value = strings.TrimRight(value[:end], " \t")
+	if brace >= 0 && brace < len(value) && strings.HasSuffix(value, "}") {
+		value = value[:brace]
+	}
+	return tag, strings.TrimSpace(value), nil
+}
+
+// parseOBOQuoted reads a leading quoted string and returns the unescaped
+// text along with the rest of the value
+func parseOBOQuoted(value string) (string, string, error) {
+	if !strings.HasPrefix(value, "\"") {
+		return "", value, fmt.Errorf("%s is not quoted", value)
+	}
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return oboUnescape(value[1:i]), strings.TrimSpace(value[i+1:]), nil
+		}
+	}
+	return "", value, fmt.Errorf("unterminated quote in %s", value)
+}
+
+// parseOBOXrefList returns the ids of a bracketed list of dbxrefs, the
+// descriptions are dropped
+func parseOBOXrefList(value string) []string {
+	start := strings.Index(value, "[")
+	stop := strings.LastIndex(value, "]")
+	if start < 0 || stop < start {
+		return nil
+	}
+	var xrefs []string
+	var item []byte
+	quoted := false
+	list := value[start+1 : stop]
+	for i := 0; i < len(list); i++ {
+		switch ch := list[i]; {
+		case ch == '\\' && i+1 < len(list):
+			i++
+			item = append(item, list[i])
+		case ch == '"':
+			quoted = !quoted
+			item = append(item, ch)
+		case ch == ',' && !quoted:
+			if x := firstField(string(item)); len(x) > 0 {
+				xrefs = append(xrefs, x)
+			}
+			item = item[:0]
+		default:
+			item = append(item, ch)
+		}
+	}
+	if x := firstField(string(item)); len(x) > 0 {
+		xrefs = append(xrefs, x)
+	}
+	return xrefs
+}
+
+func parseOBOSynonym(tag, value string) (*oboSynonym, error) {
+	text, rest, err := parseOBOQuoted(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid synonym %s", err)
+	}
+	s := &oboSynonym{Text: text, Scope: "RELATED"}
+	if tag != "synonym" {
+		s.Scope = strings.ToUpper(strings.TrimSuffix(tag, "_synonym"))
+	}
+	idx := strings.Index(rest, "[")
+	if idx < 0 {
+		idx = len(rest)
+	}
+	fields := strings.Fields(rest[:idx])
+	if tag == "synonym" && len(fields) > 0 {
+		s.Scope = fields[0]
+		if len(fields) > 1 {
+			s.Type = fields[1]
+		}
+	}
+	switch s.Scope {
+	case "EXACT", "BROAD", "NARROW", "RELATED":
+	default:
+		return nil, fmt.Errorf("invalid synonym scope %s", s.Scope)
+	}
+	s.Xrefs = parseOBOXrefList(rest[idx:])
+	return s, nil
+}
+
+// parseOBOProperty reads either of a relation to an id or to a quoted value
+// with its datatype
+func parseOBOProperty(value string) (*oboProperty, error) {
+	fields := strings.SplitN(value, " ", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid property_value %s", value)
+	}
+	p := &oboProperty{Name: fields[0]}
+	rest := strings.TrimSpace(fields[1])
+	if strings.HasPrefix(rest, "\"") {
+		text, _, err := parseOBOQuoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid property_value %s", err)
+		}
+		p.Value = text
+		return p, nil
+	}
+	p.Value = firstField(rest)
+	return p, nil
+}
+
+var oboUnescaper = strings.NewReplacer(
+	`\n`, "\n",
+	`\t`, "\t",
+	`\W`, " ",
+	`\"`, `"`,
+	`\\`, `\`,
+	`\:`, ":",
+	`\,`, ",",
+	`\(`, "(",
+	`\)`, ")",
+	`\[`, "[",
+	`\]`, "]",
+	`\{`, "{",
+	`\}`, "}",
+	`\!`, "!",
+)
+
+func oboUnescape(s string) string {
+	return oboUnescaper.Replace(s)
+}
+
+func firstField(s string) string {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// splitOBOId splits an id in its db and accession, an id without any prefix
+// belongs to the _global db
+func splitOBOId(id string) (string, string) {
+	idx := strings.Index(id, ":")
+	if idx < 1 || idx == len(id)-1 {
+		return "_global", id
+	}
+	return id[:idx], id[idx+1:]
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOBO = `format-version: 1.2
+default-namespace: dicty_phenotypes
+ontology: dpo
+! a comment line
+
+[Term]
+id: DDPHENO:0000001
+name: aberrant\, spore morphology ! trailing comment
+def: "Spores \"look\" odd." [PMID:123, dictyBase:curator "the curator, again"]
+comment: see also http\://dictybase.org
+is_a: DDPHENO:0000002 ! parent
+relationship: part_of DDPHENO:0000003 {source="dictyBase"}
+synonym: "odd spore" EXACT [PMID:456]
+synonym: "spore defect" RELATED plural []
+narrow_synonym: "tiny spore"
+xref: GO:0030435 "sporulation"
+alt_id: DDPHENO:0000009
+subset: slim
+property_value: seeAlso "http://dictybase.org" xsd:string
+property_value: part_of DDPHENO:0000004
+
+[Term]
+id: DDPHENO:0000002
+namespace: other
+is_obsolete: true
+replaced_by: DDPHENO:0000001
+consider: DDPHENO:0000003
+
+[Instance]
+id: instance1
+name: skipped
+
+[Typedef]
+id: part_of
+name: part of
+`
+
+func TestReadOBO(t *testing.T) {
+	d, err := readOBO(strings.NewReader(testOBO))
+	if err != nil {
+		t.Fatalf("unable to parse obo %s", err)
+	}
+	if d.Namespace() != "dicty_phenotypes" || d.Tag("ontology") != "dpo" {
+		t.Fatalf("unexpected header %v", d.Header)
+	}
+	if len(d.Terms) != 2 || len(d.Typedefs) != 1 {
+		t.Fatalf("expected 2 terms and 1 typedef, got %d %d", len(d.Terms), len(d.Typedefs))
+	}
+	expected := &oboTerm{
+		ID:            "DDPHENO:0000001",
+		Name:          "aberrant, spore morphology",
+		Def:           `Spores "look" odd.`,
+		DefXrefs:      []string{"PMID:123", "dictyBase:curator"},
+		Comment:       "see also http://dictybase.org",
+		IsA:           []string{"DDPHENO:0000002"},
+		Relationships: []*oboRelation{{Type: "part_of", Target: "DDPHENO:0000003"}},
+		Synonyms: []*oboSynonym{
+			{Text: "odd spore", Scope: "EXACT", Xrefs: []string{"PMID:456"}},
+			{Text: "spore defect", Scope: "RELATED", Type: "plural"},
+			{Text: "tiny spore", Scope: "NARROW"},
+		},
+		Xrefs:   []string{"GO:0030435"},
+		AltIds:  []string{"DDPHENO:0000009"},
+		Subsets: []string{"slim"},
+		Properties: []*oboProperty{
+			{Name: "seeAlso", Value: "http://dictybase.org"},
+			{Name: "part_of", Value: "DDPHENO:0000004"},
+		},
+		Line: 6,
+	}
+	if !reflect.DeepEqual(d.Terms[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, d.Terms[0])
+	}
+	obs := d.Terms[1]
+	if !obs.Obsolete || d.TermNamespace(obs) != "other" || d.TermNamespace(d.Terms[0]) != "dicty_phenotypes" {
+		t.Fatalf("unexpected obsolete term %+v", obs)
+	}
+	if !reflect.DeepEqual(obs.ReplacedBy, []string{"DDPHENO:0000001"}) || !reflect.DeepEqual(obs.Consider, []string{"DDPHENO:0000003"}) {
+		t.Fatalf("unexpected replacements %v %v", obs.ReplacedBy, obs.Consider)
+	}
+	if td := d.Typedefs[0]; !td.Typedef || td.ID != "part_of" || td.Name != "part of" {
+		t.Fatalf("unexpected typedef %+v", td)
+	}
+}
+
+func TestReadOBOMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ct   string
+		err  string
+	}{
+		{
+			name: "line without tag",
+			ct:   "ontology: dpo\n[Term]\nid: DDPHENO:1\njust text\n",
+			err:  "line 4 no tag in just text",
+		},
+		{
+			name: "header line without tag",
+			ct:   ":dpo\n",
+			err:  "line 1 no tag in :dpo",
+		},
+		{
+			name: "stanza without id",
+			ct:   "ontology: dpo\n\n[Term]\nname: orphan\n",
+			err:  "stanza at line 3 has no id",
+		},
+		{
+			name: "typedef without id",
+			ct:   "[Typedef]\nname: part of\n",
+			err:  "stanza at line 1 has no id",
+		},
+		{
+			name: "unquoted def",
+			ct:   "[Term]\nid: DDPHENO:1\ndef: odd spores [PMID:1]\n",
+			err:  "line 3 invalid def odd spores [PMID:1] is not quoted",
+		},
+		{
+			name: "unterminated def",
+			ct:   "[Term]\nid: DDPHENO:1\ndef: \"odd spores [PMID:1]\n",
+			err:  "line 3 invalid def unterminated quote in \"odd spores [PMID:1]",
+		},
+		{
+			name: "short relationship",
+			ct:   "[Term]\nid: DDPHENO:1\nrelationship: part_of\n",
+			err:  "line 3 invalid relationship part_of",
+		},
+		{
+			name: "bad synonym scope",
+			ct:   "[Term]\nid: DDPHENO:1\nsynonym: \"odd\" SIMILAR []\n",
+			err:  "line 3 invalid synonym scope SIMILAR",
+		},
+		{
+			name: "unquoted synonym",
+			ct:   "[Term]\nid: DDPHENO:1\nsynonym: odd EXACT []\n",
+			err:  "line 3 invalid synonym odd EXACT [] is not quoted",
+		},
+		{
+			name: "property without value",
+			ct:   "[Term]\nid: DDPHENO:1\nproperty_value: seeAlso\n",
+			err:  "line 3 invalid property_value seeAlso",
+		},
+	} {
+		_, err := readOBO(strings.NewReader(tc.ct))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestSplitOBOTag(t *testing.T) {
+	for _, tc := range []struct {
+		line  string
+		tag   string
+		value string
+	}{
+		{line: "id: GO:0000001", tag: "id", value: "GO:0000001"},
+		{line: "name: cell ! a comment", tag: "name", value: "cell"},
+		{line: `def: "has ! and {x}" [] {modifier="1"}`, tag: "def", value: `"has ! and {x}" []`},
+		{line: `name: escaped \! bang`, tag: "name", value: `escaped \! bang`},
+		{line: "is_a: GO:1 {inferred=\"true\"} ! parent", tag: "is_a", value: "GO:1"},
+		{line: "comment: a {brace without end", tag: "comment", value: "a {brace without end"},
+	} {
+		tag, value, err := splitOBOTag(tc.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.line, err)
+			continue
+		}
+		if tag != tc.tag || value != tc.value {
+			t.Errorf("%q: expected %q %q, got %q %q", tc.line, tc.tag, tc.value, tag, value)
+		}
+	}
+}
+
+func TestParseOBOXrefList(t *testing.T) {
+	for value, expected := range map[string][]string{
+		"[]":                          nil,
+		"no list":                     nil,
+		"[PMID:1]":                    {"PMID:1"},
+		`[PMID:1 "a, b", GO:2]`:       {"PMID:1", "GO:2"},
+		`[URL:http\://x.org, ISBN:3]`: {"URL:http://x.org", "ISBN:3"},
+		"[ , PMID:1, ]":               {"PMID:1"},
+	} {
+		if xrefs := parseOBOXrefList(value); !reflect.DeepEqual(xrefs, expected) {
+			t.Errorf("%q: expected %v, got %v", value, expected, xrefs)
+		}
+	}
+}
+
+func TestSplitOBOId(t *testing.T) {
+	for id, expected := range map[string][2]string{
+		"GO:0000001": {"GO", "0000001"},
+		"part_of":    {"_global", "part_of"},
+		":0000001":   {"_global", ":0000001"},
+		"GO:":        {"_global", "GO:"},
+		"URL:http:x": {"URL", "http:x"},
+	} {
+		db, acc := splitOBOId(id)
+		if db != expected[0] || acc != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s", id, expected, db, acc)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	synonymTypeCv  = "synonym_type"
+	termPropCv     = "cvterm_property_type"
+	cvPropCv       = "cv_property"
+	relationshipCv = "relationship"
+	oboVersionProp = "ontology_version"
+	oboCommitProp  = "ontology_commit"
+)
+
+const termByDbxrefSQL = `
+SELECT cvterm_id FROM cvterm WHERE dbxref_id = $1
+	`
+
+const relationshipTypeSQL = `
+SELECT cvterm_id FROM cvterm
+WHERE name = $1 AND is_relationshiptype = 1 AND is_obsolete = 0
+ORDER BY cvterm_id
+LIMIT 1
+	`
+
+const obsoleteNameSQL = `
+SELECT cvterm_id FROM cvterm
+WHERE cv_id = $1 AND name = $2 AND is_obsolete = 1 AND cvterm_id != $3
+	`
+
+const oboVersionSQL = `
+SELECT cvprop.value FROM cvprop
+JOIN cv ON cv.cv_id = cvprop.cv_id
+JOIN cvterm ON cvterm.cvterm_id = cvprop.type_id
+JOIN cv pcv ON pcv.cv_id = cvterm.cv_id
+WHERE cv.name = $1 AND cvterm.name = $2 AND pcv.name = $3
+	`
+
+const insertCvtermRelationship = `
+INSERT INTO cvterm_relationship (type_id,subject_id,object_id)
+VALUES ($1,$2,$3)
+ON CONFLICT DO NOTHING
+	`
+
+const insertCvtermDbxref = `
+INSERT INTO cvterm_dbxref (cvterm_id,dbxref_id,is_for_definition)
+VALUES ($1,$2,$3)
+ON CONFLICT DO NOTHING
+	`
+
+const insertCvtermSynonym = `
+INSERT INTO cvtermsynonym (cvterm_id,synonym,type_id)
+VALUES ($1,$2,$3)
+ON CONFLICT DO NOTHING
+	`
+
+// oboHeaderProps are the header tags kept as the properties of the cv
+var oboHeaderProps = []string{
+	"format-version",
+	"data-version",
+	"date",
+	"saved-by",
+	"auto-generated-by",
+	"default-namespace",
+	"ontology",
+}
+
+// oboLoader writes an ontology to chado in a single transaction. A term is
+// identified by the dbxref of its id, its synonyms, dbxrefs, properties and
+// relationships are replaced on every load.
+type oboLoader struct {
+	c         *cli.Context
+	tx        *runner.Tx
+	log       *logrus.Logger
+	report    *changeReport
+	doc       *oboDocument
+	cvIds     map[string]int64
+	termCvs   map[string]bool
+	dbxrefIds map[string]int64
+	termIds   map[string]int64
+	typeIds   map[string]int64
+	propIds   map[string]int64
+	rows      map[string]int64
+	missing   int64
+	previous  map[string]*chadoTerm
+	obsoleted []*obsoleteTerm
+}
+
+func newOboLoader(c *cli.Context, tx *runner.Tx, log *logrus.Logger, doc *oboDocument) *oboLoader {
+	return &oboLoader{
+		c:         c,
+		tx:        tx,
+		log:       log,
+		report:    newChangeReport(c),
+		doc:       doc,
+		cvIds:     make(map[string]int64),
+		termCvs:   make(map[string]bool),
+		dbxrefIds: make(map[string]int64),
+		termIds:   make(map[string]int64),
+		typeIds:   make(map[string]int64),
+		propIds:   make(map[string]int64),
+		rows:      make(map[string]int64),
+	}
+}
+
+func (ol *oboLoader) add(table, kind string, count int64) {
+	ol.report.add(table, kind, count)
+	if kind != changeDelete {
+		ol.rows[table] += count
+	}
+}
+
+// load writes the typedefs first so that the relationships of the terms can
+// refer to them
+func (ol *oboLoader) load() error {
+	if len(ol.doc.Namespace()) == 0 {
+		return fmt.Errorf("no default-namespace or ontology in the header")
+	}
+	if err := ol.readPrevious(); err != nil {
+		return err
+	}
+	all := append(append([]*oboTerm{}, ol.doc.Typedefs...), ol.doc.Terms...)
+	for _, t := range all {
+		if isCancelled(ol.c) {
+			return errCancelled
+		}
+		if err := ol.loadTerm(t); err != nil {
+			return err
+		}
+	}
+	for _, t := range all {
+		if isCancelled(ol.c) {
+			return errCancelled
+		}
+		if err := ol.loadRelationships(t); err != nil {
+			return err
+		}
+	}
+	if err := ol.migrateObsolete(); err != nil {
+		return err
+	}
+	if err := ol.loadMetadata(); err != nil {
+		return err
+	}
+	return recordOboVersion(ol.tx, ol.doc)
+}
+
+func (ol *oboLoader) cvId(name string) (int64, error) {
+	if id, ok := ol.cvIds[name]; ok {
+		return id, nil
+	}
+	var id int64
+	if err := ol.tx.SQL(upsertCv, name).QueryScalar(&id); err != nil {
+		return id, fmt.Errorf("error in finding or creating cv %s %s", name, err)
+	}
+	ol.cvIds[name] = id
+	return id, nil
+}
+
+func (ol *oboLoader) dbxrefId(xref string) (int64, error) {
+	if id, ok := ol.dbxrefIds[xref]; ok {
+		return id, nil
+	}
+	var dbId, id int64
+	db, acc := splitOBOId(xref)
+	if err := ol.tx.SQL(upsertDb, db).QueryScalar(&dbId); err != nil {
+		return id, fmt.Errorf("error in finding or creating db %s %s", db, err)
+	}
+	if err := ol.tx.SQL(upsertDbxref, dbId, acc).QueryScalar(&id); err != nil {
+		return id, fmt.Errorf("error in finding or creating dbxref %s %s", xref, err)
+	}
+	ol.dbxrefIds[xref] = id
+	return id, nil
+}
+
+// termName keeps the name of an obsolete term unique in its cv, as chado
+// does not allow two obsolete terms with the same name
+func (ol *oboLoader) termName(t *oboTerm, cvId, termId int64) (string, error) {
+	name := t.Name
+	if len(name) == 0 {
+		name = t.ID
+	}
+	if !t.Obsolete {
+		return name, nil
+	}
+	var ids []int64
+	err := ol.tx.SQL(obsoleteNameSQL, cvId, name, termId).QuerySlice(&ids)
+	if err != nil && err != dat.ErrNotFound {
+		return name, fmt.Errorf("error in looking up obsolete term %s %s", name, err)
+	}
+	if len(ids) > 0 {
+		name = fmt.Sprintf("%s (%s)", name, t.ID)
+	}
+	return name, nil
+}
+
+func (ol *oboLoader) loadTerm(t *oboTerm) error {
+	cvId, err := ol.cvId(ol.doc.TermNamespace(t))
+	if err != nil {
+		return err
+	}
+	ol.termCvs[ol.doc.TermNamespace(t)] = true
+	dbxrefId, err := ol.dbxrefId(t.ID)
+	if err != nil {
+		return err
+	}
+	var id int64
+	err = ol.tx.SQL(termByDbxrefSQL, dbxrefId).QueryScalar(&id)
+	if err != nil && err != dat.ErrNotFound {
+		return fmt.Errorf("error in looking up term %s %s", t.ID, err)
+	}
+	exists := err == nil
+	name, err := ol.termName(t, cvId, id)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{
+		"name":                name,
+		"definition":          t.Def,
+		"cv_id":               cvId,
+		"is_obsolete":         boolInt(t.Obsolete),
+		"is_relationshiptype": boolInt(t.Typedef),
+	}
+	if len(t.Def) == 0 {
+		values["definition"] = nil
+	}
+	if exists {
+		_, err = ol.tx.Update("cvterm").
+			SetMap(values).
+			Where("cvterm_id = $1", id).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("error in updating term %s %s", t.ID, err)
+		}
+		ol.add("cvterm", changeUpdate, 1)
+		if err := ol.clearTerm(id); err != nil {
+			return fmt.Errorf("error in clearing term %s %s", t.ID, err)
+		}
+	} else {
+		err = ol.tx.InsertInto("cvterm").
+			Columns("name", "definition", "cv_id", "is_obsolete", "is_relationshiptype", "dbxref_id").
+			Values(name, values["definition"], cvId, boolInt(t.Obsolete), boolInt(t.Typedef), dbxrefId).
+			Returning("cvterm_id").
+			QueryScalar(&id)
+		if err != nil {
+			return fmt.Errorf("error in inserting term %s %s", t.ID, err)
+		}
+		ol.add("cvterm", changeInsert, 1)
+		ol.report.sample("cvterm", changeInsert, map[string]interface{}{
+			"id":   t.ID,
+			"name": name,
+			"cv":   ol.doc.TermNamespace(t),
+		})
+	}
+	ol.termIds[t.ID] = id
+	ol.markObsolete(t, id)
+	if t.Typedef {
+		ol.typeIds[t.ID] = id
+	}
+	if err := ol.loadSynonyms(id, t); err != nil {
+		return err
+	}
+	if err := ol.loadDbxrefs(id, t); err != nil {
+		return err
+	}
+	return ol.loadProperties(id, t)
+}
+
+// clearTerm removes everything of the term that is written again by the load
+func (ol *oboLoader) clearTerm(id int64) error {
+	for _, table := range []string{"cvtermsynonym", "cvterm_dbxref", "cvtermprop"} {
+		res, err := ol.tx.DeleteFrom(table).Where("cvterm_id = $1", id).Exec()
+		if err != nil {
+			return err
+		}
+		ol.add(table, changeDelete, res.RowsAffected)
+	}
+	res, err := ol.tx.DeleteFrom("cvterm_relationship").Where("subject_id = $1", id).Exec()
+	if err != nil {
+		return err
+	}
+	ol.add("cvterm_relationship", changeDelete, res.RowsAffected)
+	return nil
+}
+
+func (ol *oboLoader) loadSynonyms(id int64, t *oboTerm) error {
+	for _, s := range t.Synonyms {
+		typeId, err := ol.cvtermId(synonymTypeCv, strings.ToLower(s.Scope))
+		if err != nil {
+			return err
+		}
+		res, err := ol.tx.SQL(insertCvtermSynonym, id, s.Text, typeId).Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting synonym %s of %s %s", s.Text, t.ID, err)
+		}
+		ol.add("cvtermsynonym", changeInsert, res.RowsAffected)
+	}
+	return nil
+}
+
+func (ol *oboLoader) loadDbxrefs(id int64, t *oboTerm) error {
+	xrefs := make(map[string]int)
+	for _, x := range append(append([]string{}, t.Xrefs...), t.AltIds...) {
+		xrefs[x] = 0
+	}
+	for _, x := range t.DefXrefs {
+		xrefs[x] = 1
+	}
+	var keys []string
+	for x := range xrefs {
+		keys = append(keys, x)
+	}
+	sort.Strings(keys)
+	for _, x := range keys {
+		xid, err := ol.dbxrefId(x)
+		if err != nil {
+			return err
+		}
+		res, err := ol.tx.SQL(insertCvtermDbxref, id, xid, xrefs[x]).Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting dbxref %s of %s %s", x, t.ID, err)
+		}
+		ol.add("cvterm_dbxref", changeInsert, res.RowsAffected)
+	}
+	return nil
+}
+
+func (ol *oboLoader) loadProperties(id int64, t *oboTerm) error {
+	var props []*oboProperty
+	if len(t.Comment) > 0 {
+		props = append(props, &oboProperty{Name: "comment", Value: t.Comment})
+	}
+	for _, v := range t.ReplacedBy {
+		props = append(props, &oboProperty{Name: "replaced_by", Value: v})
+	}
+	for _, v := range t.Consider {
+		props = append(props, &oboProperty{Name: "consider", Value: v})
+	}
+	for _, v := range t.Subsets {
+		props = append(props, &oboProperty{Name: "subset", Value: v})
+	}
+	props = append(props, t.Properties...)
+	ranks := make(map[string]int)
+	for _, p := range props {
+		typeId, err := ol.cvtermId(termPropCv, p.Name)
+		if err != nil {
+			return err
+		}
+		_, err = ol.tx.InsertInto("cvtermprop").
+			Columns("cvterm_id", "type_id", "value", "rank").
+			Values(id, typeId, p.Value, ranks[p.Name]).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting property %s of %s %s", p.Name, t.ID, err)
+		}
+		ranks[p.Name]++
+		ol.add("cvtermprop", changeInsert, 1)
+	}
+	return nil
+}
+
+// cvtermId finds or creates a term of the supporting cvs
+func (ol *oboLoader) cvtermId(cv, name string) (int64, error) {
+	key := cv + ":" + name
+	if id, ok := ol.propIds[key]; ok {
+		return id, nil
+	}
+	if _, err := ol.cvId(cv); err != nil {
+		return 0, err
+	}
+	if _, err := ol.tx.SQL(upsertDb, "internal").Exec(); err != nil {
+		return 0, fmt.Errorf("error in finding or creating db internal %s", err)
+	}
+	id, err := findOrCreateCvterm(cv, name, "", ol.tx)
+	if err != nil {
+		return id, err
+	}
+	ol.propIds[key] = id
+	return id, nil
+}
+
+// relationshipType resolves the type of a relationship from the typedefs
+// of the ontology, the ones already in chado, or is_a
+func (ol *oboLoader) relationshipType(name string) (int64, bool, error) {
+	if id, ok := ol.typeIds[name]; ok {
+		return id, true, nil
+	}
+	if name == "is_a" {
+		id, err := ol.cvtermId(relationshipCv, "is_a")
+		return id, err == nil, err
+	}
+	id, ok, err := ol.chadoTerm(name)
+	if err != nil {
+		return id, false, err
+	}
+	if ok {
+		ol.typeIds[name] = id
+		return id, true, nil
+	}
+	err = ol.tx.SQL(relationshipTypeSQL, name).QueryScalar(&id)
+	switch {
+	case err == dat.ErrNotFound:
+		return id, false, nil
+	case err != nil:
+		return id, false, fmt.Errorf("error in looking up relationship type %s %s", name, err)
+	}
+	ol.typeIds[name] = id
+	return id, true, nil
+}
+
+// chadoTerm looks up a term of another ontology through the dbxref of its id
+func (ol *oboLoader) chadoTerm(oboId string) (int64, bool, error) {
+	var id int64
+	db, acc := splitOBOId(oboId)
+	err := ol.tx.Select("cvterm.cvterm_id").
+		From(`cvterm
+			JOIN dbxref ON dbxref.dbxref_id = cvterm.dbxref_id
+			JOIN db ON db.db_id = dbxref.db_id`).
+		Where("db.name = $1 AND dbxref.accession = $2", db, acc).
+		QueryScalar(&id)
+	switch {
+	case err == dat.ErrNotFound:
+		return id, false, nil
+	case err != nil:
+		return id, false, fmt.Errorf("error in looking up term %s %s", oboId, err)
+	}
+	return id, true, nil
+}
+
+func (ol *oboLoader) targetId(oboId string) (int64, bool, error) {
+	if id, ok := ol.termIds[oboId]; ok {
+		return id, true, nil
+	}
+	id, ok, err := ol.chadoTerm(oboId)
+	if ok {
+		ol.termIds[oboId] = id
+	}
+	return id, ok, err
+}
+
+func (ol *oboLoader) loadRelationships(t *oboTerm) error {
+	rels := make([]*oboRelation, 0, len(t.IsA)+len(t.Relationships))
+	for _, p := range t.IsA {
+		rels = append(rels, &oboRelation{Type: "is_a", Target: p})
+	}
+	rels = append(rels, t.Relationships...)
+	for _, r := range rels {
+		typeId, ok, err := ol.relationshipType(r.Type)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			ol.missing++
+			ol.log.WithFields(logrus.Fields{
+				"type":         "obo-loader",
+				"kind":         "missing-relationship-type",
+				"term":         t.ID,
+				"relationship": r.Type,
+			}).Warn("skipping relationship with unknown type")
+			continue
+		}
+		objectId, ok, err := ol.targetId(r.Target)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			ol.missing++
+			ol.log.WithFields(logrus.Fields{
+				"type":         "obo-loader",
+				"kind":         "missing-term",
+				"term":         t.ID,
+				"relationship": r.Type,
+				"target":       r.Target,
+			}).Warn("skipping relationship to unknown term")
+			continue
+		}
+		res, err := ol.tx.SQL(insertCvtermRelationship, typeId, ol.termIds[t.ID], objectId).Exec()
+		if err != nil {
+			return fmt.Errorf("error in inserting relationship %s %s %s %s", t.ID, r.Type, r.Target, err)
+		}
+		ol.add("cvterm_relationship", changeInsert, res.RowsAffected)
+	}
+	return nil
+}
+
+// loadMetadata keeps the header of the ontology as properties of every cv it
+// loaded, the property types come from the cv_property ontology
+func (ol *oboLoader) loadMetadata() error {
+	var cvs []string
+	for cv := range ol.termCvs {
+		cvs = append(cvs, cv)
+	}
+	sort.Strings(cvs)
+	for _, tag := range oboHeaderProps {
+		value := ol.doc.Tag(tag)
+		if len(value) == 0 {
+			continue
+		}
+		var typeId int64
+		err := ol.tx.Select("cvterm.cvterm_id").
+			From("cvterm JOIN cv ON cv.cv_id = cvterm.cv_id").
+			Where("cv.name = $1 AND cvterm.name = $2", cvPropCv, tag).
+			QueryScalar(&typeId)
+		if err != nil {
+			if err == dat.ErrNotFound {
+				ol.log.WithFields(logrus.Fields{
+					"type": "obo-loader",
+					"tag":  tag,
+				}).Debug("no cv_property term for header tag")
+				continue
+			}
+			return fmt.Errorf("error in looking up cv_property %s %s", tag, err)
+		}
+		for _, cv := range cvs {
+			cvId := ol.cvIds[cv]
+			res, err := ol.tx.DeleteFrom("cvprop").
+				Where("cv_id = $1 AND type_id = $2", cvId, typeId).
+				Exec()
+			if err != nil {
+				return fmt.Errorf("error in removing cvprop %s of %s %s", tag, cv, err)
+			}
+			ol.add("cvprop", changeDelete, res.RowsAffected)
+			_, err = ol.tx.InsertInto("cvprop").
+				Columns("cv_id", "type_id", "value").
+				Values(cvId, typeId, value).
+				Exec()
+			if err != nil {
+				return fmt.Errorf("error in inserting cvprop %s of %s %s", tag, cv, err)
+			}
+			ol.add("cvprop", changeInsert, 1)
+		}
+	}
+	return nil
+}
+
+// oboVersion is the data-version of the ontology, or its date when it is
+// not versioned
+func oboVersion(doc *oboDocument) string {
+	if v := doc.Tag("data-version"); len(v) > 0 {
+		return v
+	}
+	return doc.Tag("date")
+}
+
+// storedOboVersion returns the version of the ontology recorded by its last
+// load
+func storedOboVersion(dbh *runner.DB, doc *oboDocument) (string, bool, error) {
+	var version string
+	err := dbh.SQL(oboVersionSQL, doc.Namespace(), oboVersionProp, cvPropCv).QueryScalar(&version)
+	switch {
+	case err == dat.ErrNotFound:
+		return version, false, nil
+	case err != nil:
+		return version, false, fmt.Errorf("error in looking up version of %s %s", doc.Namespace(), err)
+	}
+	return version, true, nil
+}
+
+// recordOboVersion keeps the version of the ontology as a property of its
+// default cv
+func recordOboVersion(tx *runner.Tx, doc *oboDocument) error {
+	version := oboVersion(doc)
+	if len(version) == 0 {
+		return nil
+	}
+	return recordOboProp(tx, doc, oboVersionProp, "version of the loaded ontology", version)
+}
+
+// recordOboCommit keeps the github commit the ontology was read from
+func recordOboCommit(tx *runner.Tx, doc *oboDocument, commit string) error {
+	if len(commit) == 0 {
+		return nil
+	}
+	return recordOboProp(tx, doc, oboCommitProp, "github commit of the loaded ontology", commit)
+}
+
+// recordOboProp replaces a property of the default cv of the ontology, the
+// type of the property is created in cv_property when needed
+func recordOboProp(tx *runner.Tx, doc *oboDocument, prop, def, value string) error {
+	var cvId, id int64
+	if err := tx.SQL(upsertCv, doc.Namespace()).QueryScalar(&cvId); err != nil {
+		return fmt.Errorf("error in finding or creating cv %s %s", doc.Namespace(), err)
+	}
+	if err := tx.SQL(upsertCv, cvPropCv).QueryScalar(&id); err != nil {
+		return fmt.Errorf("error in finding or creating cv %s %s", cvPropCv, err)
+	}
+	if err := tx.SQL(upsertDb, "internal").QueryScalar(&id); err != nil {
+		return fmt.Errorf("error in finding or creating db internal %s", err)
+	}
+	typeId, err := findOrCreateCvterm(cvPropCv, prop, def, tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.DeleteFrom("cvprop").
+		Where("cv_id = $1 AND type_id = $2", cvId, typeId).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("error in removing %s of %s %s", prop, doc.Namespace(), err)
+	}
+	_, err = tx.InsertInto("cvprop").
+		Columns("cv_id", "type_id", "value").
+		Values(cvId, typeId, value).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("error in recording %s of %s %s", prop, doc.Namespace(), err)
+	}
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
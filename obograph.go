@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	oboPurlPrefix = "http://purl.obolibrary.org/obo/"
+	formatOBO     = "obo"
+	formatJSON    = "json"
+	formatOWL     = "owl"
+)
+
+// ontologyFormats are the file extensions of the supported formats
+var ontologyFormats = map[string]string{
+	formatOBO:  ".obo",
+	formatJSON: ".json",
+	formatOWL:  ".owl",
+}
+
+// annotationTags maps the local name of the annotation properties of OWL
+// to the tags of OBO
+var annotationTags = map[string]string{
+	"label":               "name",
+	"IAO_0000115":         "def",
+	"comment":             "comment",
+	"hasOBONamespace":     "namespace",
+	"hasExactSynonym":     "EXACT",
+	"hasBroadSynonym":     "BROAD",
+	"hasNarrowSynonym":    "NARROW",
+	"hasRelatedSynonym":   "RELATED",
+	"hasDbXref":           "xref",
+	"hasAlternativeId":    "alt_id",
+	"IAO_0100001":         "replaced_by",
+	"consider":            "consider",
+	"inSubset":            "subset",
+	"deprecated":          "is_obsolete",
+	"shorthand":           "shorthand",
+	"id":                  "id",
+	"default-namespace":   "default-namespace",
+	"hasOBOFormatVersion": "format-version",
+	"versionInfo":         "data-version",
+	"date":                "date",
+	"saved-by":            "saved-by",
+}
+
+var oboIRIPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)_(.+)$`)
+
+// oboIdFromIRI turns the IRI of an OBO term in its prefixed id, anything
+// else is kept as is
+func oboIdFromIRI(iri string) string {
+	if !strings.HasPrefix(iri, oboPurlPrefix) {
+		if idx := strings.Index(iri, "#"); idx >= 0 && strings.HasPrefix(iri, "http") {
+			return iri[idx+1:]
+		}
+		return iri
+	}
+	local := strings.TrimPrefix(iri, oboPurlPrefix)
+	if idx := strings.Index(local, "#"); idx >= 0 {
+		return local[idx+1:]
+	}
+	if m := oboIRIPattern.FindStringSubmatch(local); m != nil {
+		return m[1] + ":" + m[2]
+	}
+	return local
+}
+
+// localName is the part of an IRI after its namespace
+func localName(iri string) string {
+	if idx := strings.LastIndexAny(iri, "#/"); idx >= 0 {
+		return iri[idx+1:]
+	}
+	if idx := strings.Index(iri, ":"); idx >= 0 {
+		return iri[idx+1:]
+	}
+	return iri
+}
+
+// ontologyName is the name of the ontology from the IRI of its file
+func ontologyName(iri string) string {
+	name := localName(iri)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// dataVersion turns a version IRI in the data-version of OBO, that is the
+// path relative to the obo namespace without the file name
+func dataVersion(iri string) string {
+	if !strings.HasPrefix(iri, oboPurlPrefix) {
+		return iri
+	}
+	return filepath.Dir(strings.TrimPrefix(iri, oboPurlPrefix))
+}
+
+type graphEdge struct {
+	sub  string
+	pred string
+	obj  string
+}
+
+// graphBuilder collects the nodes, annotations and edges of the OWL based
+// formats into the same document as an OBO file
+type graphBuilder struct {
+	doc       *oboDocument
+	terms     map[string]*oboTerm
+	shorthand map[string]string
+	edges     []*graphEdge
+}
+
+func newGraphBuilder() *graphBuilder {
+	return &graphBuilder{
+		doc:       &oboDocument{Header: make(map[string][]string)},
+		terms:     make(map[string]*oboTerm),
+		shorthand: make(map[string]string),
+	}
+}
+
+func (b *graphBuilder) header(tag, value string) {
+	if len(value) > 0 {
+		b.doc.Header[tag] = append(b.doc.Header[tag], value)
+	}
+}
+
+func (b *graphBuilder) setHeader(tag, value string) {
+	if len(value) > 0 {
+		b.doc.Header[tag] = []string{value}
+	}
+}
+
+// annotateHeader adds an ontology annotation to the header
+func (b *graphBuilder) annotateHeader(pred, value string) {
+	tag, ok := annotationTags[localName(pred)]
+	if !ok {
+		return
+	}
+	switch tag {
+	case "default-namespace", "format-version", "date", "saved-by":
+		b.setHeader(tag, value)
+	case "data-version":
+		if len(b.doc.Tag(tag)) == 0 {
+			b.setHeader(tag, value)
+		}
+	}
+}
+
+// node returns the term of the IRI, it is created when not known
+func (b *graphBuilder) node(iri string, typedef bool) *oboTerm {
+	id := oboIdFromIRI(iri)
+	if t, ok := b.terms[id]; ok {
+		t.Typedef = t.Typedef || typedef
+		return t
+	}
+	t := &oboTerm{ID: id, Typedef: typedef}
+	b.terms[id] = t
+	return t
+}
+
+// annotate sets an annotation of a term, the ones without an OBO tag are
+// kept as property values
+func (b *graphBuilder) annotate(iri, pred, value string, xrefs []string) {
+	t := b.node(iri, false)
+	tag, ok := annotationTags[localName(pred)]
+	if !ok {
+		t.Properties = append(t.Properties, &oboProperty{Name: oboIdFromIRI(pred), Value: oboIdFromIRI(value)})
+		return
+	}
+	switch tag {
+	case "name":
+		t.Name = value
+	case "def":
+		t.Def = value
+		t.DefXrefs = append(t.DefXrefs, xrefs...)
+	case "comment":
+		t.Comment = value
+	case "namespace":
+		t.Namespace = value
+	case "EXACT", "BROAD", "NARROW", "RELATED":
+		t.Synonyms = append(t.Synonyms, &oboSynonym{Text: value, Scope: tag, Xrefs: xrefs})
+	case "xref":
+		t.Xrefs = append(t.Xrefs, value)
+	case "alt_id":
+		t.AltIds = append(t.AltIds, value)
+	case "replaced_by":
+		t.ReplacedBy = append(t.ReplacedBy, oboIdFromIRI(value))
+	case "consider":
+		t.Consider = append(t.Consider, oboIdFromIRI(value))
+	case "subset":
+		t.Subsets = append(t.Subsets, localName(value))
+	case "is_obsolete":
+		t.Obsolete = value == "true"
+	case "shorthand":
+		b.shorthand[t.ID] = value
+	}
+}
+
+func (b *graphBuilder) edge(sub, pred, obj string) {
+	b.edges = append(b.edges, &graphEdge{sub: sub, pred: pred, obj: obj})
+}
+
+// finish names the relations by their shorthand, as they are in OBO, and
+// adds the edges to the terms
+func (b *graphBuilder) finish() *oboDocument {
+	ids := make(map[string]string)
+	for id, short := range b.shorthand {
+		t := b.terms[id]
+		if !t.Typedef {
+			continue
+		}
+		t.Xrefs = append(t.Xrefs, id)
+		t.ID = short
+		ids[id] = short
+	}
+	rename := func(iri string) string {
+		id := oboIdFromIRI(iri)
+		if short, ok := ids[id]; ok {
+			return short
+		}
+		return id
+	}
+	for _, e := range b.edges {
+		t, ok := b.terms[oboIdFromIRI(e.sub)]
+		if !ok {
+			continue
+		}
+		obj := rename(e.obj)
+		switch pred := rename(e.pred); pred {
+		case "is_a", "subPropertyOf":
+			t.IsA = append(t.IsA, obj)
+		default:
+			t.Relationships = append(t.Relationships, &oboRelation{Type: pred, Target: obj})
+		}
+	}
+	for _, t := range b.terms {
+		if t.Typedef {
+			b.doc.Typedefs = append(b.doc.Typedefs, t)
+		} else {
+			b.doc.Terms = append(b.doc.Terms, t)
+		}
+	}
+	sortTerms(b.doc.Typedefs)
+	sortTerms(b.doc.Terms)
+	return b.doc
+}
+
+func sortTerms(terms []*oboTerm) {
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].ID < terms[j].ID
+	})
+}
+
+type obographDocument struct {
+	Graphs []*obograph `json:"graphs"`
+}
+
+type obograph struct {
+	ID    string          `json:"id"`
+	Meta  *obographMeta   `json:"meta"`
+	Nodes []*obographNode `json:"nodes"`
+	Edges []*obographEdge `json:"edges"`
+}
+
+type obographMeta struct {
+	Definition          *obographDefinition `json:"definition"`
+	Comments            []string            `json:"comments"`
+	Subsets             []string            `json:"subsets"`
+	Synonyms            []*obographSynonym  `json:"synonyms"`
+	Xrefs               []*obographXref     `json:"xrefs"`
+	BasicPropertyValues []*obographProperty `json:"basicPropertyValues"`
+	Deprecated          bool                `json:"deprecated"`
+	Version             string              `json:"version"`
+}
+
+type obographDefinition struct {
+	Val   string   `json:"val"`
+	Xrefs []string `json:"xrefs"`
+}
+
+type obographSynonym struct {
+	Pred  string   `json:"pred"`
+	Val   string   `json:"val"`
+	Xrefs []string `json:"xrefs"`
+}
+
+type obographXref struct {
+	Val string `json:"val"`
+}
+
+type obographProperty struct {
+	Pred string `json:"pred"`
+	Val  string `json:"val"`
+}
+
+type obographNode struct {
+	ID   string        `json:"id"`
+	Lbl  string        `json:"lbl"`
+	Type string        `json:"type"`
+	Meta *obographMeta `json:"meta"`
+}
+
+type obographEdge struct {
+	Sub  string `json:"sub"`
+	Pred string `json:"pred"`
+	Obj  string `json:"obj"`
+}
+
+// readOBOGraphFile reads an ontology in obographs JSON, the header comes
+// from the first graph and the others are merged into it
+func readOBOGraphFile(file string) (*oboDocument, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var og obographDocument
+	if err := json.NewDecoder(r).Decode(&og); err != nil {
+		return nil, fmt.Errorf("error in decoding %s %s", file, err)
+	}
+	if len(og.Graphs) == 0 {
+		return nil, fmt.Errorf("no graph in %s", file)
+	}
+	b := newGraphBuilder()
+	first := og.Graphs[0]
+	b.setHeader("ontology", ontologyName(first.ID))
+	if first.Meta != nil {
+		b.setHeader("data-version", dataVersion(first.Meta.Version))
+		for _, p := range first.Meta.BasicPropertyValues {
+			b.annotateHeader(p.Pred, p.Val)
+		}
+	}
+	for _, g := range og.Graphs {
+		for _, n := range g.Nodes {
+			switch n.Type {
+			case "CLASS":
+				b.addNode(n, false)
+			case "PROPERTY":
+				b.addNode(n, true)
+			}
+		}
+		for _, e := range g.Edges {
+			b.edge(e.Sub, e.Pred, e.Obj)
+		}
+	}
+	return b.finish(), nil
+}
+
+func (b *graphBuilder) addNode(n *obographNode, typedef bool) {
+	t := b.node(n.ID, typedef)
+	if len(n.Lbl) > 0 {
+		t.Name = n.Lbl
+	}
+	m := n.Meta
+	if m == nil {
+		return
+	}
+	if m.Definition != nil {
+		t.Def = m.Definition.Val
+		t.DefXrefs = m.Definition.Xrefs
+	}
+	if len(m.Comments) > 0 {
+		t.Comment = strings.Join(m.Comments, " ")
+	}
+	for _, s := range m.Subsets {
+		t.Subsets = append(t.Subsets, localName(s))
+	}
+	for _, s := range m.Synonyms {
+		b.annotate(n.ID, s.Pred, s.Val, s.Xrefs)
+	}
+	for _, x := range m.Xrefs {
+		t.Xrefs = append(t.Xrefs, x.Val)
+	}
+	for _, p := range m.BasicPropertyValues {
+		b.annotate(n.ID, p.Pred, p.Val, nil)
+	}
+	t.Obsolete = t.Obsolete || m.Deprecated
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOBOGraph = `{
+  "graphs": [{
+    "id": "http://purl.obolibrary.org/obo/dpo.json",
+    "meta": {
+      "version": "http://purl.obolibrary.org/obo/dpo/releases/2018-01-10/dpo.json",
+      "basicPropertyValues": [
+        {"pred": "http://www.geneontology.org/formats/oboInOwl#default-namespace", "val": "dicty_phenotypes"},
+        {"pred": "http://www.geneontology.org/formats/oboInOwl#hasOBOFormatVersion", "val": "1.2"},
+        {"pred": "http://purl.org/dc/elements/1.1/creator", "val": "dictyBase"}
+      ]
+    },
+    "nodes": [{
+      "id": "http://purl.obolibrary.org/obo/DDPHENO_0000001",
+      "lbl": "aberrant spore morphology",
+      "type": "CLASS",
+      "meta": {
+        "definition": {"val": "Spores look odd.", "xrefs": ["PMID:123"]},
+        "comments": ["first", "second"],
+        "subsets": ["http://purl.obolibrary.org/obo/dpo#slim"],
+        "synonyms": [
+          {"pred": "hasExactSynonym", "val": "odd spore", "xrefs": ["PMID:456"]},
+          {"pred": "hasNarrowSynonym", "val": "tiny spore"}
+        ],
+        "xrefs": [{"val": "GO:0030435"}],
+        "basicPropertyValues": [
+          {"pred": "http://www.geneontology.org/formats/oboInOwl#hasOBONamespace", "val": "dicty_phenotypes"},
+          {"pred": "http://www.geneontology.org/formats/oboInOwl#hasAlternativeId", "val": "DDPHENO:0000009"},
+          {"pred": "http://www.w3.org/2000/01/rdf-schema#seeAlso", "val": "http://purl.obolibrary.org/obo/DDPHENO_0000003"}
+        ]
+      }
+    }, {
+      "id": "http://purl.obolibrary.org/obo/DDPHENO_0000002",
+      "lbl": "abnormal",
+      "type": "CLASS",
+      "meta": {
+        "deprecated": true,
+        "basicPropertyValues": [
+          {"pred": "http://purl.obolibrary.org/obo/IAO_0100001", "val": "http://purl.obolibrary.org/obo/DDPHENO_0000001"}
+        ]
+      }
+    }, {
+      "id": "http://purl.obolibrary.org/obo/RO_0002202",
+      "lbl": "develops from",
+      "type": "PROPERTY",
+      "meta": {
+        "basicPropertyValues": [
+          {"pred": "http://www.geneontology.org/formats/oboInOwl#shorthand", "val": "develops_from"}
+        ]
+      }
+    }, {
+      "id": "http://purl.obolibrary.org/obo/DDPHENO_0000003",
+      "type": "INDIVIDUAL"
+    }],
+    "edges": [
+      {"sub": "http://purl.obolibrary.org/obo/DDPHENO_0000001", "pred": "is_a", "obj": "http://purl.obolibrary.org/obo/DDPHENO_0000002"},
+      {"sub": "http://purl.obolibrary.org/obo/DDPHENO_0000001", "pred": "http://purl.obolibrary.org/obo/RO_0002202", "obj": "http://purl.obolibrary.org/obo/DDPHENO_0000002"},
+      {"sub": "http://purl.obolibrary.org/obo/DDPHENO_0000404", "pred": "is_a", "obj": "http://purl.obolibrary.org/obo/DDPHENO_0000002"}
+    ]
+  }, {
+    "id": "http://purl.obolibrary.org/obo/dpo/extra.json",
+    "nodes": [{"id": "http://purl.obolibrary.org/obo/DDPHENO_0000005", "lbl": "extra", "type": "CLASS"}],
+    "edges": [{"sub": "http://purl.obolibrary.org/obo/DDPHENO_0000005", "pred": "is_a", "obj": "http://purl.obolibrary.org/obo/DDPHENO_0000001"}]
+  }]
+}`
+
+// tempOntology writes the content in a temporary file with the given name
+func tempOntology(t *testing.T, name, ct string) (string, func()) {
+	dir, err := ioutil.TempDir("", "ontology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(ct), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return file, func() { os.RemoveAll(dir) }
+}
+
+func TestReadOBOGraphFile(t *testing.T) {
+	file, cleanup := tempOntology(t, "dpo.json", testOBOGraph)
+	defer cleanup()
+	d, err := readOntologyFile(file)
+	if err != nil {
+		t.Fatalf("unable to read obograph %s", err)
+	}
+	for tag, value := range map[string]string{
+		"ontology":          "dpo",
+		"data-version":      "dpo/releases/2018-01-10",
+		"default-namespace": "dicty_phenotypes",
+		"format-version":    "1.2",
+	} {
+		if v := d.Tag(tag); v != value {
+			t.Errorf("expected header %s %q, got %q", tag, value, v)
+		}
+	}
+	if _, ok := d.Header["creator"]; ok {
+		t.Error("expected an unknown ontology annotation to be dropped")
+	}
+	var ids []string
+	for _, term := range d.Terms {
+		ids = append(ids, term.ID)
+	}
+	if expected := []string{"DDPHENO:0000001", "DDPHENO:0000002", "DDPHENO:0000005"}; !reflect.DeepEqual(ids, expected) {
+		t.Fatalf("expected terms %v, got %v", expected, ids)
+	}
+	expected := &oboTerm{
+		ID:        "DDPHENO:0000001",
+		Name:      "aberrant spore morphology",
+		Namespace: "dicty_phenotypes",
+		Def:       "Spores look odd.",
+		DefXrefs:  []string{"PMID:123"},
+		Comment:   "first second",
+		IsA:       []string{"DDPHENO:0000002"},
+		Relationships: []*oboRelation{
+			{Type: "develops_from", Target: "DDPHENO:0000002"},
+		},
+		Synonyms: []*oboSynonym{
+			{Text: "odd spore", Scope: "EXACT", Xrefs: []string{"PMID:456"}},
+			{Text: "tiny spore", Scope: "NARROW"},
+		},
+		Xrefs:      []string{"GO:0030435"},
+		AltIds:     []string{"DDPHENO:0000009"},
+		Subsets:    []string{"slim"},
+		Properties: []*oboProperty{{Name: "seeAlso", Value: "DDPHENO:0000003"}},
+	}
+	if !reflect.DeepEqual(d.Terms[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, d.Terms[0])
+	}
+	obs := d.Terms[1]
+	if !obs.Obsolete || !reflect.DeepEqual(obs.ReplacedBy, []string{"DDPHENO:0000001"}) {
+		t.Fatalf("expected an obsolete term replaced by DDPHENO:0000001, got %+v", obs)
+	}
+	if !reflect.DeepEqual(d.Terms[2].IsA, []string{"DDPHENO:0000001"}) {
+		t.Fatalf("expected the edge of the second graph, got %v", d.Terms[2].IsA)
+	}
+	if len(d.Typedefs) != 1 {
+		t.Fatalf("expected a typedef, got %d", len(d.Typedefs))
+	}
+	td := d.Typedefs[0]
+	if td.ID != "develops_from" || !reflect.DeepEqual(td.Xrefs, []string{"RO:0002202"}) {
+		t.Fatalf("expected the typedef named by its shorthand, got %+v", td)
+	}
+}
+
+func TestReadOBOGraphMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ct   string
+		err  string
+	}{
+		{name: "not json", ct: "format-version: 1.2", err: "error in decoding"},
+		{name: "truncated", ct: `{"graphs": [{"id": "dpo"`, err: "error in decoding"},
+		{name: "wrong type", ct: `{"graphs": {"id": "dpo"}}`, err: "error in decoding"},
+		{name: "no graph", ct: `{"graphs": []}`, err: "no graph in"},
+		{name: "empty document", ct: `{}`, err: "no graph in"},
+	} {
+		file, cleanup := tempOntology(t, "bad.json", tc.ct)
+		_, err := readOBOGraphFile(file)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error with %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestOBOIdFromIRI(t *testing.T) {
+	for iri, id := range map[string]string{
+		"http://purl.obolibrary.org/obo/GO_0000001":       "GO:0000001",
+		"http://purl.obolibrary.org/obo/NCBITaxon_44689":  "NCBITaxon:44689",
+		"http://purl.obolibrary.org/obo/ro#part_of":       "part_of",
+		"http://purl.obolibrary.org/obo/dpo":              "dpo",
+		"http://www.w3.org/2000/01/rdf-schema#subClassOf": "subClassOf",
+		"http://dictybase.org/terms/DDB_G0267178":         "http://dictybase.org/terms/DDB_G0267178",
+		"GO:0000001": "GO:0000001",
+		"is_a":       "is_a",
+		"http://purl.obolibrary.org/obo/GO_0000001_extra_1": "GO:0000001_extra_1",
+	} {
+		if v := oboIdFromIRI(iri); v != id {
+			t.Errorf("%s: expected %s, got %s", iri, id, v)
+		}
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	for iri, version := range map[string]string{
+		"http://purl.obolibrary.org/obo/go/releases/2018-01-01/go.owl": "go/releases/2018-01-01",
+		"http://purl.obolibrary.org/obo/ro.owl":                        ".",
+		"2018-01-01":                                                   "2018-01-01",
+	} {
+		if v := dataVersion(iri); v != version {
+			t.Errorf("%s: expected %s, got %s", iri, version, v)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// the outcome of a newly obsoleted term
+const (
+	obsoleteRepointed = "repointed"
+	obsoletePending   = "pending"
+	obsoleteUnused    = "unused"
+	obsoleteManual    = "manual"
+)
+
+// duplicate annotations of a stock once the obsolete term is replaced
+const deleteDuplicateStockCvterm = `
+DELETE FROM stock_cvterm old
+WHERE old.cvterm_id = $1
+AND EXISTS (
+	SELECT 1 FROM stock_cvterm repl
+	WHERE repl.cvterm_id = $2
+	AND repl.stock_id = old.stock_id
+	AND repl.pub_id = old.pub_id
+	AND repl.rank = old.rank
+)
+	`
+
+// phenotype columns that refer to a term
+var phenotypeTermColumns = []string{"observable_id", "attr_id", "cvalue_id", "assay_id"}
+
+// obsoleteTerm is a term obsoleted by the current load along with the
+// references to it
+type obsoleteTerm struct {
+	ID           string
+	Name         string
+	CvtermID     int64
+	ReplacedBy   []string
+	Consider     []string
+	StockCvterms int64
+	Phenotypes   int64
+	Action       string
+	Reason       string
+}
+
+// readPrevious keeps the terms of the cvs of the ontology as they are before
+// the load
+func (ol *oboLoader) readPrevious() error {
+	ol.previous = make(map[string]*chadoTerm)
+	seen := make(map[string]bool)
+	for _, t := range ol.doc.Terms {
+		cv := ol.doc.TermNamespace(t)
+		if seen[cv] {
+			continue
+		}
+		seen[cv] = true
+		terms, err := readChadoTerms(ol.tx, cv)
+		if err != nil {
+			return err
+		}
+		for id, ct := range terms {
+			ol.previous[id] = ct
+		}
+	}
+	return nil
+}
+
+// markObsolete records a term that was not obsolete before the load
+func (ol *oboLoader) markObsolete(t *oboTerm, id int64) {
+	if !t.Obsolete {
+		return
+	}
+	ct, ok := ol.previous[t.ID]
+	if !ok || ct.Obsolete != 0 {
+		return
+	}
+	ol.obsoleted = append(ol.obsoleted, &obsoleteTerm{
+		ID:         t.ID,
+		Name:       ct.Name,
+		CvtermID:   id,
+		ReplacedBy: t.ReplacedBy,
+		Consider:   t.Consider,
+	})
+}
+
+// migrateObsolete counts the references to the newly obsoleted terms and,
+// when asked for, moves them to the replacing term. Terms with only consider
+// candidates are left for manual curation.
+func (ol *oboLoader) migrateObsolete() error {
+	for _, o := range ol.obsoleted {
+		var u termUsage
+		if err := ol.tx.SQL(termUsageSQL, o.CvtermID).QueryStruct(&u); err != nil {
+			return fmt.Errorf("error in counting usage of %s %s", o.ID, err)
+		}
+		o.StockCvterms = u.StockCvterms
+		o.Phenotypes = u.Phenotypes
+		switch {
+		case o.StockCvterms+o.Phenotypes == 0:
+			o.Action = obsoleteUnused
+			continue
+		case len(o.ReplacedBy) == 0:
+			o.Action = obsoleteManual
+			o.Reason = "no replaced_by"
+			if len(o.Consider) > 0 {
+				o.Reason = "consider candidates"
+			}
+			continue
+		case len(o.ReplacedBy) > 1:
+			o.Action = obsoleteManual
+			o.Reason = "more than one replaced_by"
+			continue
+		case !ol.c.Bool("migrate-obsolete"):
+			o.Action = obsoletePending
+			o.Reason = "use --migrate-obsolete to repoint"
+			continue
+		}
+		replId, ok, err := ol.targetId(o.ReplacedBy[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			o.Action = obsoleteManual
+			o.Reason = fmt.Sprintf("replacing term %s is not loaded", o.ReplacedBy[0])
+			continue
+		}
+		if err := ol.repoint(o, replId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ol *oboLoader) repoint(o *obsoleteTerm, replId int64) error {
+	res, err := ol.tx.SQL(deleteDuplicateStockCvterm, o.CvtermID, replId).Exec()
+	if err != nil {
+		return fmt.Errorf("error in removing duplicate annotations of %s %s", o.ID, err)
+	}
+	ol.add("stock_cvterm", changeDelete, res.RowsAffected)
+	merged := res.RowsAffected
+	res, err = ol.tx.Update("stock_cvterm").
+		Set("cvterm_id", replId).
+		Where("cvterm_id = $1", o.CvtermID).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("error in repointing stock annotations of %s %s", o.ID, err)
+	}
+	ol.add("stock_cvterm", changeUpdate, res.RowsAffected)
+	stocks := res.RowsAffected
+	var phenotypes int64
+	for _, col := range phenotypeTermColumns {
+		res, err := ol.tx.Update("phenotype").
+			Set(col, replId).
+			Where(col+" = $1", o.CvtermID).
+			Exec()
+		if err != nil {
+			return fmt.Errorf("error in repointing phenotypes of %s %s", o.ID, err)
+		}
+		ol.add("phenotype", changeUpdate, res.RowsAffected)
+		phenotypes += res.RowsAffected
+	}
+	o.Action = obsoleteRepointed
+	o.Reason = fmt.Sprintf("to %s", o.ReplacedBy[0])
+	ol.report.sample("stock_cvterm", changeUpdate, map[string]interface{}{
+		"obsolete":    o.ID,
+		"replaced_by": o.ReplacedBy[0],
+		"stocks":      stocks,
+	})
+	ol.log.WithFields(logrus.Fields{
+		"type":        "obo-loader",
+		"kind":        "obsolete-repointed",
+		"term":        o.ID,
+		"replaced_by": o.ReplacedBy[0],
+		"stocks":      stocks,
+		"merged":      merged,
+		"phenotypes":  phenotypes,
+	}).Info("repointed references of obsolete term")
+	return nil
+}
+
+// writeObsoleteReport keeps the newly obsoleted terms in the log folder, so
+// that it is shipped along with the logs by upload-log. In a dry run nothing
+// is changed, the report is then named and labeled as the planned changes.
+func writeObsoleteReport(c *cli.Context, name string, terms []*obsoleteTerm) (string, error) {
+	prefix := "ontology-obsolete-"
+	title := "# newly obsoleted terms of %s %s\n"
+	if c.GlobalBool("dry-run") {
+		prefix = "ontology-obsolete-planned-"
+		title = "# dry run, nothing is changed, planned changes for the terms obsoleted by %s %s\n"
+	}
+	logf, err := getLogFileName(c, prefix+strings.TrimSuffix(name, filepath.Ext(name)))
+	if err != nil {
+		return "", err
+	}
+	file := strings.TrimSuffix(logf, ".log") + ".tsv"
+	w, err := os.Create(file)
+	if err != nil {
+		return file, fmt.Errorf("unable to create obsolete report %s", err)
+	}
+	defer w.Close()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, title, name, time.Now().Format(time.RFC3339))
+	fmt.Fprintln(bw, "id\tname\treplaced_by\tconsider\tstock_cvterms\tphenotypes\taction\treason")
+	for _, o := range terms {
+		fmt.Fprintf(
+			bw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			o.ID, o.Name,
+			strings.Join(o.ReplacedBy, ","), strings.Join(o.Consider, ","),
+			o.StockCvterms, o.Phenotypes, o.Action, o.Reason,
+		)
+	}
+	return file, bw.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+func TestWriteObsoleteReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	terms := []*obsoleteTerm{{
+		ID:           "DDPHENO:0000002",
+		Name:         "abnormal",
+		ReplacedBy:   []string{"DDPHENO:0000001"},
+		StockCvterms: 2,
+		Action:       obsoleteRepointed,
+	}}
+	for _, tc := range []struct {
+		dryRun bool
+		prefix string
+		title  string
+	}{
+		{dryRun: false, prefix: "ontology-obsolete-dpo_", title: "# newly obsoleted terms of dpo.obo"},
+		{dryRun: true, prefix: "ontology-obsolete-planned-dpo_", title: "# dry run, nothing is changed, planned changes for the terms obsoleted by dpo.obo"},
+	} {
+		set := flag.NewFlagSet("import", flag.ContinueOnError)
+		set.String("local-log-path", dir, "")
+		set.Bool("dry-run", tc.dryRun, "")
+		file, err := writeObsoleteReport(cli.NewContext(cli.NewApp(), set, nil), "dpo.obo", terms)
+		if err != nil {
+			t.Fatalf("unable to write report %s", err)
+		}
+		if !strings.HasPrefix(filepath.Base(file), tc.prefix) || filepath.Ext(file) != ".tsv" {
+			t.Errorf("unexpected report file %s", file)
+		}
+		ct, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(string(ct), "\n")
+		if !strings.HasPrefix(lines[0], tc.title) {
+			t.Errorf("expected the title %q, got %q", tc.title, lines[0])
+		}
+		if lines[2] != "DDPHENO:0000002\tabnormal\tDDPHENO:0000001\t\t2\t0\trepointed\t" {
+			t.Errorf("unexpected report line %q", lines[2])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// outcome of a lookup in the cache
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// ontologyCache keeps the downloaded ontologies by their source url, every
+// file is stored along with the metadata to validate it on the next run
+type ontologyCache struct {
+	dir string
+}
+
+// cacheEntry is the metadata of a cached file, the ETag and Last-Modified
+// headers of purl and the commit of github
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Commit       string    `json:"commit,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+func newOntologyCache(c *cli.Context) (*ontologyCache, error) {
+	dir := c.String("cache-dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create cache folder %s %s", dir, err)
+	}
+	return &ontologyCache{dir: dir}, nil
+}
+
+func (oc *ontologyCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(oc.dir, hex.EncodeToString(sum[:]))
+}
+
+// read returns the cached file of the url, the entry is nil when it is not
+// in the cache
+func (oc *ontologyCache) read(url string) (*cacheEntry, []byte, error) {
+	p := oc.path(url)
+	meta, err := ioutil.ReadFile(p + ".json")
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read cache of %s %s", url, err)
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(meta, entry); err != nil {
+		return nil, nil, fmt.Errorf("unable to decode cache of %s %s", url, err)
+	}
+	data, err := ioutil.ReadFile(p + ".data")
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read cache of %s %s", url, err)
+	}
+	return entry, data, nil
+}
+
+// write stores the file before its metadata, so that an interrupted write
+// is never taken as a valid entry
+func (oc *ontologyCache) write(entry *cacheEntry, data []byte) error {
+	p := oc.path(entry.URL)
+	entry.Fetched = time.Now()
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	os.Remove(p + ".json")
+	for _, f := range []struct {
+		name string
+		ct   []byte
+	}{{p + ".data", data}, {p + ".json", meta}} {
+		tmp := f.name + ".tmp"
+		if err := ioutil.WriteFile(tmp, f.ct, 0644); err != nil {
+			return fmt.Errorf("unable to write cache of %s %s", entry.URL, err)
+		}
+		if err := os.Rename(tmp, f.name); err != nil {
+			return fmt.Errorf("unable to write cache of %s %s", entry.URL, err)
+		}
+	}
+	return nil
+}
+
+// offline serves the cached file of the url without any validation
+func (oc *ontologyCache) offline(name, url string) *OntoFile {
+	entry, data, err := oc.read(url)
+	if err != nil {
+		return &OntoFile{Error: err, Name: name}
+	}
+	if entry == nil {
+		return &OntoFile{
+			Error: fmt.Errorf("%s is not in the cache, it can not be fetched offline", url),
+			Name:  name,
+		}
+	}
+	return &OntoFile{
+		Name:    name,
+		Content: string(data),
+		Commit:  entry.Commit,
+		URL:     url,
+		Cache:   cacheHit,
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const dbAccessionsSQL = `
+SELECT dbxref.accession FROM cvterm
+JOIN dbxref ON dbxref.dbxref_id = cvterm.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE db.name = $1
+	`
+
+// missingTerms are the terms of a prefix referred by an ontology that are
+// neither loaded nor part of the requested ontologies
+type missingTerms struct {
+	ontology string
+	prefix   string
+	example  string
+	count    int
+}
+
+// ontologyDeps works out the dependencies among the ontologies from their
+// import headers and the prefixes of the terms they refer to
+type ontologyDeps struct {
+	c        *cli.Context
+	log      *logrus.Logger
+	dbh      sqlRunner
+	registry *ontologyRegistry
+	dir      string
+	onts     []*registryOntology
+	docs     map[string]*oboDocument
+	provides map[string]string
+	loaded   map[string]map[string]bool
+	unread   map[string]bool
+}
+
+// orderOntologies sorts the ontologies so that every one is loaded after
+// the ones it depends on. The dependencies that are neither loaded nor
+// requested are fetched with --fetch-dependencies, otherwise it is an
+// error before anything gets loaded.
+func orderOntologies(c *cli.Context, log *logrus.Logger, dir string, onts []*registryOntology) ([]*registryOntology, error) {
+	registry, err := readRegistry(c.String("registry"))
+	if err != nil {
+		return onts, err
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return onts, fmt.Errorf("unable to create database connection %s", err)
+	}
+	od := &ontologyDeps{
+		c:        c,
+		log:      log,
+		dbh:      dbh,
+		registry: registry,
+		dir:      dir,
+		docs:     make(map[string]*oboDocument),
+		provides: make(map[string]string),
+		loaded:   make(map[string]map[string]bool),
+		unread:   make(map[string]bool),
+	}
+	if err := od.add(onts); err != nil {
+		return onts, err
+	}
+	for {
+		deps, missing, err := od.dependencies()
+		if err != nil {
+			return onts, err
+		}
+		if len(missing) == 0 {
+			return od.sort(deps)
+		}
+		if !c.Bool("fetch-dependencies") {
+			return onts, missingError(missing)
+		}
+		if err := od.fetch(missing); err != nil {
+			return onts, err
+		}
+	}
+}
+
+// add reads the ontologies and keeps the ontology of each of their terms.
+// obo2chado has its own parser, a file that is not read here is left to it
+// without any dependencies.
+func (od *ontologyDeps) add(onts []*registryOntology) error {
+	for _, o := range onts {
+		doc, err := readOntologyFile(filepath.Join(od.dir, o.file()))
+		if err != nil && !od.c.Bool("obo2chado") {
+			return fmt.Errorf("error in reading %s %s", o.file(), err)
+		}
+		if err != nil {
+			od.log.WithFields(logrus.Fields{
+				"type": "ontology-dependency",
+				"kind": "parse-issue",
+				"file": o.file(),
+			}).Warnf("unable to read dependencies, loading in the given order %s", err)
+			doc = &oboDocument{Header: make(map[string][]string)}
+			od.unread[o.Name] = true
+		}
+		od.onts = append(od.onts, o)
+		od.docs[o.Name] = doc
+		for _, t := range append(append([]*oboTerm{}, doc.Typedefs...), doc.Terms...) {
+			if _, ok := od.provides[t.ID]; !ok {
+				od.provides[t.ID] = o.Name
+			}
+		}
+	}
+	return nil
+}
+
+// dependencies returns the ontologies every ontology depends on along with
+// the terms that none of them provides
+func (od *ontologyDeps) dependencies() (map[string]map[string]bool, []*missingTerms, error) {
+	deps := make(map[string]map[string]bool)
+	var missing []*missingTerms
+	for _, o := range od.onts {
+		doc := od.docs[o.Name]
+		deps[o.Name] = make(map[string]bool)
+		for _, imp := range doc.Header["import"] {
+			name := ontologyName(imp)
+			if _, ok := od.docs[name]; ok && name != o.Name {
+				deps[o.Name][name] = true
+			}
+		}
+		own := docPrefixes(doc)
+		byPrefix := make(map[string]*missingTerms)
+		for _, ref := range termReferences(doc) {
+			if name, ok := od.provides[ref]; ok {
+				if name != o.Name {
+					deps[o.Name][name] = true
+				}
+				continue
+			}
+			prefix, acc := splitOBOId(ref)
+			if prefix == "_global" || prefix == "http" || prefix == "https" || own[prefix] {
+				continue
+			}
+			// the terms of an unread ontology are taken to be provided by it
+			if name := od.registry.byPrefix(prefix); od.unread[name] {
+				if name != o.Name {
+					deps[o.Name][name] = true
+				}
+				continue
+			}
+			ok, err := od.inChado(prefix, acc)
+			if err != nil {
+				return deps, missing, err
+			}
+			if ok {
+				continue
+			}
+			m, ok := byPrefix[prefix]
+			if !ok {
+				m = &missingTerms{ontology: o.Name, prefix: prefix, example: ref}
+				byPrefix[prefix] = m
+				missing = append(missing, m)
+			}
+			m.count++
+		}
+	}
+	return deps, missing, nil
+}
+
+// inChado tells if the term is already loaded, the accessions are read
+// once for every prefix
+func (od *ontologyDeps) inChado(prefix, acc string) (bool, error) {
+	accs, ok := od.loaded[prefix]
+	if !ok {
+		var all []string
+		err := od.dbh.SQL(dbAccessionsSQL, prefix).QuerySlice(&all)
+		if err != nil && err != dat.ErrNotFound {
+			return false, fmt.Errorf("error in looking up terms of %s %s", prefix, err)
+		}
+		accs = make(map[string]bool)
+		for _, a := range all {
+			accs[a] = true
+		}
+		od.loaded[prefix] = accs
+	}
+	return accs[acc], nil
+}
+
+// fetch adds the ontologies that provide the missing terms
+func (od *ontologyDeps) fetch(missing []*missingTerms) error {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range missing {
+		name := od.registry.byPrefix(m.prefix)
+		if _, ok := od.docs[name]; ok {
+			return fmt.Errorf(
+				"%s refers to %d terms of %s such as %s, they are not in %s which is expected to provide them",
+				m.ontology, m.count, m.prefix, m.example, name,
+			)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+		od.log.WithFields(logrus.Fields{
+			"type":       "ontology-dependency",
+			"kind":       "fetch",
+			"ontology":   m.ontology,
+			"dependency": name,
+			"prefix":     m.prefix,
+			"terms":      m.count,
+		}).Info("fetching missing dependency")
+	}
+	onts, err := resolveOntologies(od.c, names)
+	if err != nil {
+		return err
+	}
+	if err := fetchOntologies(od.c, od.log, od.dir, onts); err != nil {
+		return err
+	}
+	return od.add(onts)
+}
+
+// sort orders the ontologies by their dependencies, keeping the requested
+// order otherwise
+func (od *ontologyDeps) sort(deps map[string]map[string]bool) ([]*registryOntology, error) {
+	var sorted []*registryOntology
+	done := make(map[string]bool)
+	for len(sorted) < len(od.onts) {
+		found := false
+		for _, o := range od.onts {
+			if done[o.Name] || !dependenciesLoaded(deps[o.Name], done) {
+				continue
+			}
+			done[o.Name] = true
+			sorted = append(sorted, o)
+			found = true
+			break
+		}
+		if !found {
+			var cyclic []string
+			for _, o := range od.onts {
+				if !done[o.Name] {
+					cyclic = append(cyclic, o.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle among ontologies %s", strings.Join(cyclic, ","))
+		}
+	}
+	var order []string
+	for _, o := range sorted {
+		order = append(order, o.Name)
+	}
+	od.log.WithFields(logrus.Fields{
+		"type":  "ontology-dependency",
+		"kind":  "order",
+		"order": strings.Join(order, ","),
+	}).Info("ordered ontologies by their dependencies")
+	return sorted, nil
+}
+
+func dependenciesLoaded(deps map[string]bool, done map[string]bool) bool {
+	for d := range deps {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+func missingError(missing []*missingTerms) error {
+	var msgs []string
+	for _, m := range missing {
+		msgs = append(msgs, fmt.Sprintf(
+			"%s refers to %d terms of %s such as %s",
+			m.ontology, m.count, m.prefix, m.example,
+		))
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf(
+		"missing dependencies, the terms are neither loaded nor part of the requested ontologies, load them first or use --fetch-dependencies: %s",
+		strings.Join(msgs, "; "),
+	)
+}
+
+// docPrefixes returns the prefixes of the terms of the ontology
+func docPrefixes(doc *oboDocument) map[string]bool {
+	prefixes := make(map[string]bool)
+	for _, t := range doc.Terms {
+		prefix, _ := splitOBOId(t.ID)
+		prefixes[prefix] = true
+	}
+	return prefixes
+}
+
+// termReferences returns the parents, relationship targets and relationship
+// types the terms of the ontology refer to
+func termReferences(doc *oboDocument) []string {
+	var refs []string
+	for _, t := range append(append([]*oboTerm{}, doc.Typedefs...), doc.Terms...) {
+		refs = append(refs, t.IsA...)
+		for _, r := range t.Relationships {
+			refs = append(refs, r.Type, r.Target)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const chadoTermSQL = `
+SELECT cvterm.cvterm_id,db.name AS db,dbxref.accession,cvterm.name,cvterm.is_obsolete
+FROM cvterm
+JOIN cv ON cv.cv_id = cvterm.cv_id
+JOIN dbxref ON dbxref.dbxref_id = cvterm.dbxref_id
+JOIN db ON db.db_id = dbxref.db_id
+WHERE cv.name = $1
+	`
+
+const chadoParentSQL = `
+SELECT subject.cvterm_id,tdb.name AS type_db,tdbxref.accession AS type_accession,
+	odb.name AS object_db,odbxref.accession AS object_accession
+FROM cvterm_relationship
+JOIN cvterm subject ON subject.cvterm_id = cvterm_relationship.subject_id
+JOIN cv ON cv.cv_id = subject.cv_id
+JOIN cvterm rtype ON rtype.cvterm_id = cvterm_relationship.type_id
+JOIN dbxref tdbxref ON tdbxref.dbxref_id = rtype.dbxref_id
+JOIN db tdb ON tdb.db_id = tdbxref.db_id
+JOIN cvterm object ON object.cvterm_id = cvterm_relationship.object_id
+JOIN dbxref odbxref ON odbxref.dbxref_id = object.dbxref_id
+JOIN db odb ON odb.db_id = odbxref.db_id
+WHERE cv.name = $1
+	`
+
+const termUsageSQL = `
+SELECT
+	(SELECT COUNT(*) FROM stock_cvterm WHERE cvterm_id = $1) AS stock_cvterms,
+	(SELECT COUNT(*) FROM phenotype
+		WHERE observable_id = $1 OR attr_id = $1 OR cvalue_id = $1 OR assay_id = $1) AS phenotypes
+	`
+
+const (
+	termAdded      = "added"
+	termRemoved    = "removed"
+	termRenamed    = "renamed"
+	termReparented = "reparented"
+	termObsoleted  = "obsoleted"
+)
+
+// sqlRunner is either of a database handle or a transaction
+type sqlRunner interface {
+	SQL(sql string, args ...interface{}) *runner.RawBuilder
+}
+
+// chadoTerm is a term of a cv in chado identified by its OBO id
+type chadoTerm struct {
+	CvtermID  int64  `db:"cvterm_id"`
+	Db        string `db:"db"`
+	Accession string `db:"accession"`
+	Name      string `db:"name"`
+	Obsolete  int    `db:"is_obsolete"`
+	Parents   []string
+}
+
+func (t *chadoTerm) ID() string {
+	return chadoOboId(t.Db, t.Accession)
+}
+
+type chadoParent struct {
+	CvtermID        int64  `db:"cvterm_id"`
+	TypeDb          string `db:"type_db"`
+	TypeAccession   string `db:"type_accession"`
+	ObjectDb        string `db:"object_db"`
+	ObjectAccession string `db:"object_accession"`
+}
+
+type termUsage struct {
+	StockCvterms int64 `db:"stock_cvterms"`
+	Phenotypes   int64 `db:"phenotypes"`
+}
+
+// chadoOboId turns a dbxref back to the OBO id, the ids without any prefix
+// and the internal terms are kept in dbs of their own
+func chadoOboId(db, acc string) string {
+	if db == "_global" || db == "internal" || db == "OBO_REL" {
+		return acc
+	}
+	return db + ":" + acc
+}
+
+// readChadoTerms returns the terms of a cv by their OBO id along with
+// their parents
+func readChadoTerms(dbh sqlRunner, cv string) (map[string]*chadoTerm, error) {
+	var terms []*chadoTerm
+	err := dbh.SQL(chadoTermSQL, cv).QueryStructs(&terms)
+	if err != nil && err != dat.ErrNotFound {
+		return nil, fmt.Errorf("error in querying terms of %s %s", cv, err)
+	}
+	byId := make(map[int64]*chadoTerm)
+	current := make(map[string]*chadoTerm)
+	for _, t := range terms {
+		byId[t.CvtermID] = t
+		current[t.ID()] = t
+	}
+	var parents []*chadoParent
+	err = dbh.SQL(chadoParentSQL, cv).QueryStructs(&parents)
+	if err != nil && err != dat.ErrNotFound {
+		return nil, fmt.Errorf("error in querying relationships of %s %s", cv, err)
+	}
+	for _, p := range parents {
+		t := byId[p.CvtermID]
+		t.Parents = append(t.Parents, fmt.Sprintf(
+			"%s %s",
+			chadoOboId(p.TypeDb, p.TypeAccession),
+			chadoOboId(p.ObjectDb, p.ObjectAccession),
+		))
+	}
+	return current, nil
+}
+
+// termParents returns the relationships of a term in the form of
+// readChadoTerms
+func termParents(t *oboTerm) []string {
+	var parents []string
+	for _, p := range t.IsA {
+		parents = append(parents, "is_a "+p)
+	}
+	for _, r := range t.Relationships {
+		parents = append(parents, r.Type+" "+r.Target)
+	}
+	return parents
+}
+
+// termChange is a change of a single term, a term that is both renamed and
+// reparented has a change for each
+type termChange struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Change       string `json:"change"`
+	Detail       string `json:"detail,omitempty"`
+	CvtermID     int64  `json:"-"`
+	StockCvterms int64  `json:"stock_cvterms"`
+	Phenotypes   int64  `json:"phenotypes"`
+}
+
+type ontologyDiff struct {
+	Cv            string         `json:"cv"`
+	Version       string         `json:"version"`
+	LoadedVersion string         `json:"loaded_version"`
+	Counts        map[string]int `json:"counts"`
+	Changes       []*termChange  `json:"changes"`
+}
+
+// diffOntology compares the terms of an ontology with the ones in the cv
+func diffOntology(doc *oboDocument, cv string, current map[string]*chadoTerm) []*termChange {
+	var changes []*termChange
+	incoming := make(map[string]bool)
+	for _, t := range doc.Typedefs {
+		incoming[t.ID] = true
+	}
+	for _, t := range doc.Terms {
+		if doc.TermNamespace(t) != cv {
+			continue
+		}
+		incoming[t.ID] = true
+		ct, ok := current[t.ID]
+		if !ok {
+			changes = append(changes, &termChange{ID: t.ID, Name: t.Name, Change: termAdded})
+			continue
+		}
+		if len(t.Name) > 0 && t.Name != ct.Name && !strings.HasPrefix(ct.Name, t.Name+" (") {
+			changes = append(changes, &termChange{
+				ID:       t.ID,
+				Name:     t.Name,
+				Change:   termRenamed,
+				Detail:   fmt.Sprintf("%s -> %s", ct.Name, t.Name),
+				CvtermID: ct.CvtermID,
+			})
+		}
+		if added, removed := diffStrings(ct.Parents, termParents(t)); len(added)+len(removed) > 0 {
+			var detail []string
+			for _, p := range added {
+				detail = append(detail, "+"+p)
+			}
+			for _, p := range removed {
+				detail = append(detail, "-"+p)
+			}
+			changes = append(changes, &termChange{
+				ID:       t.ID,
+				Name:     t.Name,
+				Change:   termReparented,
+				Detail:   strings.Join(detail, ","),
+				CvtermID: ct.CvtermID,
+			})
+		}
+		if t.Obsolete && ct.Obsolete == 0 {
+			detail := ""
+			if len(t.ReplacedBy) > 0 {
+				detail = "replaced_by " + strings.Join(t.ReplacedBy, ",")
+			}
+			changes = append(changes, &termChange{
+				ID:       t.ID,
+				Name:     t.Name,
+				Change:   termObsoleted,
+				Detail:   detail,
+				CvtermID: ct.CvtermID,
+			})
+		}
+	}
+	for id, ct := range current {
+		if !incoming[id] {
+			changes = append(changes, &termChange{
+				ID:       id,
+				Name:     ct.Name,
+				Change:   termRemoved,
+				CvtermID: ct.CvtermID,
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
+
+// diffStrings returns the values of b not in a, and of a not in b
+func diffStrings(a, b []string) ([]string, []string) {
+	as := make(map[string]bool)
+	for _, v := range a {
+		as[v] = true
+	}
+	bs := make(map[string]bool)
+	for _, v := range b {
+		bs[v] = true
+	}
+	var added, removed []string
+	for v := range bs {
+		if !as[v] {
+			added = append(added, v)
+		}
+	}
+	for v := range as {
+		if !bs[v] {
+			removed = append(removed, v)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func validateOntoDiff(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(c.String("file")) == 0 {
+		return cli.NewExitError("file of the incoming ontology is needed", 2)
+	}
+	return nil
+}
+
+func OntoDiffAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "onto-diff")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	doc, err := readOntologyFile(c.String("file"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	cv := c.String("cv")
+	if len(cv) == 0 {
+		cv = doc.Namespace()
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	current, err := readChadoTerms(dbh, cv)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	diff := &ontologyDiff{
+		Cv:      cv,
+		Version: oboVersion(doc),
+		Counts:  make(map[string]int),
+		Changes: diffOntology(doc, cv, current),
+	}
+	if v, ok, err := storedOboVersion(dbh, doc); err == nil && ok {
+		diff.LoadedVersion = v
+	}
+	for _, ch := range diff.Changes {
+		diff.Counts[ch.Change]++
+		if ch.CvtermID == 0 {
+			continue
+		}
+		var u termUsage
+		if err := dbh.SQL(termUsageSQL, ch.CvtermID).QueryStruct(&u); err != nil {
+			return cli.NewExitError(fmt.Sprintf("error in counting usage of %s %s", ch.ID, err), 2)
+		}
+		ch.StockCvterms = u.StockCvterms
+		ch.Phenotypes = u.Phenotypes
+	}
+	log.WithFields(logrus.Fields{
+		"type":       "onto-diff",
+		"cv":         cv,
+		"added":      diff.Counts[termAdded],
+		"removed":    diff.Counts[termRemoved],
+		"renamed":    diff.Counts[termRenamed],
+		"reparented": diff.Counts[termReparented],
+		"obsoleted":  diff.Counts[termObsoleted],
+	}).Info("compared ontology")
+	if len(c.String("json")) > 0 {
+		err := writeExport(c.String("json"), c.App.Writer, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(diff)
+		})
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("error in writing json report %s", err), 2)
+		}
+		if c.String("json") == "-" {
+			return nil
+		}
+	}
+	return printOntologyDiff(c.App.Writer, diff)
+}
+
+func printOntologyDiff(w io.Writer, diff *ontologyDiff) error {
+	fmt.Fprintf(w, "cv %s, loaded version %s, incoming version %s\n", diff.Cv, diff.LoadedVersion, diff.Version)
+	for _, ch := range []string{termAdded, termRemoved, termRenamed, termReparented, termObsoleted} {
+		fmt.Fprintf(w, "%-11s %d\n", ch, diff.Counts[ch])
+	}
+	if len(diff.Changes) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tID\tNAME\tSTOCKS\tPHENOTYPES\tDETAIL")
+	for _, ch := range diff.Changes {
+		fmt.Fprintf(
+			tw, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			ch.Change, ch.ID, ch.Name, ch.StockCvterms, ch.Phenotypes, ch.Detail,
+		)
+	}
+	return tw.Flush()
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -14,15 +17,16 @@ import (
 	"github.com/google/go-github/github"
 	"github.com/jackc/pgx"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/mgutz/dat.v1/sqlx-runner"
 )
 
+// sources of the ontology files
 const (
-	repository   = "migration-data"
-	roRepository = "obo-relations"
-	owner        = "dictyBase"
-	basePath     = "ontologies"
-	roBasePath   = "subsets"
-	purlBase     = "http://purl.obolibrary.org/obo"
+	sourcePurl   = "purl"
+	sourceGithub = "github"
+	sourceDir    = "dir"
+	sourceS3     = "s3"
 )
 
 type Obo struct {
@@ -33,20 +37,131 @@ type OntoFile struct {
 	Content string
 	Error   error
 	Name    string
+	Commit  string
+	URL     string
+	Cache   string
 }
 
-type contentFn func(string, chan<- *OntoFile)
+type contentFn func(*registryOntology, chan<- *OntoFile)
 
 func validateOnto(c *cli.Context) error {
+	// cli runs this before any of the subcommands, which have their own
+	// validation
+	if c.NArg() > 0 && c.App.Command(c.Args().First()) != nil {
+		return nil
+	}
+	if c.Bool("obo2chado") {
+		if err := validateNoDryRun(c, "onto --obo2chado"); err != nil {
+			return err
+		}
+	}
 	if err := validateArgs(c); err != nil {
 		return err
 	}
 	if len(c.StringSlice("obo")) == 0 {
 		cli.NewExitError("no obo file given", 2)
 	}
+	if c.Bool("migrate-obsolete") && c.Bool("obo2chado") {
+		return cli.NewExitError("migrate-obsolete is only supported by the builtin loader", 2)
+	}
+	onts, err := resolveOntologies(c, append([]string{"cv_property"}, c.StringSlice("obo")...))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	for _, o := range onts {
+		if o.Source != sourceS3 {
+			continue
+		}
+		if c.Bool("offline") {
+			return cli.NewExitError(fmt.Sprintf("%s is read from s3, which is not available offline", o.Name), 2)
+		}
+		return validateS3Args(c)
+	}
 	return nil
 }
 
+// ontologySources reads the source of every ontology given as name=source,
+// the rest come from the source selected by the flags
+func ontologySources(c *cli.Context) (map[string]string, error) {
+	sources := make(map[string]string)
+	switch {
+	case len(c.String("obo-dir")) > 0:
+		sources[""] = sourceDir
+	case len(c.String("s3")) > 0:
+		sources[""] = sourceS3
+	case c.Bool("purl"):
+		sources[""] = sourcePurl
+	case c.Bool("github"):
+		sources[""] = sourceGithub
+	}
+	for _, s := range c.StringSlice("source") {
+		idx := strings.Index(s, "=")
+		if idx < 1 {
+			return sources, fmt.Errorf("source %s should be given as name=source", s)
+		}
+		name, src := s[:idx], s[idx+1:]
+		switch src {
+		case sourcePurl, sourceGithub, sourceDir, sourceS3:
+		default:
+			return sources, fmt.Errorf("unknown source %s of %s, should be either of purl, github, dir or s3", src, name)
+		}
+		sources[name] = src
+	}
+	return sources, nil
+}
+
+// ontologySource is the source of an ontology. The source of the registry
+// takes over the remote ones selected by the flags, as not every ontology is
+// published through purl, but a local folder or S3 is used for all. The
+// ontologies without any source are fetched through purl.
+func ontologySource(sources map[string]string, o *registryOntology) string {
+	if src, ok := sources[o.Name]; ok {
+		return src
+	}
+	src, ok := sources[""]
+	switch {
+	case ok && (src == sourceDir || src == sourceS3):
+		return src
+	case len(o.Source) > 0:
+		return o.Source
+	case !ok:
+		return sourcePurl
+	}
+	return src
+}
+
+// ontologyFormatMap reads the formats of the ontologies given either as
+// name=format or as a single format for all of them
+func ontologyFormatMap(c *cli.Context) (map[string]string, error) {
+	formats := make(map[string]string)
+	for _, f := range c.StringSlice("format") {
+		name, format := "", f
+		if idx := strings.Index(f, "="); idx >= 0 {
+			name, format = f[:idx], f[idx+1:]
+		}
+		if _, ok := ontologyFormats[format]; !ok {
+			return formats, fmt.Errorf("unsupported format %s, should be either of obo, json or owl", format)
+		}
+		formats[name] = format
+	}
+	return formats, nil
+}
+
+// ontologyFormat is the format of an ontology, a format given for the
+// ontology comes first followed by the one of the registry
+func ontologyFormat(formats map[string]string, o *registryOntology) string {
+	if format, ok := formats[o.Name]; ok {
+		return format
+	}
+	if len(o.Format) > 0 {
+		return o.Format
+	}
+	if format, ok := formats[""]; ok {
+		return format
+	}
+	return formatOBO
+}
+
 func ontoAction(c *cli.Context) error {
 	log, err := getLogger(c, "ontology")
 	if err != nil {
@@ -62,11 +177,162 @@ func ontoAction(c *cli.Context) error {
 	if err != nil {
 		return cli.NewExitError(err.Error(), 2)
 	}
+	// cv_property comes first as the metadata of the others are typed by
+	// its terms
+	names := c.StringSlice("obo")
+	if !cvp {
+		names = append([]string{"cv_property"}, names...)
+	}
+	onts, err := resolveOntologies(c, names)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
 	// download obo files
-	dir, err := oboDownload(cvp, c)
+	dir, err := oboDownload(c, log, onts)
+	if len(dir) > 0 {
+		defer os.RemoveAll(dir)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	onts, err = orderOntologies(c, log, dir, onts)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 2)
 	}
+	if c.Bool("obo2chado") {
+		return loadOboSubprocess(c, log, dir, cvp, onts)
+	}
+	return loadOboNative(c, log, dir, onts)
+}
+
+// loadOboNative loads every ontology in its own transaction, a failed
+// ontology is logged and the rest are still loaded before it is an error
+func loadOboNative(c *cli.Context, log *logrus.Logger, dir string, onts []*registryOntology) error {
+	dat.EnableInterpolation = true
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	summary := new(ontoSummary)
+	defer summary.log(log)
+	for _, o := range onts {
+		name := o.file()
+		doc, err := readOntologyFile(filepath.Join(dir, name))
+		if err == nil && len(o.Cv) > 0 {
+			doc.Header["default-namespace"] = []string{o.Cv}
+		}
+		if err == nil {
+			var skip bool
+			skip, err = skipOntology(c, dbh, log, doc, name)
+			if skip {
+				summary.skip(name, doc)
+				continue
+			}
+		}
+		if err == nil {
+			err = loadOboFile(c, dbh, log, doc, o)
+		}
+		if err == nil {
+			err = closureAfterLoad(c, dbh, log, doc)
+		}
+		if err == nil {
+			summary.load(name, doc)
+			continue
+		}
+		summary.fail(name, doc)
+		log.WithFields(logrus.Fields{
+			"type": "obo-loader",
+			"kind": "loading-issue",
+			"file": name,
+		}).Error(err)
+		if err == errCancelled {
+			return err
+		}
+		if o.Name == "cv_property" {
+			return cli.NewExitError(err.Error(), 2)
+		}
+	}
+	return loadFailure(summary.failed)
+}
+
+// loadFailure is the error of a load where any of the ontologies failed,
+// the rest of them are loaded by then
+func loadFailure(failed []string) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return cli.NewExitError(
+		fmt.Sprintf("unable to load ontologies %s", strings.Join(failed, ",")),
+		2,
+	)
+}
+
+func loadOboFile(c *cli.Context, dbh *runner.DB, log *logrus.Logger, doc *oboDocument, o *registryOntology) error {
+	name := o.file()
+	tx, err := dbh.Begin()
+	if err != nil {
+		return fmt.Errorf("error in starting transaction %s", err)
+	}
+	defer tx.AutoRollback()
+	ol := newOboLoader(c, tx, log, doc)
+	if err := ol.load(); err != nil {
+		return err
+	}
+	if err := recordOboCommit(tx, doc, o.Commit); err != nil {
+		return err
+	}
+	if err := commitTx(c, tx, ol.report, log); err != nil {
+		if err == errCancelled {
+			return err
+		}
+		return fmt.Errorf("error in commiting %s", err)
+	}
+	for t, n := range ol.rows {
+		addRowCount(c, t, n)
+	}
+	if len(ol.obsoleted) > 0 {
+		rfile, err := writeObsoleteReport(c, name, ol.obsoleted)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "obsolete-report",
+				"file": rfile,
+			}).Error(err)
+		} else if c.GlobalBool("dry-run") {
+			log.WithFields(logrus.Fields{
+				"type":      "obsolete-report",
+				"file":      rfile,
+				"obsoleted": len(ol.obsoleted),
+			}).Warn("terms would be obsoleted by the load")
+		} else {
+			log.WithFields(logrus.Fields{
+				"type":      "obsolete-report",
+				"file":      rfile,
+				"obsoleted": len(ol.obsoleted),
+			}).Warn("terms were obsoleted by the load")
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"type":          "obo-loader",
+		"kind":          "loading-success",
+		"file":          name,
+		"version":       oboVersion(doc),
+		"commit":        o.Commit,
+		"terms":         len(doc.Terms),
+		"typedefs":      len(doc.Typedefs),
+		"relationships": ol.rows["cvterm_relationship"],
+		"missing":       ol.missing,
+	}).Info("ontology loaded successfully")
+	return nil
+}
+
+// loadOboSubprocess loads the ontologies through the obo2chado and
+// adhocobo2chado commands of modware-load, like the builtin loader a failed
+// ontology does not stop the rest
+func loadOboSubprocess(c *cli.Context, log *logrus.Logger, dir string, cvp bool, onts []*registryOntology) error {
+	unknownRowCounts(c)
 	ml, err := exec.LookPath("modware-load")
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -82,7 +348,7 @@ func ontoAction(c *cli.Context) error {
 			return cli.NewExitError(fmt.Sprintf("unable to generate command %s", err), 2)
 		}
 		pcmd := append(acmd, filepath.Join(dir, "cv_property.obo"))
-		out, err := exec.Command(ml, pcmd...).CombinedOutput()
+		out, err := runCommand(c, ml, pcmd...)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"type":        "adhocobo2chado-loader",
@@ -103,80 +369,197 @@ func ontoAction(c *cli.Context) error {
 	}
 
 	// Now the other obo files
-	reader, err := ioutil.ReadDir(dir)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"type":      "read ontology directory",
-			"kind":      "error reading",
-			"directory": dir,
-		}).Error(err)
-		return cli.NewExitError(err.Error(), 2)
-	}
 	obocmd, err := makeOboCmd(c)
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf("unable to generate command %s", err), 2)
 	}
-	for _, obo := range reader {
-		if obo.IsDir() {
-			continue
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	summary := new(ontoSummary)
+	defer summary.log(log)
+	for _, o := range onts {
+		name := o.file()
+		// the version is only known when the builtin parser can read the file
+		doc, err := readOBOFile(filepath.Join(dir, name))
+		if err != nil {
+			doc = nil
+			log.WithFields(logrus.Fields{
+				"type": "obo2chado loader",
+				"kind": "unknown-version",
+				"file": name,
+			}).Warn(err)
+		}
+		if doc != nil {
+			skip, err := skipOntology(c, dbh, log, doc, name)
+			if err != nil {
+				summary.fail(name, doc)
+				log.WithFields(logrus.Fields{
+					"type": "obo2chado loader",
+					"kind": "loading-issue",
+					"file": name,
+				}).Error(err)
+				continue
+			}
+			if skip {
+				summary.skip(name, doc)
+				continue
+			}
+		}
+		pcmd := append(obocmd, filepath.Join(dir, name))
+		out, err := runCommand(c, ml, pcmd...)
+		if err == nil && doc != nil {
+			err = recordVersion(c, dbh, log, doc, o.Commit)
+		}
+		if err == nil {
+			err = closureAfterLoad(c, dbh, log, doc)
 		}
-		pcmd := append(obocmd, filepath.Join(dir, obo.Name()))
-		out, err := exec.Command(ml, pcmd...).CombinedOutput()
 		if err != nil {
+			summary.fail(name, doc)
 			log.WithFields(logrus.Fields{
 				"type":        "obo2chado loader",
 				"kind":        "loading-issue",
 				"status":      string(out),
-				"file":        obo.Name(),
+				"file":        name,
 				"commandline": strings.Join(pcmd, " "),
 			}).Error(err)
+			if err == errCancelled {
+				return cli.NewExitError(err.Error(), exitCancelled)
+			}
 			continue
 		}
+		summary.load(name, doc)
 		log.WithFields(logrus.Fields{
 			"type":        "obo2chado loader",
 			"kind":        "loading-success",
 			"status":      string(out),
-			"file":        obo.Name(),
+			"file":        name,
 			"commandline": strings.Join(pcmd, " "),
 		}).Info("ontology loaded successfully")
 	}
-	return nil
+	return loadFailure(summary.failed)
+}
+
+// skipOntology tells if the ontology is of the same version as the one
+// already loaded
+func skipOntology(c *cli.Context, dbh *runner.DB, log *logrus.Logger, doc *oboDocument, name string) (bool, error) {
+	version := oboVersion(doc)
+	if c.Bool("force") || len(version) == 0 {
+		return false, nil
+	}
+	stored, ok, err := storedOboVersion(dbh, doc)
+	if err != nil || !ok || stored != version {
+		return false, err
+	}
+	log.WithFields(logrus.Fields{
+		"type":    "obo-loader",
+		"kind":    "unchanged",
+		"file":    name,
+		"version": version,
+	}).Info("skipping ontology of the same version, use --force to reload")
+	return true, nil
+}
+
+// recordVersion keeps the version of an ontology loaded through obo2chado
+func recordVersion(c *cli.Context, dbh *runner.DB, log *logrus.Logger, doc *oboDocument, commit string) error {
+	tx, err := dbh.Begin()
+	if err != nil {
+		return fmt.Errorf("error in starting transaction %s", err)
+	}
+	defer tx.AutoRollback()
+	if err := recordOboVersion(tx, doc); err != nil {
+		return err
+	}
+	if err := recordOboCommit(tx, doc, commit); err != nil {
+		return err
+	}
+	return commitTx(c, tx, newChangeReport(c), log)
+}
+
+// ontoSummary collects the outcome of every ontology of a run along with
+// their versions
+type ontoSummary struct {
+	loaded  []string
+	skipped []string
+	failed  []string
 }
 
-func oboDownload(cvp bool, c *cli.Context) (string, error) {
-	allObos := make([]string, 0)
-	if cvp {
-		allObos = append(allObos, c.StringSlice("obo")...)
-	} else {
-		allObos = append(allObos, "cv_property")
-		allObos = append(allObos, c.StringSlice("obo")...)
+func ontoLabel(name string, doc *oboDocument) string {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if doc == nil || len(oboVersion(doc)) == 0 {
+		return name
 	}
+	return fmt.Sprintf("%s@%s", name, oboVersion(doc))
+}
+
+func (s *ontoSummary) load(name string, doc *oboDocument) {
+	s.loaded = append(s.loaded, ontoLabel(name, doc))
+}
+
+func (s *ontoSummary) skip(name string, doc *oboDocument) {
+	s.skipped = append(s.skipped, ontoLabel(name, doc))
+}
+
+func (s *ontoSummary) fail(name string, doc *oboDocument) {
+	s.failed = append(s.failed, ontoLabel(name, doc))
+}
+
+func (s *ontoSummary) log(log *logrus.Logger) {
+	log.WithFields(logrus.Fields{
+		"type":    "obo-loader",
+		"kind":    "summary",
+		"loaded":  strings.Join(s.loaded, ","),
+		"skipped": strings.Join(s.skipped, ","),
+		"failed":  strings.Join(s.failed, ","),
+	}).Info("loaded ontologies")
+}
+
+// oboDownload gets the ontologies into a temporary folder
+func oboDownload(c *cli.Context, log *logrus.Logger, onts []*registryOntology) (string, error) {
 	dir, err := ioutil.TempDir("", "obo")
 	if err != nil {
-		return dir, err
+		return "", err
 	}
-	//defer os.RemoveAll(dir)
-	var fn contentFn
-	switch {
-	case c.Bool("purl"):
-		fn = purlContent
-	case c.Bool("github"):
-		fn = githubContent
-	default:
-		return dir, fmt.Errorf("either of %s or %s download source has to be selected", "purl", "github")
-	}
-	ch := make(chan *OntoFile, len(allObos))
-	for _, n := range allObos {
-		if n == "cv_property" {
-			go githubContent(fmt.Sprintf("%s.obo", n), ch)
-			continue
-		}
-		go fn(fmt.Sprintf("%s.obo", n), ch)
+	return dir, fetchOntologies(c, log, dir, onts)
+}
+
+// fetchOntologies gets the ontologies into the folder, the ones from purl
+// and github go through the cache
+func fetchOntologies(c *cli.Context, log *logrus.Logger, dir string, onts []*registryOntology) error {
+	cache, err := newOntologyCache(c)
+	if err != nil {
+		return err
+	}
+	fns := map[string]contentFn{
+		sourcePurl:   purlContent(c, cache),
+		sourceGithub: githubContent(c, cache),
+		sourceDir:    localContent,
+		sourceS3:     s3Content(c),
+	}
+	ch := make(chan *OntoFile, len(onts))
+	byFile := make(map[string]*registryOntology)
+	for _, o := range onts {
+		byFile[o.file()] = o
+		go fns[o.Source](o, ch)
 	}
-	for i := 0; i < len(allObos); i++ {
+	for i := 0; i < len(onts); i++ {
 		file := <-ch
 		if file.Error != nil {
-			return dir, file.Error
+			return file.Error
+		}
+		byFile[file.Name].Commit = file.Commit
+		if len(file.Cache) > 0 {
+			log.WithFields(logrus.Fields{
+				"type":   "ontology-cache",
+				"kind":   file.Cache,
+				"file":   file.Name,
+				"url":    file.URL,
+				"commit": file.Commit,
+			}).Infof("cache %s", file.Cache)
 		}
 		err := ioutil.WriteFile(
 			filepath.Join(dir, file.Name),
@@ -184,60 +567,275 @@ func oboDownload(cvp bool, c *cli.Context) (string, error) {
 			0644,
 		)
 		if err != nil {
-			return dir, err
+			return err
 		}
 	}
-	return dir, nil
+	return nil
 }
 
-func purlContent(name string, ch chan<- *OntoFile) {
-	url := fmt.Sprintf("%s/%s", purlBase, name)
-	res, err := http.Get(url)
-	if err != nil {
-		ch <- &OntoFile{Error: err, Name: name}
-	} else {
+// purlContent validates the cached ontologies through conditional
+// requests, only the changed ones are downloaded
+func purlContent(c *cli.Context, cache *ontologyCache) contentFn {
+	return func(o *registryOntology, ch chan<- *OntoFile) {
+		name := o.file()
+		if c.Bool("offline") {
+			ch <- cache.offline(name, o.Purl)
+			return
+		}
+		entry, data, err := cache.read(o.Purl)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		req, err := http.NewRequest("GET", o.Purl, nil)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		if entry != nil && len(entry.ETag) > 0 {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry != nil && len(entry.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		defer res.Body.Close()
+		switch {
+		case res.StatusCode == http.StatusNotModified && entry != nil:
+			ch <- &OntoFile{Name: name, Content: string(data), URL: o.Purl, Cache: cacheHit}
+			return
+		case res.StatusCode != http.StatusOK:
+			ch <- &OntoFile{Error: fmt.Errorf("unable to fetch %s %s", o.Purl, res.Status), Name: name}
+			return
+		}
 		ct, err := ioutil.ReadAll(res.Body)
 		if err != nil {
 			ch <- &OntoFile{Error: err, Name: name}
-		} else {
-			ch <- &OntoFile{Name: name, Content: string(ct)}
+			return
+		}
+		err = cache.write(&cacheEntry{
+			URL:          o.Purl,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+		}, ct)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		ch <- &OntoFile{Name: name, Content: string(ct), URL: o.Purl, Cache: cacheMiss}
+	}
+}
+
+// localContent reads an ontology from its local path
+func localContent(o *registryOntology, ch chan<- *OntoFile) {
+	ct, err := ioutil.ReadFile(o.Path)
+	if err != nil {
+		ch <- &OntoFile{Error: err, Name: o.file()}
+		return
+	}
+	ch <- &OntoFile{Name: o.file(), Content: string(ct)}
+}
+
+// s3Content reads the ontologies from their keys in the bucket
+func s3Content(c *cli.Context) contentFn {
+	return func(o *registryOntology, ch chan<- *OntoFile) {
+		name := o.file()
+		s3Client, err := getS3Client(c)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		key := o.S3
+		obj, err := s3Client.GetObject(c.GlobalString("s3-bucket"), key)
+		if err != nil {
+			ch <- &OntoFile{Error: fmt.Errorf("unable to retrieve the object %s %s", key, err), Name: name}
+			return
+		}
+		defer obj.Close()
+		ct, err := ioutil.ReadAll(obj)
+		if err != nil {
+			ch <- &OntoFile{Error: fmt.Errorf("unable to read the object %s %s", key, err), Name: name}
+			return
 		}
+		ch <- &OntoFile{Name: name, Content: string(ct)}
+	}
+}
+
+// githubTransport authenticates the requests of the github client
+type githubTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "token "+t.token)
+	return t.base.RoundTrip(r)
+}
+
+// getGithubClient returns a client authenticated by the token when it is
+// given, anonymous clients are rate limited to 60 requests an hour
+func getGithubClient(c *cli.Context) *github.Client {
+	token := c.String("gh-token")
+	if len(token) == 0 {
+		return github.NewClient(nil)
 	}
+	return github.NewClient(&http.Client{
+		Transport: &githubTransport{token: token, base: http.DefaultTransport},
+	})
 }
 
-func getRepository(name string) string {
-	if name == "ro-chado.obo" {
-		return roRepository
+// githubContent reads the ontologies from their commit in github, the
+// commit is kept with the file so that the load can be reproduced. The
+// cached file is used as long as the ref resolves to the same commit.
+func githubContent(c *cli.Context, cache *ontologyCache) contentFn {
+	client := getGithubClient(c)
+	return func(o *registryOntology, ch chan<- *OntoFile) {
+		name := o.file()
+		url := githubURL(o.Github)
+		if c.Bool("offline") {
+			ch <- cache.offline(name, url)
+			return
+		}
+		ctx := context.Background()
+		sha, err := githubCommit(ctx, client, o.Github)
+		if err != nil {
+			ch <- &OntoFile{Error: fmt.Errorf("unable to resolve the commit of %s %s", name, err), Name: name}
+			return
+		}
+		entry, cached, err := cache.read(url)
+		if err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		if entry != nil && entry.Commit == sha {
+			ch <- &OntoFile{Content: string(cached), Name: name, Commit: sha, URL: url, Cache: cacheHit}
+			return
+		}
+		data, err := githubFile(ctx, client, o.Github, sha)
+		if err != nil {
+			ch <- &OntoFile{Error: fmt.Errorf("unable to retrieve %s %s", name, err), Name: name}
+			return
+		}
+		if err := cache.write(&cacheEntry{URL: url, Commit: sha}, []byte(data)); err != nil {
+			ch <- &OntoFile{Error: err, Name: name}
+			return
+		}
+		ch <- &OntoFile{Content: data, Name: name, Commit: sha, URL: url, Cache: cacheMiss}
 	}
-	return repository
 }
 
-func getRepoPath(name string) string {
-	if name == "ro-chado.obo" {
-		return fmt.Sprintf("%s/%s", roBasePath, name)
+// githubURL is the url of the contents api for the file at its ref
+func githubURL(gh *githubLocation) string {
+	return fmt.Sprintf(
+		"https://api.github.com/repos/%s/%s/contents/%s?ref=%s",
+		gh.Owner, gh.Repo, gh.Path, gh.Ref,
+	)
+}
+
+// githubCommit resolves the ref, or the default branch when there is none,
+// to its commit
+func githubCommit(ctx context.Context, client *github.Client, gh *githubLocation) (string, error) {
+	ref := gh.Ref
+	if len(ref) == 0 {
+		repo, _, err := client.Repositories.Get(ctx, gh.Owner, gh.Repo)
+		if err != nil {
+			return "", err
+		}
+		if repo.DefaultBranch == nil {
+			return "", fmt.Errorf("no default branch of %s/%s", gh.Owner, gh.Repo)
+		}
+		ref = *repo.DefaultBranch
 	}
-	return fmt.Sprintf("%s/%s", basePath, name)
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, gh.Owner, gh.Repo, ref, "")
+	return sha, err
 }
 
-func githubContent(name string, ch chan<- *OntoFile) {
-	client := github.NewClient(nil)
+// githubFile reads a file through the contents api, the files over a
+// megabyte are refused by it and are read as a blob instead
+func githubFile(ctx context.Context, client *github.Client, gh *githubLocation, sha string) (string, error) {
 	ct, _, _, err := client.Repositories.GetContents(
-		context.Background(),
-		owner,
-		getRepository(name),
-		getRepoPath(name),
-		nil,
+		ctx,
+		gh.Owner,
+		gh.Repo,
+		gh.Path,
+		&github.RepositoryContentGetOptions{Ref: sha},
 	)
-	if err != nil {
-		ch <- &OntoFile{Error: err}
-	} else {
+	switch {
+	case err == nil && ct != nil:
+		// large files come without any content
 		data, err := ct.GetContent()
-		if err != nil {
-			ch <- &OntoFile{Error: err}
-		} else {
-			ch <- &OntoFile{Content: data, Name: name}
+		if err == nil {
+			return data, nil
+		}
+	case err != nil && !githubTooLarge(err):
+		return "", err
+	}
+	return githubBlob(ctx, client, gh, sha)
+}
+
+func githubTooLarge(err error) bool {
+	er, ok := err.(*github.ErrorResponse)
+	if !ok {
+		return false
+	}
+	for _, e := range er.Errors {
+		if e.Code == "too_large" {
+			return true
+		}
+	}
+	return false
+}
+
+// githubBlob looks up the blob of the file in the listing of its folder
+func githubBlob(ctx context.Context, client *github.Client, gh *githubLocation, sha string) (string, error) {
+	dir := path.Dir(gh.Path)
+	if dir == "." {
+		dir = ""
+	}
+	_, entries, _, err := client.Repositories.GetContents(
+		ctx,
+		gh.Owner,
+		gh.Repo,
+		dir,
+		&github.RepositoryContentGetOptions{Ref: sha},
+	)
+	if err != nil {
+		return "", err
+	}
+	var blobSha string
+	for _, e := range entries {
+		if e.Path != nil && *e.Path == gh.Path && e.SHA != nil {
+			blobSha = *e.SHA
 		}
 	}
+	if len(blobSha) == 0 {
+		return "", fmt.Errorf("%s not found in %s/%s at %s", gh.Path, gh.Owner, gh.Repo, sha)
+	}
+	blob, _, err := client.Git.GetBlob(ctx, gh.Owner, gh.Repo, blobSha)
+	if err != nil {
+		return "", err
+	}
+	if blob.Content == nil {
+		return "", fmt.Errorf("empty blob of %s", gh.Path)
+	}
+	if blob.Encoding == nil || *blob.Encoding != "base64" {
+		return *blob.Content, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Replace(*blob.Content, "\n", "", -1))
+	if err != nil {
+		return "", fmt.Errorf("unable to decode blob of %s %s", gh.Path, err)
+	}
+	return string(data), nil
 }
 
 func iscvPropLoaded(conn *pgx.Conn) (bool, error) {
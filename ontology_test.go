@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// fakeModwareLoad is a modware-load that fails for any file named bad
+const fakeModwareLoad = `#!/bin/sh
+for last; do :; done
+case "$last" in
+*bad*) echo "unable to load $last"; exit 1 ;;
+esac
+echo "loaded $last"
+`
+
+// ontoDir writes the obo files, none of them could be read by the builtin
+// parser so that their versions are never looked up in the database
+func ontoDir(t *testing.T, names ...string) string {
+	dir, err := ioutil.TempDir("", "obo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range names {
+		ct := "format-version: 1.2\nontology: " + strings.TrimSuffix(n, ".obo") + "\n"
+		ct += "[Term]\nname: no id\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, n), []byte(ct), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func ontoContext(t *testing.T) *cli.Context {
+	set := flag.NewFlagSet("import", flag.ContinueOnError)
+	for _, n := range []string{"chado-user", "chado-pass", "pghost", "pgport", "chado-db"} {
+		set.String(n, "", "")
+	}
+	set.Bool("use-log-file", false, "")
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func quietLogger() *logrus.Logger {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	return log
+}
+
+func TestLoadFailure(t *testing.T) {
+	if err := loadFailure(nil); err != nil {
+		t.Fatalf("expected no error without any failure, got %s", err)
+	}
+	err := loadFailure([]string{"go.obo", "eco.obo"})
+	if err == nil {
+		t.Fatal("expected an error for failed ontologies")
+	}
+	if err.Error() != "unable to load ontologies go.obo,eco.obo" {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if ee, ok := err.(cli.ExitCoder); !ok || ee.ExitCode() != 2 {
+		t.Fatal("expected an exit error with code 2")
+	}
+}
+
+func TestLoadOboNativeFailure(t *testing.T) {
+	dir := ontoDir(t, "bad1.obo", "bad2.obo")
+	defer os.RemoveAll(dir)
+	err := loadOboNative(
+		ontoContext(t),
+		quietLogger(),
+		dir,
+		[]*registryOntology{{Name: "bad1", Format: formatOBO}, {Name: "bad2", Format: formatOBO}},
+	)
+	if err == nil {
+		t.Fatal("expected the failed ontologies to fail the load")
+	}
+	if err.Error() != "unable to load ontologies bad1,bad2" {
+		t.Fatalf("expected both failures in the error, got %s", err)
+	}
+}
+
+func TestLoadOboSubprocess(t *testing.T) {
+	bin, err := ioutil.TempDir("", "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bin)
+	if err := ioutil.WriteFile(filepath.Join(bin, "modware-load"), []byte(fakeModwareLoad), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	dir := ontoDir(t, "go.obo", "eco.obo")
+	defer os.RemoveAll(dir)
+	onts := []*registryOntology{{Name: "go", Format: formatOBO}, {Name: "eco", Format: formatOBO}}
+	if err := loadOboSubprocess(ontoContext(t), quietLogger(), dir, true, onts); err != nil {
+		t.Fatalf("expected all ontologies to load, got %s", err)
+	}
+	dir = ontoDir(t, "bad.obo", "eco.obo", "go.obo")
+	defer os.RemoveAll(dir)
+	onts = append(onts, &registryOntology{Name: "bad", Format: formatOBO})
+	err = loadOboSubprocess(ontoContext(t), quietLogger(), dir, true, onts)
+	if err == nil {
+		t.Fatal("expected a failed ontology to fail the load")
+	}
+	if err.Error() != "unable to load ontologies bad" {
+		t.Fatalf("expected the failed ontology in the error, got %s", err)
+	}
+}
+
+func TestValidateOntoSubcommand(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		fail bool
+	}{
+		{args: []string{"diff", "--file", "dpo.obo"}, fail: false},
+		{args: []string{"closure", "--cv", "dicty_phenotypes"}, fail: false},
+		{args: []string{"dpo.obo"}, fail: true},
+		{args: []string{}, fail: true},
+	} {
+		// cli runs the before of onto in an app of its subcommands
+		app := cli.NewApp()
+		app.Commands = []cli.Command{{Name: "diff"}, {Name: "closure"}}
+		set := flag.NewFlagSet("onto", flag.ContinueOnError)
+		if err := set.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		// no database is given, which fails the validation of onto itself
+		err := validateOnto(cli.NewContext(app, set, nil))
+		if tc.fail && err == nil {
+			t.Errorf("%v: expected the validation of onto to fail", tc.args)
+		}
+		if !tc.fail && err != nil {
+			t.Errorf("%v: expected the validation to be left to the subcommand, got %s", tc.args, err)
+		}
+	}
+}
+
+func TestOntologySource(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		sources  map[string]string
+		ont      *registryOntology
+		expected string
+	}{
+		{
+			name:     "no source",
+			sources:  map[string]string{},
+			ont:      &registryOntology{Name: "go"},
+			expected: sourcePurl,
+		},
+		{
+			name:     "registry",
+			sources:  map[string]string{},
+			ont:      &registryOntology{Name: "cv_property", Source: sourceGithub},
+			expected: sourceGithub,
+		},
+		{
+			name:     "registry over remote flag",
+			sources:  map[string]string{"": sourcePurl},
+			ont:      &registryOntology{Name: "cv_property", Source: sourceGithub},
+			expected: sourceGithub,
+		},
+		{
+			name:     "local flag over registry",
+			sources:  map[string]string{"": sourceDir},
+			ont:      &registryOntology{Name: "cv_property", Source: sourceGithub},
+			expected: sourceDir,
+		},
+		{
+			name:     "single ontology",
+			sources:  map[string]string{"": sourceDir, "go": sourceS3},
+			ont:      &registryOntology{Name: "go", Source: sourcePurl},
+			expected: sourceS3,
+		},
+	} {
+		if src := ontologySource(tc.sources, tc.ont); src != tc.expected {
+			t.Errorf("%s: expected source %s, got %s", tc.name, tc.expected, src)
+		}
+	}
+}
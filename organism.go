@@ -26,6 +26,9 @@ type Feedback struct {
 }
 
 func validateOrganism(c *cli.Context) error {
+	if err := validateNoDryRun(c, "organism"); err != nil {
+		return err
+	}
 	if err := validateArgs(c); err != nil {
 		return err
 	}
@@ -33,6 +36,9 @@ func validateOrganism(c *cli.Context) error {
 }
 
 func validateOrganismPlus(c *cli.Context) error {
+	if err := validateNoDryRun(c, "organism-plus"); err != nil {
+		return err
+	}
 	if err := validateArgs(c); err != nil {
 		return err
 	}
@@ -56,15 +62,13 @@ func OrganismPlusAction(c *cli.Context) error {
 	}
 
 	// database connection
-	connPoolConfig, err := getConnPoolConfig(c)
+	connPool, err := getConnPool(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 2)
 	}
-	connPool, err := pgx.NewConnPool(connPoolConfig)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 2)
+	if !isSharedConnPool(c) {
+		defer connPool.Close()
 	}
-	defer connPool.Close()
 	// reading from the file
 	filename, err := fetchRemoteFile(c, "organism")
 	if err != nil {
@@ -85,6 +89,7 @@ func OrganismPlusAction(c *cli.Context) error {
 	if fb.Error != nil {
 		return cli.NewExitError(fb.Error.Error(), 2)
 	}
+	addRowCount(c, "organism", int64(fb.Count))
 	log.WithFields(logrus.Fields{
 		"type": "organism-plus-loader",
 		"kind": "loading-success",
@@ -97,6 +102,7 @@ func OrganismAction(c *cli.Context) error {
 	if err != nil {
 		return cli.NewExitError(err.Error(), 2)
 	}
+	unknownRowCounts(c)
 	dsn := getPostgresDsn(c)
 	mi, err := exec.LookPath("modware-import")
 	if err != nil {
@@ -117,7 +123,7 @@ func OrganismAction(c *cli.Context) error {
 		"--password",
 		c.GlobalString("chado-pass"),
 	}
-	out, err := exec.Command(mi, cmdline...).CombinedOutput()
+	out, err := runCommand(c, mi, cmdline...)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"type":        "organism-loader",
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// owlExpr is either an atom, an IRI, a prefixed name or a literal, or an
+// expression of OWL functional syntax with its arguments
+type owlExpr struct {
+	Name     string
+	Args     []*owlExpr
+	Literal  bool
+	Compound bool
+}
+
+// the standard prefixes of OWL functional syntax
+var owlPrefixes = map[string]string{
+	"rdf":  "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+	"rdfs": "http://www.w3.org/2000/01/rdf-schema#",
+	"xsd":  "http://www.w3.org/2001/XMLSchema#",
+	"owl":  "http://www.w3.org/2002/07/owl#",
+}
+
+type owlTokenizer struct {
+	rd *bufio.Reader
+}
+
+// next returns the next token, a literal keeps its leading quote
+func (t *owlTokenizer) next() (string, error) {
+	for {
+		r, _, err := t.rd.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case r == '#':
+			if _, err := t.rd.ReadString('\n'); err != nil && err != io.EOF {
+				return "", err
+			}
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+		case r == '(' || r == ')':
+			return string(r), nil
+		case r == '<':
+			iri, err := t.rd.ReadString('>')
+			if err != nil {
+				return "", fmt.Errorf("unterminated IRI <%s", iri)
+			}
+			return "<" + iri, nil
+		case r == '"':
+			return t.literal()
+		default:
+			var buf bytes.Buffer
+			buf.WriteRune(r)
+			for {
+				r, _, err := t.rd.ReadRune()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return "", err
+				}
+				if strings.ContainsRune(" \t\r\n()<\"", r) {
+					t.rd.UnreadRune()
+					break
+				}
+				buf.WriteRune(r)
+			}
+			return buf.String(), nil
+		}
+	}
+}
+
+// literal reads a quoted string, its language tag or datatype is dropped
+func (t *owlTokenizer) literal() (string, error) {
+	var buf bytes.Buffer
+	buf.WriteRune('"')
+	for {
+		r, _, err := t.rd.ReadRune()
+		if err != nil {
+			return "", fmt.Errorf("unterminated literal %s", buf.String())
+		}
+		if r == '\\' {
+			r, _, err = t.rd.ReadRune()
+			if err != nil {
+				return "", fmt.Errorf("unterminated literal %s", buf.String())
+			}
+			buf.WriteRune(r)
+			continue
+		}
+		if r == '"' {
+			break
+		}
+		buf.WriteRune(r)
+	}
+	r, _, err := t.rd.ReadRune()
+	switch {
+	case err == io.EOF:
+	case err != nil:
+		return "", err
+	case r == '@' || r == '^':
+		for {
+			r, _, err := t.rd.ReadRune()
+			if err != nil {
+				break
+			}
+			if strings.ContainsRune(" \t\r\n()", r) {
+				t.rd.UnreadRune()
+				break
+			}
+		}
+	default:
+		t.rd.UnreadRune()
+	}
+	return buf.String(), nil
+}
+
+// parseOWLExprs reads all the expressions of a document
+func parseOWLExprs(r io.Reader) ([]*owlExpr, error) {
+	tk := &owlTokenizer{rd: bufio.NewReader(r)}
+	var parents []*owlExpr
+	var top []*owlExpr
+	var pending string
+	emit := func(e *owlExpr) {
+		if len(parents) == 0 {
+			top = append(top, e)
+			return
+		}
+		p := parents[len(parents)-1]
+		p.Args = append(p.Args, e)
+	}
+	for {
+		tok, err := tk.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case "(":
+			if len(pending) == 0 {
+				return nil, fmt.Errorf("parenthesis without an expression name")
+			}
+			e := &owlExpr{Name: pending, Compound: true}
+			pending = ""
+			emit(e)
+			parents = append(parents, e)
+		case ")":
+			if len(pending) > 0 {
+				emit(&owlExpr{Name: pending})
+				pending = ""
+			}
+			if len(parents) == 0 {
+				return nil, fmt.Errorf("unbalanced parenthesis")
+			}
+			parents = parents[:len(parents)-1]
+		default:
+			if len(pending) > 0 {
+				emit(&owlExpr{Name: pending})
+				pending = ""
+			}
+			if strings.HasPrefix(tok, "\"") {
+				emit(&owlExpr{Name: tok[1:], Literal: true})
+				continue
+			}
+			pending = tok
+		}
+	}
+	if len(parents) > 0 {
+		return nil, fmt.Errorf("unbalanced parenthesis")
+	}
+	if len(pending) > 0 {
+		emit(&owlExpr{Name: pending})
+	}
+	return top, nil
+}
+
+// owlReader resolves the names of the expressions through the prefixes of
+// the document
+type owlReader struct {
+	prefixes map[string]string
+	declared map[string]bool
+	b        *graphBuilder
+}
+
+func (o *owlReader) iri(e *owlExpr) (string, bool) {
+	if e == nil || e.Compound || e.Literal {
+		return "", false
+	}
+	if strings.HasPrefix(e.Name, "<") {
+		return strings.TrimSuffix(strings.TrimPrefix(e.Name, "<"), ">"), true
+	}
+	idx := strings.Index(e.Name, ":")
+	if idx < 0 {
+		return "", false
+	}
+	if base, ok := o.prefixes[e.Name[:idx]]; ok {
+		return base + e.Name[idx+1:], true
+	}
+	return e.Name, true
+}
+
+// value is the text of a literal or the IRI of a name
+func (o *owlReader) value(e *owlExpr) (string, bool) {
+	if e.Literal {
+		return e.Name, true
+	}
+	return o.iri(e)
+}
+
+// readOWLFile reads the subset of OWL functional syntax that has a
+// counterpart in OBO: declarations, annotation assertions, named and
+// existential subclass axioms and subproperties
+func readOWLFile(file string) (*oboDocument, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(content)
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		return nil, fmt.Errorf("%s is in RDF/XML, only OWL functional syntax is supported", file)
+	}
+	exprs, err := parseOWLExprs(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("error in parsing %s %s", file, err)
+	}
+	o := &owlReader{
+		prefixes: make(map[string]string),
+		declared: make(map[string]bool),
+		b:        newGraphBuilder(),
+	}
+	for k, v := range owlPrefixes {
+		o.prefixes[k] = v
+	}
+	var onto *owlExpr
+	for _, e := range exprs {
+		switch e.Name {
+		case "Prefix":
+			if len(e.Args) == 2 {
+				name := strings.TrimSuffix(strings.TrimSuffix(e.Args[0].Name, "="), ":")
+				iri, _ := o.iri(e.Args[1])
+				o.prefixes[name] = iri
+			}
+		case "Ontology":
+			onto = e
+		}
+	}
+	if onto == nil {
+		return nil, fmt.Errorf("no ontology in %s", file)
+	}
+	o.readOntology(onto)
+	return o.b.finish(), nil
+}
+
+func (o *owlReader) readOntology(onto *owlExpr) {
+	var axioms []*owlExpr
+	var iris []string
+	for _, a := range onto.Args {
+		if !a.Compound {
+			if iri, ok := o.iri(a); ok {
+				iris = append(iris, iri)
+			}
+			continue
+		}
+		axioms = append(axioms, a)
+	}
+	if len(iris) > 0 {
+		o.b.setHeader("ontology", ontologyName(iris[0]))
+	}
+	if len(iris) > 1 {
+		o.b.setHeader("data-version", dataVersion(iris[1]))
+	}
+	for _, a := range axioms {
+		if a.Name != "Declaration" || len(a.Args) != 1 {
+			continue
+		}
+		iri, ok := o.iri(firstArg(a.Args[0]))
+		if !ok {
+			continue
+		}
+		switch a.Args[0].Name {
+		case "Class":
+			o.declared[iri] = true
+			o.b.node(iri, false)
+		case "ObjectProperty":
+			o.declared[iri] = true
+			o.b.node(iri, true)
+		}
+	}
+	for _, a := range axioms {
+		args := withoutAnnotations(a.Args)
+		switch a.Name {
+		case "Import":
+			if iri, ok := o.iri(firstArg(a)); ok {
+				o.b.header("import", iri)
+			}
+		case "Annotation":
+			if len(a.Args) == 2 {
+				pred, _ := o.iri(a.Args[0])
+				if v, ok := o.value(a.Args[1]); ok {
+					o.b.annotateHeader(pred, v)
+				}
+			}
+		case "AnnotationAssertion":
+			o.annotationAssertion(a, args)
+		case "SubClassOf", "SubObjectPropertyOf":
+			o.subClassOf(args)
+		}
+	}
+}
+
+func (o *owlReader) annotationAssertion(a *owlExpr, args []*owlExpr) {
+	if len(args) != 3 {
+		return
+	}
+	pred, ok := o.iri(args[0])
+	if !ok {
+		return
+	}
+	subject, ok := o.iri(args[1])
+	if !ok || !o.declared[subject] {
+		return
+	}
+	value, ok := o.value(args[2])
+	if !ok {
+		return
+	}
+	var xrefs []string
+	for _, ann := range a.Args {
+		if ann.Name != "Annotation" || len(ann.Args) != 2 {
+			continue
+		}
+		if p, _ := o.iri(ann.Args[0]); localName(p) == "hasDbXref" {
+			if v, ok := o.value(ann.Args[1]); ok {
+				xrefs = append(xrefs, v)
+			}
+		}
+	}
+	o.b.annotate(subject, pred, value, xrefs)
+}
+
+// subClassOf adds an is_a for a named superclass and a relationship for an
+// existential restriction, other class expressions are skipped
+func (o *owlReader) subClassOf(args []*owlExpr) {
+	if len(args) != 2 {
+		return
+	}
+	sub, ok := o.iri(args[0])
+	if !ok || !o.declared[sub] {
+		return
+	}
+	if super, ok := o.iri(args[1]); ok {
+		o.b.edge(sub, "is_a", super)
+		return
+	}
+	if args[1].Name != "ObjectSomeValuesFrom" || len(args[1].Args) != 2 {
+		return
+	}
+	pred, ok := o.iri(args[1].Args[0])
+	if !ok {
+		return
+	}
+	if obj, ok := o.iri(args[1].Args[1]); ok {
+		o.b.edge(sub, pred, obj)
+	}
+}
+
+func firstArg(e *owlExpr) *owlExpr {
+	if len(e.Args) == 0 {
+		return nil
+	}
+	return e.Args[0]
+}
+
+// withoutAnnotations drops the axiom annotations from the arguments
+func withoutAnnotations(args []*owlExpr) []*owlExpr {
+	var rest []*owlExpr
+	for _, a := range args {
+		if a.Compound && a.Name == "Annotation" {
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return rest
+}
+
+// readOntologyFile reads an ontology in any of the supported formats, the
+// format is known from the extension of the file
+func readOntologyFile(file string) (*oboDocument, error) {
+	switch filepath.Ext(file) {
+	case ontologyFormats[formatJSON]:
+		return readOBOGraphFile(file)
+	case ontologyFormats[formatOWL], ".ofn":
+		return readOWLFile(file)
+	}
+	return readOBOFile(file)
+}
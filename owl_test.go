@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testOWL = `Prefix(:=<http://purl.obolibrary.org/obo/dpo.owl#>)
+Prefix(obo:=<http://purl.obolibrary.org/obo/>)
+Prefix(oboInOwl:=<http://www.geneontology.org/formats/oboInOwl#>)
+# a comment line
+Ontology(<http://purl.obolibrary.org/obo/dpo.owl>
+<http://purl.obolibrary.org/obo/dpo/releases/2018-01-10/dpo.owl>
+Import(<http://purl.obolibrary.org/obo/ro.owl>)
+Annotation(oboInOwl:default-namespace "dicty_phenotypes"^^xsd:string)
+Annotation(owl:versionInfo "2018-01-10")
+
+Declaration(Class(obo:DDPHENO_0000001))
+Declaration(Class(obo:DDPHENO_0000002))
+Declaration(ObjectProperty(obo:RO_0002202))
+
+AnnotationAssertion(rdfs:label obo:DDPHENO_0000001 "aberrant \"spore\" morphology"@en)
+AnnotationAssertion(Annotation(oboInOwl:hasDbXref "PMID:123") obo:IAO_0000115 obo:DDPHENO_0000001 "Spores look odd.")
+AnnotationAssertion(oboInOwl:hasExactSynonym obo:DDPHENO_0000001 "odd spore")
+AnnotationAssertion(oboInOwl:inSubset obo:DDPHENO_0000001 <http://purl.obolibrary.org/obo/dpo#slim>)
+AnnotationAssertion(rdfs:seeAlso obo:DDPHENO_0000001 obo:DDPHENO_0000003)
+AnnotationAssertion(rdfs:label obo:DDPHENO_0000404 "undeclared")
+AnnotationAssertion(owl:deprecated obo:DDPHENO_0000002 "true"^^xsd:boolean)
+AnnotationAssertion(rdfs:label obo:RO_0002202 "develops from")
+AnnotationAssertion(oboInOwl:shorthand obo:RO_0002202 "develops_from")
+
+SubClassOf(obo:DDPHENO_0000001 obo:DDPHENO_0000002)
+SubClassOf(Annotation(rdfs:comment "axiom") obo:DDPHENO_0000001 ObjectSomeValuesFrom(obo:RO_0002202 obo:DDPHENO_0000002))
+SubClassOf(obo:DDPHENO_0000001 ObjectIntersectionOf(obo:DDPHENO_0000002 obo:DDPHENO_0000003))
+SubClassOf(obo:DDPHENO_0000404 obo:DDPHENO_0000002)
+)
+`
+
+func TestReadOWLFile(t *testing.T) {
+	file, cleanup := tempOntology(t, "dpo.owl", testOWL)
+	defer cleanup()
+	d, err := readOntologyFile(file)
+	if err != nil {
+		t.Fatalf("unable to read owl %s", err)
+	}
+	for tag, value := range map[string]string{
+		"ontology":          "dpo",
+		"data-version":      "dpo/releases/2018-01-10",
+		"default-namespace": "dicty_phenotypes",
+		"import":            "http://purl.obolibrary.org/obo/ro.owl",
+	} {
+		if v := d.Tag(tag); v != value {
+			t.Errorf("expected header %s %q, got %q", tag, value, v)
+		}
+	}
+	if len(d.Terms) != 2 || len(d.Typedefs) != 1 {
+		t.Fatalf("expected 2 terms and 1 typedef, got %d %d", len(d.Terms), len(d.Typedefs))
+	}
+	expected := &oboTerm{
+		ID:            "DDPHENO:0000001",
+		Name:          `aberrant "spore" morphology`,
+		Def:           "Spores look odd.",
+		DefXrefs:      []string{"PMID:123"},
+		IsA:           []string{"DDPHENO:0000002"},
+		Relationships: []*oboRelation{{Type: "develops_from", Target: "DDPHENO:0000002"}},
+		Synonyms:      []*oboSynonym{{Text: "odd spore", Scope: "EXACT"}},
+		Subsets:       []string{"slim"},
+		Properties:    []*oboProperty{{Name: "seeAlso", Value: "DDPHENO:0000003"}},
+	}
+	if !reflect.DeepEqual(d.Terms[0], expected) {
+		t.Fatalf("expected %+v, got %+v", expected, d.Terms[0])
+	}
+	if !d.Terms[1].Obsolete {
+		t.Fatal("expected a deprecated class to be obsolete")
+	}
+	td := d.Typedefs[0]
+	if td.ID != "develops_from" || td.Name != "develops from" {
+		t.Fatalf("expected the typedef named by its shorthand, got %+v", td)
+	}
+}
+
+func TestReadOWLMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ct   string
+		err  string
+	}{
+		{
+			name: "rdf xml",
+			ct:   `<?xml version="1.0"?><rdf:RDF></rdf:RDF>`,
+			err:  "is in RDF/XML, only OWL functional syntax is supported",
+		},
+		{
+			name: "no ontology",
+			ct:   "Prefix(obo:=<http://purl.obolibrary.org/obo/>)\n",
+			err:  "no ontology in",
+		},
+		{
+			name: "unclosed ontology",
+			ct:   "Ontology(<http://purl.obolibrary.org/obo/dpo.owl>\nDeclaration(Class(obo:X_1))\n",
+			err:  "unbalanced parenthesis",
+		},
+		{
+			name: "extra parenthesis",
+			ct:   "Ontology(<http://purl.obolibrary.org/obo/dpo.owl>))\n",
+			err:  "unbalanced parenthesis",
+		},
+		{
+			name: "bare parenthesis",
+			ct:   "(obo:X_1)\nOntology(<http://purl.obolibrary.org/obo/dpo.owl>)\n",
+			err:  "parenthesis without an expression name",
+		},
+		{
+			name: "unterminated iri",
+			ct:   "Ontology(<http://purl.obolibrary.org/obo/dpo.owl\n",
+			err:  "unterminated IRI",
+		},
+		{
+			name: "unterminated literal",
+			ct:   "Ontology(<http://purl.obolibrary.org/obo/dpo.owl>\nAnnotation(rdfs:comment \"open)\n",
+			err:  "unterminated literal",
+		},
+	} {
+		file, cleanup := tempOntology(t, "bad.owl", tc.ct)
+		_, err := readOWLFile(file)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error with %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestParseOWLExprs(t *testing.T) {
+	exprs, err := parseOWLExprs(strings.NewReader(`SubClassOf(obo:A ObjectSomeValuesFrom(<http://x/p> obo:B)) "lit"@en end`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*owlExpr{
+		{Name: "SubClassOf", Compound: true, Args: []*owlExpr{
+			{Name: "obo:A"},
+			{Name: "ObjectSomeValuesFrom", Compound: true, Args: []*owlExpr{
+				{Name: "<http://x/p>"},
+				{Name: "obo:B"},
+			}},
+		}},
+		{Name: "lit", Literal: true},
+		{Name: "end"},
+	}
+	if !reflect.DeepEqual(exprs, expected) {
+		t.Fatalf("unexpected expressions %+v", exprs)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// pipelineStep is a subcommand of this application that runs as part of the
+// full import. Every entry of Runs is a set of command line arguments
+// passed to the subcommand, a step without Runs is invoked once without any
+// argument. The subcommand is the name of the step unless Command is given.
+// Globals overrides the value of global flags for the step.
+type pipelineStep struct {
+	Name      string
+	Command   string
+	DependsOn []string
+	Runs      [][]string
+	Globals   map[string]string
+}
+
+func (s *pipelineStep) command() string {
+	if len(s.Command) > 0 {
+		return s.Command
+	}
+	return s.Name
+}
+
+type stepStatus int
+
+const (
+	stepPending stepStatus = iota
+	stepDone
+	stepFailed
+	stepSkipped
+	stepRunning
+)
+
+type stepResult struct {
+	name string
+	err  error
+}
+
+func (s stepStatus) String() string {
+	switch s {
+	case stepDone:
+		return "done"
+	case stepFailed:
+		return "failed"
+	case stepSkipped:
+		return "skipped"
+	case stepRunning:
+		return "running"
+	default:
+		return "pending"
+	}
+}
+
+// pipelineRegistry locates the ontologies of the onto step
+const pipelineRegistry = "manifests/ontology-registry.json"
+
+var pipelineSteps = []*pipelineStep{
+	{Name: "organism"},
+	{Name: "organism-plus", DependsOn: []string{"organism"}},
+	{
+		Name: "onto",
+		Runs: [][]string{
+			{"--registry", pipelineRegistry, "--purl", "--obo", "so"},
+			{"--registry", pipelineRegistry, "--github", "--obo", "ro-chado"},
+			{
+				"--registry", pipelineRegistry,
+				"--github",
+				"--obo", "dictyBase_literature_topic",
+				"--obo", "dicty_anatomy",
+				"--obo", "dicty_assay",
+				"--obo", "dicty_environment",
+				"--obo", "dicty_genetic_modification",
+				"--obo", "dicty_mutagenesis_method",
+				"--obo", "dicty_phenotypes",
+				"--obo", "dicty_plasmid_inventory",
+				"--obo", "dicty_plasmid_keywords",
+				"--obo", "dicty_storage_condition",
+				"--obo", "dicty_strain_characteristics",
+				"--obo", "dicty_strain_inventory",
+			},
+		},
+	},
+	{Name: "literature", DependsOn: []string{"onto"}},
+	{Name: "stock-center", DependsOn: []string{"organism-plus", "onto", "literature"}},
+	{Name: "users"},
+	{Name: "stock-center-orders", DependsOn: []string{"stock-center", "users"}},
+	{Name: "tag-inventory", DependsOn: []string{"stock-center"}},
+	{Name: "plasmid-prefix", DependsOn: []string{"stock-center"}},
+	{Name: "bacterial-strain", DependsOn: []string{"stock-center"}},
+	{Name: "annotation-assignments", DependsOn: []string{"stock-center", "users"}},
+}
+
+func validatePipeline(c *cli.Context) error {
+	if err := validateCommon(c); err != nil {
+		return err
+	}
+	if len(c.StringSlice("only")) > 0 && len(c.String("from")) > 0 {
+		return cli.NewExitError("only one of --only or --from could be given", 2)
+	}
+	if c.Int("max-parallel") < 1 {
+		return cli.NewExitError("max-parallel should be at least 1", 2)
+	}
+	return nil
+}
+
+func PipelineAction(c *cli.Context) error {
+	log, err := getLogger(c, "pipeline")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	return executeSteps(c, log, pipelineSteps)
+}
+
+// executeSteps runs the selected steps in the order of their dependencies,
+// up to --max-parallel steps without any dependency between them run at the
+// same time. A failed step does not stop the run, however all the steps
+// depending on it are skipped.
+func executeSteps(c *cli.Context, log *logrus.Logger, allSteps []*pipelineStep) error {
+	steps, err := sortSteps(allSteps)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "pipeline",
+			"kind": "dependency-graph",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	selected, err := selectSteps(c, steps)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	if c.GlobalBool("migration-lock") {
+		lock, err := lockMigration(c)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "lock",
+				"lock": migrationLock,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		defer lock.release()
+	}
+	maxParallel := c.Int("max-parallel")
+	if maxParallel > 1 {
+		pool := new(sharedPool)
+		c.App.Metadata["pool"] = pool
+		defer func() {
+			delete(c.App.Metadata, "pool")
+			pool.close()
+		}()
+	}
+	status := make(map[string]stepStatus)
+	results := make(chan stepResult)
+	running := 0
+	for {
+		for _, s := range steps {
+			if _, ok := status[s.Name]; ok || !selected[s.Name] {
+				continue
+			}
+			if isCancelled(c) {
+				status[s.Name] = stepSkipped
+				log.WithFields(logrus.Fields{
+					"type": "pipeline",
+					"step": s.Name,
+				}).Warn("skipping step as the pipeline is cancelled")
+				continue
+			}
+			if dep, ok := failedDependency(s, status); ok {
+				status[s.Name] = stepSkipped
+				log.WithFields(logrus.Fields{
+					"type":       "pipeline",
+					"step":       s.Name,
+					"dependency": dep,
+				}).Warn("skipping step as its dependency did not succeed")
+				continue
+			}
+			if !dependenciesDone(s, status, selected) || running >= maxParallel {
+				continue
+			}
+			status[s.Name] = stepRunning
+			running++
+			log.WithFields(logrus.Fields{
+				"type": "pipeline",
+				"step": s.Name,
+			}).Info("running step")
+			go func(s *pipelineStep) {
+				results <- stepResult{name: s.Name, err: runStep(c, s)}
+			}(s)
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			status[r.name] = stepFailed
+			log.WithFields(logrus.Fields{
+				"type": "pipeline",
+				"step": r.name,
+			}).Error(r.err)
+			continue
+		}
+		status[r.name] = stepDone
+		log.WithFields(logrus.Fields{
+			"type": "pipeline",
+			"step": r.name,
+		}).Info("step finished successfully")
+	}
+	var failed []string
+	for _, s := range steps {
+		st, ok := status[s.Name]
+		if !ok {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"type":   "pipeline",
+			"kind":   "summary",
+			"step":   s.Name,
+			"status": st.String(),
+		}).Info("step status")
+		if st != stepDone {
+			failed = append(failed, s.Name)
+		}
+	}
+	if len(failed) > 0 && isCancelled(c) {
+		return cli.NewExitError(
+			fmt.Sprintf("pipeline is cancelled, steps did not finish %s", strings.Join(failed, ",")),
+			exitCancelled,
+		)
+	}
+	if len(failed) > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("pipeline steps did not finish %s", strings.Join(failed, ",")),
+			2,
+		)
+	}
+	return nil
+}
+
+// sortSteps orders the steps so that every step comes after all of its
+// dependencies. Steps without any ordering constraint between them keep
+// their order of declaration.
+func sortSteps(steps []*pipelineStep) ([]*pipelineStep, error) {
+	byName := make(map[string]*pipelineStep)
+	indegree := make(map[string]int)
+	for _, s := range steps {
+		byName[s.Name] = s
+		indegree[s.Name] = 0
+	}
+	for _, s := range steps {
+		for _, d := range s.DependsOn {
+			if _, ok := byName[d]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step %s", s.Name, d)
+			}
+			indegree[s.Name]++
+		}
+	}
+	var sorted []*pipelineStep
+	done := make(map[string]bool)
+	for len(sorted) < len(steps) {
+		found := false
+		for _, s := range steps {
+			if done[s.Name] || indegree[s.Name] > 0 {
+				continue
+			}
+			done[s.Name] = true
+			sorted = append(sorted, s)
+			for _, o := range steps {
+				for _, d := range o.DependsOn {
+					if d == s.Name {
+						indegree[o.Name]--
+					}
+				}
+			}
+			found = true
+			break
+		}
+		if !found {
+			var cyclic []string
+			for _, s := range steps {
+				if !done[s.Name] {
+					cyclic = append(cyclic, s.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle among steps %s", strings.Join(cyclic, ","))
+		}
+	}
+	return sorted, nil
+}
+
+// selectSteps returns the name of steps that has to be run based on the
+// --only and --from flags, by default every step gets selected.
+func selectSteps(c *cli.Context, steps []*pipelineStep) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, s := range steps {
+		known[s.Name] = true
+	}
+	selected := make(map[string]bool)
+	switch {
+	case len(c.StringSlice("only")) > 0:
+		for _, n := range c.StringSlice("only") {
+			if !known[n] {
+				return selected, fmt.Errorf("unknown pipeline step %s", n)
+			}
+			selected[n] = true
+		}
+	case len(c.String("from")) > 0:
+		from := c.String("from")
+		if !known[from] {
+			return selected, fmt.Errorf("unknown pipeline step %s", from)
+		}
+		// steps are sorted, so every dependent comes after its dependency
+		selected[from] = true
+		for _, s := range steps {
+			for _, d := range s.DependsOn {
+				if selected[d] {
+					selected[s.Name] = true
+				}
+			}
+		}
+	default:
+		for n := range known {
+			selected[n] = true
+		}
+	}
+	return selected, nil
+}
+
+// failedDependency returns the first dependency of the step that was run
+// without success. Dependencies that are not part of the current run are
+// considered to be done.
+func failedDependency(s *pipelineStep, status map[string]stepStatus) (string, bool) {
+	for _, d := range s.DependsOn {
+		if st := status[d]; st == stepFailed || st == stepSkipped {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// dependenciesDone tells whether the step is ready to run, that is all of
+// its dependencies within the current run are finished successfully
+func dependenciesDone(s *pipelineStep, status map[string]stepStatus, selected map[string]bool) bool {
+	for _, d := range s.DependsOn {
+		if selected[d] && status[d] != stepDone {
+			return false
+		}
+	}
+	return true
+}
+
+// runStep runs every invocation of the step, the name of the step is set as
+// a global so that the logs of the step are labelled with it
+func runStep(c *cli.Context, s *pipelineStep) error {
+	globals := map[string]string{"pipeline-step": s.Name}
+	for k, v := range s.Globals {
+		globals[k] = v
+	}
+	pctx := overrideGlobals(c, globals)
+	if len(s.Runs) == 0 {
+		return runSubcommand(pctx, s.command(), []string{})
+	}
+	for _, args := range s.Runs {
+		if err := runSubcommand(pctx, s.command(), args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// overrideGlobals returns a context that shadows the given global flags,
+// the global flags are looked up from the nearest parent context.
+func overrideGlobals(c *cli.Context, values map[string]string) *cli.Context {
+	set := flag.NewFlagSet("globals", flag.ContinueOnError)
+	for k, v := range values {
+		set.String(k, v, "")
+	}
+	return cli.NewContext(c.App, set, c)
+}
+
+// runSubcommand runs the before and action handlers of the named subcommand
+// in the current process. It mimics the cli.Command.Run, however any error
+// is returned to the caller instead of exiting the application.
+func runSubcommand(c *cli.Context, name string, args []string) error {
+	cmd := c.App.Command(name)
+	if cmd == nil {
+		return fmt.Errorf("unknown subcommand %s", name)
+	}
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, f := range cmd.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		return fmt.Errorf("unable to parse arguments for %s %s", name, err)
+	}
+	ctx := cli.NewContext(c.App, set, c)
+	ctx.Command = *cmd
+	if cmd.Before != nil {
+		if err := cmd.Before(ctx); err != nil {
+			return err
+		}
+	}
+	return cli.HandleAction(cmd.Action, ctx)
+}
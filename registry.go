@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// defaultRegistry is used when no registry file is given, the ontologies
+// not listed in it are read from the ontologies folder of migration-data
+const defaultRegistry = `
+{
+  "github": {"owner": "dictyBase", "repo": "migration-data", "path": "ontologies"},
+  "purl": "http://purl.obolibrary.org/obo",
+  "ontologies": [
+    {"name": "cv_property", "source": "github", "format": "obo", "prefixes": ["cv_property"]},
+    {
+      "name": "ro-chado",
+      "source": "github",
+      "github": {"repo": "obo-relations", "path": "subsets/ro-chado.obo"},
+      "prefixes": ["RO", "OBO_REL"]
+    }
+  ]
+}
+	`
+
+// ontologyRegistry maps the names of the ontologies to their locations,
+// for example
+//
+//	{
+//	  "github": {"owner": "dictyBase", "repo": "migration-data", "path": "ontologies"},
+//	  "purl": "http://purl.obolibrary.org/obo",
+//	  "ontologies": [
+//	    {"name": "so", "source": "purl"},
+//	    {
+//	      "name": "dicty_phenotypes",
+//	      "cv": "dicty_phenotypes",
+//	      "prefixes": ["DDPHENO"],
+//	      "format": "obo",
+//	      "github": {"ref": "v1.2"},
+//	      "s3": "ontologies/dicty_phenotypes.obo",
+//	      "path": "/data/ontologies/dicty_phenotypes.obo"
+//	    }
+//	  ]
+//	}
+//
+// The top level github and purl are the defaults of the ontologies that do
+// not give their own location, ontologies that are not listed at all are
+// looked up through the defaults only.
+type ontologyRegistry struct {
+	Github     githubLocation      `json:"github"`
+	Purl       string              `json:"purl"`
+	Ontologies []*registryOntology `json:"ontologies"`
+}
+
+type githubLocation struct {
+	Owner string `json:"owner"`
+	Repo  string `json:"repo"`
+	Path  string `json:"path"`
+	Ref   string `json:"ref"`
+}
+
+// registryOntology is an ontology of the registry, the cv overrides the
+// default-namespace of the file and the prefixes are the ones of the terms
+// it provides to the others. The commit is the one the file was fetched
+// from github.
+type registryOntology struct {
+	Name     string          `json:"name"`
+	Cv       string          `json:"cv"`
+	Format   string          `json:"format"`
+	Source   string          `json:"source"`
+	Github   *githubLocation `json:"github"`
+	Purl     string          `json:"purl"`
+	S3       string          `json:"s3"`
+	Path     string          `json:"path"`
+	Prefixes []string        `json:"prefixes"`
+	Commit   string          `json:"-"`
+}
+
+// file is the name of the ontology file in its format
+func (o *registryOntology) file() string {
+	return o.Name + ontologyFormats[o.Format]
+}
+
+func readRegistry(file string) (*ontologyRegistry, error) {
+	ct := []byte(defaultRegistry)
+	if len(file) > 0 {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read ontology registry %s %s", file, err)
+		}
+		ct = b
+	}
+	r := new(ontologyRegistry)
+	if err := json.Unmarshal(ct, r); err != nil {
+		return nil, fmt.Errorf("unable to decode ontology registry %s %s", file, err)
+	}
+	seen := make(map[string]bool)
+	for _, o := range r.Ontologies {
+		if len(o.Name) == 0 {
+			return nil, fmt.Errorf("ontology without any name in registry %s", file)
+		}
+		if seen[o.Name] {
+			return nil, fmt.Errorf("duplicate ontology %s in registry %s", o.Name, file)
+		}
+		seen[o.Name] = true
+		if _, ok := ontologyFormats[o.Format]; len(o.Format) > 0 && !ok {
+			return nil, fmt.Errorf("unsupported format %s of %s in registry %s", o.Format, o.Name, file)
+		}
+		switch o.Source {
+		case "", sourcePurl, sourceGithub, sourceDir, sourceS3:
+		default:
+			return nil, fmt.Errorf("unknown source %s of %s in registry %s", o.Source, o.Name, file)
+		}
+	}
+	return r, nil
+}
+
+// lookup returns a copy of the ontology of the registry, the ones not
+// listed get an empty entry
+func (r *ontologyRegistry) lookup(name string) *registryOntology {
+	for _, o := range r.Ontologies {
+		if o.Name == name {
+			cp := *o
+			return &cp
+		}
+	}
+	return &registryOntology{Name: name}
+}
+
+// byPrefix returns the name of the ontology that provides the terms of the
+// prefix, by convention it is the prefix in lower case
+func (r *ontologyRegistry) byPrefix(prefix string) string {
+	for _, o := range r.Ontologies {
+		for _, p := range o.Prefixes {
+			if p == prefix {
+				return o.Name
+			}
+		}
+	}
+	return strings.ToLower(prefix)
+}
+
+// locate fills in the location of the ontology in its source from the
+// defaults of the registry and the flags
+func (r *ontologyRegistry) locate(c *cli.Context, o *registryOntology) error {
+	switch o.Source {
+	case sourceGithub:
+		gh := githubLocation{}
+		if o.Github != nil {
+			gh = *o.Github
+		}
+		if len(gh.Owner) == 0 {
+			gh.Owner = r.Github.Owner
+		}
+		if len(gh.Repo) == 0 {
+			gh.Repo = r.Github.Repo
+		}
+		refs, err := githubRefs(c)
+		if err != nil {
+			return err
+		}
+		if ref, ok := refs[o.Name]; ok {
+			gh.Ref = ref
+		}
+		if ref, ok := refs[""]; ok && len(gh.Ref) == 0 {
+			gh.Ref = ref
+		}
+		if len(gh.Ref) == 0 {
+			gh.Ref = r.Github.Ref
+		}
+		if len(gh.Path) == 0 {
+			gh.Path = path.Join(r.Github.Path, o.file())
+		}
+		if len(gh.Owner) == 0 || len(gh.Repo) == 0 {
+			return fmt.Errorf("no github repository for %s in the registry", o.Name)
+		}
+		o.Github = &gh
+	case sourcePurl:
+		if len(o.Purl) > 0 {
+			return nil
+		}
+		if len(r.Purl) == 0 {
+			return fmt.Errorf("no purl for %s in the registry", o.Name)
+		}
+		o.Purl = fmt.Sprintf("%s/%s", strings.TrimSuffix(r.Purl, "/"), o.file())
+	case sourceS3:
+		if len(o.S3) > 0 {
+			return nil
+		}
+		if len(c.String("s3")) == 0 {
+			return fmt.Errorf("s3 is needed for the source of %s", o.Name)
+		}
+		o.S3 = path.Join(c.String("s3"), o.file())
+	case sourceDir:
+		if len(o.Path) > 0 {
+			return nil
+		}
+		if len(c.String("obo-dir")) == 0 {
+			return fmt.Errorf("obo-dir is needed for the source of %s", o.Name)
+		}
+		o.Path = filepath.Join(c.String("obo-dir"), o.file())
+	}
+	return nil
+}
+
+// resolveOntologies looks up the ontologies in the registry and settles
+// their format, source and location
+func resolveOntologies(c *cli.Context, names []string) ([]*registryOntology, error) {
+	r, err := readRegistry(c.String("registry"))
+	if err != nil {
+		return nil, err
+	}
+	formats, err := ontologyFormatMap(c)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := ontologySources(c)
+	if err != nil {
+		return nil, err
+	}
+	var onts []*registryOntology
+	for _, n := range names {
+		o := r.lookup(n)
+		o.Format = ontologyFormat(formats, o)
+		if c.Bool("obo2chado") && o.Format != formatOBO {
+			return onts, fmt.Errorf("obo2chado can only load obo, %s is in %s", n, o.Format)
+		}
+		o.Source = ontologySource(sources, o)
+		if err := r.locate(c, o); err != nil {
+			return onts, err
+		}
+		onts = append(onts, o)
+	}
+	return onts, nil
+}
+
+// githubRefs reads the branches, tags or commits to fetch the ontologies
+// from github, given as name=ref or as a single ref for all of them
+func githubRefs(c *cli.Context) (map[string]string, error) {
+	refs := make(map[string]string)
+	for _, r := range c.StringSlice("gh-ref") {
+		name, ref := "", r
+		if idx := strings.Index(r, "="); idx >= 0 {
+			name, ref = r[:idx], r[idx+1:]
+		}
+		if len(ref) == 0 {
+			return refs, fmt.Errorf("empty ref in %s", r)
+		}
+		refs[name] = ref
+	}
+	return refs, nil
+}
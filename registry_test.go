@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestRegistryByPrefix(t *testing.T) {
+	for _, file := range []string{"", "manifests/ontology-registry.json"} {
+		r, err := readRegistry(file)
+		if err != nil {
+			t.Fatalf("unable to read registry %q %s", file, err)
+		}
+		claimed := make(map[string]string)
+		for _, o := range r.Ontologies {
+			if len(o.Prefixes) == 0 {
+				t.Errorf("%q: no prefixes for %s", file, o.Name)
+			}
+			for _, p := range o.Prefixes {
+				if name, ok := claimed[p]; ok {
+					t.Errorf("%q: prefix %s of %s is also claimed by %s", file, p, o.Name, name)
+				}
+				claimed[p] = o.Name
+				if name := r.byPrefix(p); name != o.Name {
+					t.Errorf("%q: expected prefix %s to give %s, got %s", file, p, o.Name, name)
+				}
+			}
+		}
+	}
+	r, err := readRegistry("manifests/ontology-registry.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for prefix, name := range map[string]string{
+		"SO":      "so",
+		"DDPHENO": "dicty_phenotypes",
+		"RO":      "ro-chado",
+		"GO":      "go",
+		"ECO":     "eco",
+	} {
+		if v := r.byPrefix(prefix); v != name {
+			t.Errorf("expected prefix %s to give %s, got %s", prefix, name, v)
+		}
+	}
+}
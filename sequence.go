@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgutz/dat.v1"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const sequenceFeatureSQL = `
+SELECT feature.feature_id,feature.seqlen,
+	(SELECT SUM(featureloc.fmax - featureloc.fmin) FROM featureloc
+		WHERE featureloc.feature_id = feature.feature_id
+		AND featureloc.locgroup = 0) AS span
+FROM feature
+JOIN cvterm ON cvterm.cvterm_id = feature.type_id
+WHERE feature.uniquename = $1
+	`
+
+// sequenceFeature is a feature matched to a FASTA record along with its
+// known extent, the span sums up the segments of every rank of its location
+type sequenceFeature struct {
+	ID     int64         `db:"feature_id"`
+	Seqlen dat.NullInt64 `db:"seqlen"`
+	Span   dat.NullInt64 `db:"span"`
+}
+
+func validateSequences(c *cli.Context) error {
+	if err := validateArgs(c); err != nil {
+		return err
+	}
+	if len(c.String("folder")) == 0 && len(c.String("remote-path")) == 0 {
+		return cli.NewExitError("one of folder or remote-path is needed", 2)
+	}
+	if len(c.String("remote-path")) > 0 {
+		if err := validateS3Args(c); err != nil {
+			return err
+		}
+	}
+	if len(c.String("id-pattern")) > 0 {
+		re, err := regexp.Compile(c.String("id-pattern"))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("invalid id-pattern %s", err), 2)
+		}
+		if re.NumSubexp() != 1 {
+			return cli.NewExitError("id-pattern should have a single capturing group", 2)
+		}
+	}
+	switch c.String("molecule") {
+	case "dna", "protein":
+	default:
+		return cli.NewExitError("molecule should be either of dna or protein", 2)
+	}
+	if c.Int("batch-size") < 1 {
+		return cli.NewExitError("batch-size should be at least 1", 2)
+	}
+	return nil
+}
+
+func SequencesAction(c *cli.Context) error {
+	dat.EnableInterpolation = true
+	log, err := getLogger(c, "sequences")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	files, err := sequenceFiles(c, log)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		log.WithFields(logrus.Fields{
+			"type": "sequence-loader",
+		}).Warn("no fasta file to load")
+		return nil
+	}
+	dbh, err := getPgWrapper(c)
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("unable to create database connection %s", err),
+			2,
+		)
+	}
+	batch, err := newBatchTx(c, dbh, log, c.Int("batch-size"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	defer batch.rollback()
+	sl := &sequenceLoader{
+		c:     c,
+		batch: batch,
+		log:   log,
+	}
+	if len(c.String("id-pattern")) > 0 {
+		sl.pattern = regexp.MustCompile(c.String("id-pattern"))
+	}
+	for _, f := range files {
+		if err := sl.loadFile(f); err != nil {
+			log.WithFields(logrus.Fields{
+				"type": "sequence-loader",
+				"file": f,
+			}).Error(err)
+			if err == errCancelled {
+				return err
+			}
+			return cli.NewExitError(err.Error(), 2)
+		}
+	}
+	if err := batch.commit(true); err != nil {
+		if err == errCancelled {
+			return err
+		}
+		return cli.NewExitError(err.Error(), 2)
+	}
+	log.WithFields(logrus.Fields{
+		"type":       "sequence-loader",
+		"kind":       "summary",
+		"updated":    sl.updated,
+		"unmatched":  sl.unmatched,
+		"ambiguous":  sl.ambiguous,
+		"mismatched": sl.mismatched,
+	}).Info("loaded sequences")
+	return nil
+}
+
+// sequenceFiles returns the local FASTA files, either from the folder or
+// downloaded from the bucket. A remote tarball is unpacked in a temp folder.
+func sequenceFiles(c *cli.Context, log *logrus.Logger) ([]string, error) {
+	rp := c.String("remote-path")
+	if len(rp) == 0 {
+		files, err := listFastaFiles(c.String("folder"))
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type":   "dir-lookup",
+				"folder": c.String("folder"),
+			}).Error(err)
+			return files, cli.NewExitError(err.Error(), 2)
+		}
+		return files, nil
+	}
+	if strings.HasSuffix(rp, ".tar.gz") || strings.HasSuffix(rp, ".tgz") {
+		dir, err := fetchAndDecompress(c, log, "sequences")
+		if err != nil {
+			return nil, err
+		}
+		files, err := listFastaFiles(dir)
+		if err != nil {
+			return files, cli.NewExitError(err.Error(), 2)
+		}
+		return files, nil
+	}
+	file, err := fetchRemoteFile(c, "sequences")
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "remote-get",
+			"name": "input",
+		}).Error(err)
+		return nil, cli.NewExitError(fmt.Sprintf("unable to fetch remote file %s ", err), 2)
+	}
+	return []string{file}, nil
+}
+
+func listFastaFiles(folder string) ([]string, error) {
+	all, err := listFiles(folder)
+	if err != nil {
+		return all, err
+	}
+	var files []string
+	for _, f := range all {
+		name := strings.TrimSuffix(f, ".gz")
+		switch filepath.Ext(name) {
+		case ".fa", ".fasta", ".fna", ".faa", ".fas":
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+type sequenceLoader struct {
+	c          *cli.Context
+	batch      *batchTx
+	log        *logrus.Logger
+	pattern    *regexp.Regexp
+	updated    int64
+	unmatched  int64
+	ambiguous  int64
+	mismatched int64
+}
+
+// recordId is the uniquename of the feature for the record, it is the
+// first word of the header unless an id pattern is given
+func (sl *sequenceLoader) recordId(rec *fastaRecord) (string, bool) {
+	if sl.pattern == nil {
+		return rec.ID, len(rec.ID) > 0
+	}
+	m := sl.pattern.FindStringSubmatch(rec.Header())
+	if m == nil || len(m[1]) == 0 {
+		return "", false
+	}
+	return m[1], true
+}
+
+func (sl *sequenceLoader) loadFile(file string) error {
+	r, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fr, err := newFastaReader(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s %s", file, err)
+	}
+	for {
+		rec, err := fr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error in reading %s %s", file, err)
+		}
+		if isCancelled(sl.c) {
+			return errCancelled
+		}
+		if err := sl.loadRecord(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (sl *sequenceLoader) loadRecord(rec *fastaRecord) error {
+	id, ok := sl.recordId(rec)
+	if !ok {
+		sl.unmatched++
+		sl.log.WithFields(logrus.Fields{
+			"type":   "sequence-loader",
+			"kind":   "unmatched",
+			"header": rec.Header(),
+		}).Warn("no id in the header of record")
+		return nil
+	}
+	query := sequenceFeatureSQL
+	args := []interface{}{id}
+	if t := sl.c.String("feature-type"); len(t) > 0 {
+		query += " AND cvterm.name = $2"
+		args = append(args, t)
+	}
+	var features []*sequenceFeature
+	err := sl.batch.tx.SQL(query, args...).QueryStructs(&features)
+	if err != nil && err != dat.ErrNotFound {
+		return fmt.Errorf("error in looking up feature %s %s", id, err)
+	}
+	switch len(features) {
+	case 0:
+		sl.unmatched++
+		sl.log.WithFields(logrus.Fields{
+			"type":       "sequence-loader",
+			"kind":       "unmatched",
+			"uniquename": id,
+		}).Warn("no feature for the record")
+		return nil
+	case 1:
+	default:
+		sl.ambiguous++
+		sl.log.WithFields(logrus.Fields{
+			"type":       "sequence-loader",
+			"kind":       "ambiguous",
+			"uniquename": id,
+			"features":   len(features),
+		}).Warn("more than one feature for the record, use --feature-type to pick one")
+		return nil
+	}
+	f := features[0]
+	seqlen := int64(len(rec.Residues))
+	sl.checkExtent(id, f, seqlen)
+	sum := md5.Sum(rec.Residues)
+	checksum := hex.EncodeToString(sum[:])
+	_, err = sl.batch.tx.Update("feature").
+		SetMap(map[string]interface{}{
+			"residues":    string(rec.Residues),
+			"seqlen":      seqlen,
+			"md5checksum": checksum,
+		}).
+		Where("feature_id = $1", f.ID).
+		Exec()
+	if err != nil {
+		return fmt.Errorf("error in updating sequence of %s %s", id, err)
+	}
+	sl.updated++
+	sl.batch.add("feature", changeUpdate, 1)
+	sl.batch.report.sample("feature", changeUpdate, map[string]interface{}{
+		"feature_id":  f.ID,
+		"uniquename":  id,
+		"seqlen":      seqlen,
+		"md5checksum": checksum,
+	})
+	return sl.batch.done()
+}
+
+// checkExtent reports a DNA sequence whose length differs from the summed
+// segments of the location of the feature, or from the length of the landmark given in GFF3
+func (sl *sequenceLoader) checkExtent(id string, f *sequenceFeature, seqlen int64) {
+	if sl.c.String("molecule") != "dna" {
+		return
+	}
+	var expected int64
+	switch {
+	case f.Span.Valid:
+		expected = f.Span.Int64
+	case f.Seqlen.Valid:
+		expected = f.Seqlen.Int64
+	default:
+		return
+	}
+	if expected == seqlen {
+		return
+	}
+	sl.mismatched++
+	sl.log.WithFields(logrus.Fields{
+		"type":       "sequence-loader",
+		"kind":       "extent-mismatch",
+		"uniquename": id,
+		"expected":   expected,
+		"length":     seqlen,
+	}).Warn("sequence length differs from the extent of feature")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// exitCancelled is the exit code of a command interrupted by a signal,
+// it follows the shell convention for SIGTERM
+const exitCancelled = 143
+
+var errCancelled = errors.New("cancelled by signal")
+
+// handleSignals keeps a context in the metadata of the application that
+// gets cancelled on SIGTERM or SIGINT
+func handleSignals(app *cli.App) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sch := make(chan os.Signal, 1)
+	signal.Notify(sch, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		s := <-sch
+		// cli leaves the writer of the application unset by default
+		w := app.ErrWriter
+		if w == nil {
+			w = os.Stderr
+		}
+		fmt.Fprintf(w, "received signal %s, cancelling\n", s)
+		cancel()
+	}()
+	if app.Metadata == nil {
+		app.Metadata = make(map[string]interface{})
+	}
+	app.Metadata["context"] = ctx
+}
+
+// getContext returns the context of the application, it is never cancelled
+// when no signal handler is installed
+func getContext(c *cli.Context) context.Context {
+	if ctx, ok := c.App.Metadata["context"].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+func isCancelled(c *cli.Context) bool {
+	return getContext(c).Err() != nil
+}
+
+// sleepContext pauses for the duration, it returns false if the context
+// gets cancelled in between
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// cancellable wraps an action to log the cancellation and exit with a
+// distinct code when it is interrupted by a signal
+func cancellable(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		aerr := action(c)
+		if !isCancelled(c) {
+			return aerr
+		}
+		log, err := getLogger(c, "signal")
+		if err == nil {
+			log.WithFields(logrus.Fields{
+				"type":    "signal",
+				"kind":    "cancelled",
+				"command": c.Command.Name,
+			}).Error(aerr)
+		}
+		return cli.NewExitError(
+			fmt.Sprintf("%s is cancelled %v", c.Command.Name, aerr),
+			exitCancelled,
+		)
+	}
+}
+
+// runCommand runs an external command and returns its combined output.
+// Once the context of the application is cancelled the command gets a
+// SIGTERM, followed by a SIGKILL if it is still around after the grace
+// period.
+func runCommand(c *cli.Context, name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return out.Bytes(), err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	ctx := getContext(c)
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(c.GlobalDuration("grace-period")):
+		cmd.Process.Kill()
+		<-done
+	}
+	return out.Bytes(), errCancelled
+}
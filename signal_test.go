@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// the test binary runs as the external command when this is set to the
+// behaviour of the child, either trap or ignore
+const childEnv = "IMPORT_SIGNAL_CHILD"
+
+// file where the child records its progress
+const childFileEnv = "IMPORT_SIGNAL_FILE"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(childEnv); len(mode) > 0 {
+		signalChild(mode, os.Getenv(childFileEnv))
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// signalChild sleeps until it gets a SIGTERM, then it either exits or keeps
+// on sleeping as a loader that does not stop in time
+func signalChild(mode, file string) {
+	sch := make(chan os.Signal, 1)
+	signal.Notify(sch, syscall.SIGTERM)
+	appendChild(file, "ready")
+	for range sch {
+		appendChild(file, "term")
+		if mode == "trap" {
+			os.Exit(0)
+		}
+	}
+}
+
+func appendChild(file, line string) {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Exit(3)
+	}
+	f.WriteString(line + "\n")
+	f.Close()
+}
+
+// childLines waits for the child to record the line and returns all of the
+// recorded ones
+func childLines(t *testing.T, file, line string) []string {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		ct, _ := ioutil.ReadFile(file)
+		lines := strings.Fields(string(ct))
+		for _, l := range lines {
+			if l == line {
+				return lines
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("child did not record %s in time", line)
+	return nil
+}
+
+// signalContext returns the context of a subcommand with the grace period
+// and a cancellable context of the application
+func signalContext(t *testing.T, grace time.Duration) (*cli.Context, context.CancelFunc) {
+	app := cli.NewApp()
+	ctx, cancel := context.WithCancel(context.Background())
+	app.Metadata = map[string]interface{}{"context": ctx}
+	gset := flag.NewFlagSet("import", flag.ContinueOnError)
+	for _, f := range []cli.Flag{
+		cli.StringFlag{Name: "log-level", Value: "fatal"},
+		cli.DurationFlag{Name: "grace-period", Value: grace},
+	} {
+		f.Apply(gset)
+	}
+	if err := gset.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(app, flag.NewFlagSet("literature", flag.ContinueOnError), cli.NewContext(app, gset, nil))
+	c.Command = cli.Command{Name: "literature"}
+	return c, cancel
+}
+
+// startChild runs the test binary as an external command through
+// runCommand, the returned channel gets its error
+func startChild(t *testing.T, c *cli.Context, mode string) (string, <-chan error) {
+	dir, err := ioutil.TempDir("", "signal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "child")
+	os.Setenv(childEnv, mode)
+	os.Setenv(childFileEnv, file)
+	defer os.Unsetenv(childEnv)
+	defer os.Unsetenv(childFileEnv)
+	ech := make(chan error, 1)
+	action := cancellable(func(c *cli.Context) error {
+		_, err := runCommand(c, os.Args[0], "-test.run=^$")
+		return err
+	})
+	go func() {
+		ech <- action(c)
+	}()
+	childLines(t, file, "ready")
+	return file, ech
+}
+
+func TestHandleSignals(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  *bytes.Buffer
+	}{
+		// the default of cli, where the application has no writer
+		{name: "without writer"},
+		{name: "with writer", out: new(bytes.Buffer)},
+	} {
+		app := cli.NewApp()
+		if tc.out != nil {
+			app.ErrWriter = tc.out
+		}
+		handleSignals(app)
+		ctx, ok := app.Metadata["context"].(context.Context)
+		if !ok {
+			t.Fatalf("%s: expected a context in the metadata", tc.name)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: context is not cancelled after SIGTERM", tc.name)
+		}
+		if tc.out != nil && !strings.Contains(tc.out.String(), "received signal terminated") {
+			t.Fatalf("%s: expected the signal to be reported, got %q", tc.name, tc.out.String())
+		}
+	}
+}
+
+func TestRunCommandTerm(t *testing.T) {
+	c, cancel := signalContext(t, 10*time.Second)
+	defer cancel()
+	file, ech := startChild(t, c, "trap")
+	defer os.RemoveAll(filepath.Dir(file))
+	start := time.Now()
+	cancel()
+	var err error
+	select {
+	case err = <-ech:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command is still running after its SIGTERM")
+	}
+	childLines(t, file, "term")
+	if time.Since(start) >= 10*time.Second {
+		t.Fatal("expected the command to stop before the grace period")
+	}
+	ec, ok := err.(cli.ExitCoder)
+	if !ok {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if ec.ExitCode() != exitCancelled {
+		t.Fatalf("expected exit code %d, got %d", exitCancelled, ec.ExitCode())
+	}
+}
+
+func TestRunCommandKill(t *testing.T) {
+	grace := 300 * time.Millisecond
+	c, cancel := signalContext(t, grace)
+	defer cancel()
+	file, ech := startChild(t, c, "ignore")
+	defer os.RemoveAll(filepath.Dir(file))
+	start := time.Now()
+	cancel()
+	var err error
+	select {
+	case err = <-ech:
+	case <-time.After(10 * time.Second):
+		t.Fatal("command is still running after the grace period")
+	}
+	if elapsed := time.Since(start); elapsed < grace {
+		t.Fatalf("expected the command to be killed after %s, it took %s", grace, elapsed)
+	}
+	lines := childLines(t, file, "term")
+	if lines[len(lines)-1] != "term" {
+		t.Fatalf("expected the child to stop at its SIGTERM, got %v", lines)
+	}
+	ec, ok := err.(cli.ExitCoder)
+	if !ok {
+		t.Fatalf("expected an exit error, got %v", err)
+	}
+	if ec.ExitCode() != exitCancelled {
+		t.Fatalf("expected exit code %d, got %d", exitCancelled, ec.ExitCode())
+	}
+}
+
+func TestRunCommandDone(t *testing.T) {
+	c, cancel := signalContext(t, time.Second)
+	defer cancel()
+	out, err := runCommand(c, "sh", "-c", "echo loaded")
+	if err != nil {
+		t.Fatalf("unable to run command %s", err)
+	}
+	if strings.TrimSpace(string(out)) != "loaded" {
+		t.Fatalf("expected the output of the command, got %q", string(out))
+	}
+}
@@ -35,7 +35,7 @@ var plasmidData []string = []string{
 	"inventory",
 }
 
-type cmdFunc func(*cli.Context, string, string, *logrus.Logger) error
+type cmdFunc func(*cli.Context, string, string, *checkpoint, *logrus.Logger) error
 
 var inventorySQL = `
 	SELECT DISTINCT stock.stock_id
@@ -192,15 +192,18 @@ func TagInventoryAction(c *cli.Context) error {
 		return nil
 	}
 
+	report := newChangeReport(c)
 	insertBuilder := tx.InsertInto("stockprop").
 		Columns("type_id", "value", "stock_id")
 	expected := 0
 	for _, id := range stockIds {
-		insertBuilder.Record(&StockInventoryTag{
+		tag := &StockInventoryTag{
 			StockID: id,
 			TypeID:  cvtermId,
 			Value:   "1",
-		})
+		}
+		insertBuilder.Record(tag)
+		report.sample("stockprop", changeInsert, tag)
 		expected++
 	}
 	res, err := insertBuilder.Exec()
@@ -213,13 +216,15 @@ func TagInventoryAction(c *cli.Context) error {
 			2,
 		)
 	}
-	err = tx.Commit()
+	report.add("stockprop", changeInsert, res.RowsAffected)
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("error in commiting %s", err),
 			2,
 		)
 	}
+	addRowCount(c, "stockprop", res.RowsAffected)
 	log.WithFields(logrus.Fields{
 		"type":     "bulk insert",
 		"expected": expected,
@@ -341,6 +346,7 @@ func PrefixPlasmidAction(c *cli.Context) error {
 		log.Info("all plasmids are prefixed with p")
 		return nil
 	}
+	report := newChangeReport(c)
 	count := 0
 	for _, p := range plasmids {
 		res, err := tx.Update("stock").
@@ -355,8 +361,14 @@ func PrefixPlasmidAction(c *cli.Context) error {
 			)
 		}
 		count = count + int(res.RowsAffected)
+		report.sample("stock", changeUpdate, map[string]interface{}{
+			"stock_id": p.StockID,
+			"old_name": p.Name,
+			"name":     "p" + p.Name,
+		})
 	}
-	err = tx.Commit()
+	report.add("stock", changeUpdate, int64(count))
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		log.Errorf("error in commiting %s", err)
 		return cli.NewExitError(
@@ -364,6 +376,7 @@ func PrefixPlasmidAction(c *cli.Context) error {
 			2,
 		)
 	}
+	addRowCount(c, "stock", int64(count))
 	log.Infof("updated %d records of %d plasmids", len(plasmids), count)
 	return nil
 }
@@ -430,6 +443,7 @@ func BacterialStrainAction(c *cli.Context) error {
 		log.Info("no strains with bacterial food source characteristics")
 		return nil
 	}
+	report := newChangeReport(c)
 	count := 0
 	for _, id := range ids {
 		res, err := tx.Update("stock").
@@ -444,8 +458,13 @@ func BacterialStrainAction(c *cli.Context) error {
 			)
 		}
 		count = count + int(res.RowsAffected)
+		report.sample("stock", changeUpdate, map[string]interface{}{
+			"stock_id": id,
+			"type_id":  cvtermId,
+		})
 	}
-	err = tx.Commit()
+	report.add("stock", changeUpdate, int64(count))
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		log.Errorf("error in commiting %s", err)
 		return cli.NewExitError(
@@ -453,15 +472,24 @@ func BacterialStrainAction(c *cli.Context) error {
 			2,
 		)
 	}
+	addRowCount(c, "stock", int64(count))
 	log.Infof("expected:%d records loaded:%d bacterial_strain", len(ids), count)
 	return nil
 }
 
+func validateSc(c *cli.Context) error {
+	if err := validateNoDryRun(c, "stock-center"); err != nil {
+		return err
+	}
+	return validateCommon(c)
+}
+
 func ScAction(c *cli.Context) error {
 	log, err := getLogger(c, "dsc")
 	if err != nil {
 		return cli.NewExitError(err.Error(), 2)
 	}
+	unknownRowCounts(c)
 	mi, err := exec.LookPath("modware-import")
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -502,18 +530,60 @@ func ScAction(c *cli.Context) error {
 	}
 	log.Debugf("untar file %s in %s temp folder", filename, tmpDir)
 
+	checksum, err := fileChecksum(filename)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"type": "checksum",
+			"name": "input",
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
+	var cp *checkpoint
+	if c.Bool("resume") {
+		var resumed bool
+		cp, resumed, err = resumeCheckpoint(c.String("checkpoint-dir"), "stock-center", c.String("remote-path"), checksum)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type":     "checkpoint",
+				"checksum": checksum,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+		if resumed {
+			log.WithFields(logrus.Fields{
+				"type":      "checkpoint",
+				"checksum":  checksum,
+				"completed": strings.Join(cp.Completed, ","),
+			}).Info("resuming from checkpoint")
+		}
+	} else {
+		cp, err = newCheckpoint(c.String("checkpoint-dir"), "stock-center", c.String("remote-path"), checksum)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"type":     "checkpoint",
+				"checksum": checksum,
+			}).Error(err)
+			return cli.NewExitError(err.Error(), 2)
+		}
+	}
+
 	allfuncs := []cmdFunc{runStrainImport, runPlasmidImport, runStrainPlasmidImport}
 	for _, cf := range allfuncs {
-		if err := cf(c, tmpDir, mi, log); err != nil {
+		if err := cf(c, tmpDir, mi, cp, log); err != nil {
 			return cli.NewExitError(err.Error(), 2)
 		}
 	}
 	return nil
 }
 
-func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.Logger) error {
+func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, cp *checkpoint, log *logrus.Logger) error {
 	cmd := makeStrainImportCmd(c, tmpDir)
 	for i, data := range strainData {
+		substep := "strain:" + data
+		if cp.isDone(substep) {
+			log.Infof("skipping completed substep %s", substep)
+			continue
+		}
 		rcmd := make([]string, len(cmd))
 		copy(rcmd, cmd)
 		rcmd = append(rcmd, data)
@@ -532,7 +602,7 @@ func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.
 			log.Debugf("logfile %s for data %s", logf, data)
 			rcmd = append(rcmd, "--logfile", logf, "--log_level", "info")
 		}
-		out, err := exec.Command(mainCmd, rcmd...).CombinedOutput()
+		out, err := runCommand(c, mainCmd, rcmd...)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"type":        "modware-import",
@@ -544,6 +614,13 @@ func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.
 			return cli.NewExitError(err.Error(), 2)
 		}
 		log.Infof("successfully ran command %s", strings.Join(rcmd, " "))
+		if err := completeSubstep(cp, substep, log); err != nil {
+			return err
+		}
+	}
+	if cp.isDone("strain:phenotype") {
+		log.Infof("skipping completed substep %s", "strain:phenotype")
+		return nil
 	}
 	pcmd := make([]string, len(cmd))
 	copy(pcmd, cmd)
@@ -560,7 +637,7 @@ func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.
 		log.Debugf("logfile %s for data phenotype", logf)
 		pcmd = append(pcmd, "--logfile", logf, "--log_level", "info")
 	}
-	out, err := exec.Command(mainCmd, pcmd...).CombinedOutput()
+	out, err := runCommand(c, mainCmd, pcmd...)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"type":        "modware-import",
@@ -572,15 +649,19 @@ func runStrainImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.
 		return cli.NewExitError(err.Error(), 2)
 	}
 	log.Infof("successfully ran command %s", strings.Join(pcmd, " "))
-	return nil
+	return completeSubstep(cp, "strain:phenotype", log)
 }
 
-func runStrainPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.Logger) error {
+func runStrainPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, cp *checkpoint, log *logrus.Logger) error {
+	if cp.isDone("strain-plasmid:plasmid") {
+		log.Infof("skipping completed substep %s", "strain-plasmid:plasmid")
+		return nil
+	}
 	cmd := makeStrainImportCmd(c, tmpDir)
 	spcmd := make([]string, len(cmd))
 	copy(spcmd, cmd)
 	spcmd = append(spcmd, "plasmid")
-	out, err := exec.Command(mainCmd, spcmd...).CombinedOutput()
+	out, err := runCommand(c, mainCmd, spcmd...)
 	if c.GlobalBool("use-logfile") {
 		logf, err := getLogFileName(c, "strain-plasmid")
 		if err != nil {
@@ -604,12 +685,17 @@ func runStrainPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *
 		return cli.NewExitError(err.Error(), 2)
 	}
 	log.Infof("successfully ran command %s", strings.Join(spcmd, " "))
-	return nil
+	return completeSubstep(cp, "strain-plasmid:plasmid", log)
 }
 
-func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus.Logger) error {
+func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, cp *checkpoint, log *logrus.Logger) error {
 	cmd := makePlasmidImportCmd(c, tmpDir)
 	for i, data := range plasmidData {
+		substep := "plasmid:" + data
+		if cp.isDone(substep) {
+			log.Infof("skipping completed substep %s", substep)
+			continue
+		}
 		rcmd := make([]string, len(cmd))
 		copy(rcmd, cmd)
 		rcmd = append(rcmd, data)
@@ -628,7 +714,7 @@ func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus
 			log.Debugf("logfile %s for data %s", logf, data)
 			rcmd = append(rcmd, "--logfile", logf, "--log_level", "info")
 		}
-		out, err := exec.Command(mainCmd, rcmd...).CombinedOutput()
+		out, err := runCommand(c, mainCmd, rcmd...)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"type":        "modware-import",
@@ -640,6 +726,13 @@ func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus
 			return cli.NewExitError(err.Error(), 2)
 		}
 		log.Infof("successfully ran command %s", strings.Join(rcmd, " "))
+		if err := completeSubstep(cp, substep, log); err != nil {
+			return err
+		}
+	}
+	if cp.isDone("plasmid:sequence") {
+		log.Infof("skipping completed substep %s", "plasmid:sequence")
+		return nil
 	}
 	scmd := make([]string, len(cmd))
 	copy(scmd, cmd)
@@ -656,7 +749,7 @@ func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus
 		log.Debugf("logfile %s for data %s", logf, "sequence")
 		scmd = append(scmd, "--logfile", logf, "--log_level", "info")
 	}
-	out, err := exec.Command(mainCmd, scmd...).CombinedOutput()
+	out, err := runCommand(c, mainCmd, scmd...)
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"type":        "modware-import",
@@ -668,6 +761,17 @@ func runPlasmidImport(c *cli.Context, tmpDir string, mainCmd string, log *logrus
 		return cli.NewExitError(err.Error(), 2)
 	}
 	log.Infof("successfully ran command %s", strings.Join(scmd, " "))
+	return completeSubstep(cp, "plasmid:sequence", log)
+}
+
+func completeSubstep(cp *checkpoint, substep string, log *logrus.Logger) error {
+	if err := cp.markDone(substep); err != nil {
+		log.WithFields(logrus.Fields{
+			"type":    "checkpoint",
+			"substep": substep,
+		}).Error(err)
+		return cli.NewExitError(err.Error(), 2)
+	}
 	return nil
 }
 
@@ -794,6 +898,7 @@ func LoadAnnotationAssignment(c *cli.Context) error {
 		)
 	}
 	defer tx.AutoRollback()
+	report := newChangeReport(c)
 	for _, user := range annotators {
 		var id int64
 		err = tx.SQL(
@@ -815,7 +920,9 @@ func LoadAnnotationAssignment(c *cli.Context) error {
 			)
 		}
 		user.ID = id
+		report.sample("auth_user", changeUpsert, user)
 	}
+	report.add("auth_user", changeUpsert, int64(len(annotators)))
 	insertBuilder := tx.InsertInto("stock_user_annotation").
 		Columns("stock_id",
 			"created_user_id",
@@ -895,13 +1002,15 @@ func LoadAnnotationAssignment(c *cli.Context) error {
 			).QueryScalar(&annoId)
 		if err != nil {
 			if err == dat.ErrNotFound {
-				insertBuilder.Record(&StockUserAnnotation{
+				anno := &StockUserAnnotation{
 					StockID:    stockId,
 					CreatedBy:  annotators[name].ID,
 					ModifiedBy: annotators[name].ID,
 					CreatedAt:  createdOn,
 					ModifiedAt: modifiedOn,
-				})
+				}
+				insertBuilder.Record(anno)
+				report.sample("stock_user_annotation", changeInsert, anno)
 				counter++
 				continue
 			}
@@ -919,6 +1028,12 @@ func LoadAnnotationAssignment(c *cli.Context) error {
 	}
 	if counter == 0 { // no new record
 		log.Info("no new record to load")
+		if err := commitTx(c, tx, report, log); err != nil {
+			return cli.NewExitError(
+				fmt.Sprintf("error in commiting %s", err),
+				2,
+			)
+		}
 		return nil
 	}
 
@@ -933,13 +1048,15 @@ func LoadAnnotationAssignment(c *cli.Context) error {
 			2,
 		)
 	}
-	err = tx.Commit()
+	report.add("stock_user_annotation", changeInsert, res.RowsAffected)
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("error in commiting %s", err),
 			2,
 		)
 	}
+	addRowCount(c, "stock_user_annotation", res.RowsAffected)
 	log.WithFields(logrus.Fields{
 		"type":      "bulk insert",
 		"processed": counter,
@@ -981,6 +1098,23 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 		)
 	}
 	defer tx.AutoRollback()
+	report := newChangeReport(c)
+	if report.dryRun {
+		var orders []*StockOrder
+		err := tx.Select("stock_order_id", "user_id", "created_at").
+			From("stock_order").
+			Limit(sampleSize).
+			QueryStructs(&orders)
+		if err != nil && err != dat.ErrNotFound {
+			return cli.NewExitError(
+				fmt.Sprintf("error in querying stock orders %s", err),
+				2,
+			)
+		}
+		for _, o := range orders {
+			report.sample("stock_order", changeDelete, o)
+		}
+	}
 	// delete all orders
 	resD, err := tx.DeleteFrom("stock_order").Exec()
 	if err != nil {
@@ -994,6 +1128,7 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 		)
 	}
 	log.Infof("deleted %d records", resD.RowsAffected)
+	report.add("stock_order", changeDelete, resD.RowsAffected)
 	sItemOrderIbuilder := tx.InsertInto("stock_item_order").Columns("item_id", "order_id")
 	orderCounter := 0
 	for {
@@ -1056,6 +1191,7 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 			)
 		}
 		orderCounter += 1
+		report.sample("stock_order", changeInsert, stockOrder)
 		for _, item := range record[2:] {
 			var stockId int64
 			if strings.HasPrefix(item, "DBS") { // strain
@@ -1077,10 +1213,12 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 						2,
 					)
 				}
-				sItemOrderIbuilder.Record(&StockItemOrder{
+				item := &StockItemOrder{
 					ItemID:  stockId,
 					OrderID: stockOrder.ID,
-				})
+				}
+				sItemOrderIbuilder.Record(item)
+				report.sample("stock_item_order", changeInsert, item)
 			} else { // plasmid
 				err = tx.Select("stock_id").From("stock").
 					Where("name = $1", item).
@@ -1100,10 +1238,12 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 						2,
 					)
 				}
-				sItemOrderIbuilder.Record(&StockItemOrder{
+				item := &StockItemOrder{
 					ItemID:  stockId,
 					OrderID: stockOrder.ID,
-				})
+				}
+				sItemOrderIbuilder.Record(item)
+				report.sample("stock_item_order", changeInsert, item)
 			}
 		}
 	}
@@ -1117,13 +1257,17 @@ func loadOrders(c *cli.Context, tmpDir string, log *logrus.Logger) error {
 			2,
 		)
 	}
-	err = tx.Commit()
+	report.add("stock_order", changeInsert, int64(orderCounter))
+	report.add("stock_item_order", changeInsert, sIRes.RowsAffected)
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("error in commiting %s", err),
 			2,
 		)
 	}
+	addRowCount(c, "stock_order", int64(orderCounter))
+	addRowCount(c, "stock_item_order", sIRes.RowsAffected)
 	log.WithFields(logrus.Fields{
 		"type":  "stock order",
 		"count": orderCounter,
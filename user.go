@@ -101,6 +101,7 @@ func userAction(c *cli.Context) error {
 		)
 	}
 	defer tx.AutoRollback()
+	report := newChangeReport(c)
 	// read the file and insert record as needed
 	for {
 		record, err := r.Read()
@@ -119,9 +120,17 @@ func userAction(c *cli.Context) error {
 		}
 		allRecords = append(allRecords, record)
 		idsDelete = append(idsDelete, id)
+		report.sample("auth_user", changeUpsert, map[string]interface{}{
+			"auth_user_id": id,
+			"email":        record[0],
+			"first_name":   record[1],
+			"last_name":    record[2],
+			"is_active":    getActiveStatus(record),
+		})
 	}
 	log.Infof("upserted %d records", len(allRecords))
-	if err := deleteAllUsersInfo(tx, idsDelete, log); err != nil {
+	report.add("auth_user", changeUpsert, int64(len(allRecords)))
+	if err := deleteAllUsersInfo(tx, idsDelete, report, log); err != nil {
 		return err
 	}
 	builder := tx.InsertInto("auth_user_info").
@@ -137,7 +146,9 @@ func userAction(c *cli.Context) error {
 			"auth_user_id",
 		)
 	for i, r := range allRecords {
-		builder.Record(newUserInfo(r, idsDelete[i]))
+		uInfo := newUserInfo(r, idsDelete[i])
+		builder.Record(uInfo)
+		report.sample("auth_user_info", changeInsert, uInfo)
 	}
 	res, err := builder.Exec()
 	if err != nil {
@@ -149,18 +160,37 @@ func userAction(c *cli.Context) error {
 			2,
 		)
 	}
-	err = tx.Commit()
+	report.add("auth_user_info", changeInsert, res.RowsAffected)
+	err = commitTx(c, tx, report, log)
 	if err != nil {
 		return cli.NewExitError(
 			fmt.Sprintf("error in commiting %s", err),
 			2,
 		)
 	}
+	addRowCount(c, "auth_user", int64(len(allRecords)))
+	addRowCount(c, "auth_user_info", res.RowsAffected)
 	log.Infof("inserted %d user information", res.RowsAffected)
 	return nil
 }
 
-func deleteAllUsersInfo(tx *runner.Tx, ids []int64, log *logrus.Logger) error {
+func deleteAllUsersInfo(tx *runner.Tx, ids []int64, report *changeReport, log *logrus.Logger) error {
+	if report.dryRun {
+		var infos []*UserInfo
+		err := tx.Select("*").From("auth_user_info").
+			Where("auth_user_id IN $1", ids).
+			Limit(sampleSize).
+			QueryStructs(&infos)
+		if err != nil && err != dat.ErrNotFound {
+			return cli.NewExitError(
+				fmt.Sprintf("error in querying user information %s", err),
+				2,
+			)
+		}
+		for _, i := range infos {
+			report.sample("auth_user_info", changeDelete, i)
+		}
+	}
 	res, err := tx.DeleteFrom("auth_user_info").Where("auth_user_id IN $1", ids).Exec()
 	if err != nil {
 		log.WithFields(logrus.Fields{
@@ -173,6 +203,7 @@ func deleteAllUsersInfo(tx *runner.Tx, ids []int64, log *logrus.Logger) error {
 		)
 	}
 	log.Infof("deleted %d records", res.RowsAffected)
+	report.add("auth_user_info", changeDelete, res.RowsAffected)
 	return nil
 }
 